@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net/http/httptest"
@@ -13,6 +14,7 @@ import (
 	"photoo/internal/library"
 	"photoo/internal/models"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
@@ -26,6 +28,11 @@ type App struct {
 	thumbH   *library.ThumbnailHandler
 	uiLogs   []string
 	uiErrors []string
+
+	importMu      sync.Mutex
+	importWorkers int
+	cancelImport  context.CancelFunc
+	stopWatch     context.CancelFunc
 }
 
 // NewApp creates a new App application struct
@@ -103,6 +110,16 @@ func (a *App) startup(ctx context.Context) {
 			log.Fatal(err)
 		}
 		a.manager = manager
+
+		// No import can be running yet, so any session still marked as
+		// running was cut short by a crash.
+		if err := manager.RecoverImportSessions(); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if a.stopWatch == nil {
+		a.startWatch()
 	}
 
 	if os.Getenv("PHOTOO_SELF_TEST") == "true" {
@@ -136,6 +153,9 @@ func (a *App) runSelfTest() {
 	a.SendCommand("inspect_thumbnails", nil)
 }
 func (a *App) shutdown(ctx context.Context) {
+	if a.stopWatch != nil {
+		a.stopWatch()
+	}
 	if a.db != nil {
 		a.db.Close()
 	}
@@ -146,24 +166,53 @@ func (a *App) GetPhotos() ([]models.Photo, error) {
 	return a.GetPhotosPaged(0, 1000000)
 }
 
+// GetPhoto returns a single photo, e.g. to refresh it after an edit moved it
+func (a *App) GetPhoto(id int64) (*models.Photo, error) {
+	return a.manager.GetPhoto(id)
+}
+
 // GetPhotosPaged returns a page of photos from the database
 func (a *App) GetPhotosPaged(offset, limit int) ([]models.Photo, error) {
-	rows, err := a.db.Query("SELECT id, original_path, library_path, filename, hash, date_taken, camera_model, latitude, longitude, import_date FROM photos ORDER BY date_taken DESC LIMIT ? OFFSET ?", limit, offset)
+	rows, err := a.db.Query("SELECT "+library.PhotoColumns+" FROM photos ORDER BY date_taken DESC LIMIT ? OFFSET ?", limit, offset)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return library.ScanPhotos(rows)
+}
 
-	var photos []models.Photo
-	for rows.Next() {
-		var p models.Photo
-		err := rows.Scan(&p.ID, &p.OriginalPath, &p.LibraryPath, &p.Filename, &p.Hash, &p.DateTaken, &p.CameraModel, &p.Latitude, &p.Longitude, &p.ImportDate)
-		if err != nil {
-			return nil, err
-		}
-		photos = append(photos, p)
+// GetPhotosPage returns a page of photos using an opaque cursor from a
+// previous page ("" for the first page). Unlike GetPhotosPaged, pages stay
+// consistent while an import inserts photos.
+func (a *App) GetPhotosPage(cursor string, limit int) (*library.PhotoPage, error) {
+	return a.manager.PhotosPage(cursor, limit)
+}
+
+// GetTimelineBuckets returns photo counts per "year", "month" or "day",
+// newest first, with the first photo and the scroll offset of each bucket
+func (a *App) GetTimelineBuckets(granularity string) ([]models.TimelineBucket, error) {
+	return a.manager.TimelineBuckets(granularity)
+}
+
+// GetBucketPhotos returns a page of photos from one timeline bucket
+func (a *App) GetBucketPhotos(key string, offset, limit int) ([]models.Photo, error) {
+	return a.manager.BucketPhotos(key, offset, limit)
+}
+
+// SearchPhotos returns the photos matching a structured filter
+func (a *App) SearchPhotos(filter library.PhotoFilter) ([]models.Photo, error) {
+	return a.manager.SearchPhotos(filter)
+}
+
+// SearchPhotosByQuery parses a textual query such as
+// camera:"Pixel 7" date:2023-06..2023-08 and returns a page of matches
+func (a *App) SearchPhotosByQuery(query string, offset, limit int) ([]models.Photo, error) {
+	filter, err := library.ParseQuery(query)
+	if err != nil {
+		return nil, err
 	}
-	return photos, nil
+	filter.Offset = offset
+	filter.Limit = limit
+	return a.manager.SearchPhotos(filter)
 }
 
 // SelectFolder opens a dialog to select a folder
@@ -179,91 +228,377 @@ func (a *App) ImportFromFolder(folderPath string) (int, error) {
 		return 0, fmt.Errorf("no folder selected")
 	}
 
-	// 1. Initial pass to count total candidate files
-	var totalCandidates int
-	filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
-			return nil
-		}
-		ext := strings.ToLower(filepath.Ext(path))
-		if ext == ".jpg" || ext == ".png" || ext == ".heic" {
-			totalCandidates++
-		}
-		return nil
+	return a.runImport(func(ctx context.Context, opts library.ImportOptions) (library.ImportProgress, error) {
+		return a.manager.ImportFolder(ctx, folderPath, opts)
 	})
+}
 
+// SelectArchives opens a dialog to select one or more archives, e.g. all
+// parts of a Google Takeout export
+func (a *App) SelectArchives() ([]string, error) {
+	return runtime.OpenMultipleFilesDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "Select Archives to Import Photos",
+		Filters: []runtime.FileFilter{
+			{DisplayName: "Archives (*.zip, *.tgz, *.tar.gz)", Pattern: "*.zip;*.tgz;*.tar.gz;*.tar"},
+		},
+	})
+}
+
+// ImportFromArchive imports the photos in zip or tar.gz archives without
+// extracting them first. All parts of a multi-part export should be passed
+// together so that sidecars in other parts are found.
+func (a *App) ImportFromArchive(paths []string) (int, error) {
+	if len(paths) == 0 {
+		return 0, fmt.Errorf("no archive selected")
+	}
+
+	return a.runImport(func(ctx context.Context, opts library.ImportOptions) (library.ImportProgress, error) {
+		return a.manager.ImportArchives(ctx, paths, opts)
+	})
+}
+
+// ResumeImport continues a cancelled or interrupted import session
+func (a *App) ResumeImport(sessionID int64) (int, error) {
+	return a.runImport(func(ctx context.Context, opts library.ImportOptions) (library.ImportProgress, error) {
+		return a.manager.ResumeImport(ctx, sessionID, opts)
+	})
+}
+
+// ListImportSessions returns all past and running import sessions
+func (a *App) ListImportSessions() ([]models.ImportSession, error) {
+	return a.manager.ListImportSessions()
+}
+
+// GetImportSessionItems returns the files of an import session, optionally
+// filtered by status (pending, imported, duplicate, error)
+func (a *App) GetImportSessionItems(sessionID int64, status string) ([]models.ImportSessionItem, error) {
+	return a.manager.GetImportSessionItems(sessionID, status)
+}
+
+// ListDuplicateGroups returns the groups of photos that look the same
+func (a *App) ListDuplicateGroups() ([]models.DuplicateGroup, error) {
+	return a.manager.ListDuplicateGroups()
+}
+
+// SetDuplicateKeeper records which photo of a duplicate group to keep
+func (a *App) SetDuplicateKeeper(groupID, photoID int64) error {
+	return a.manager.SetDuplicateKeeper(groupID, photoID)
+}
+
+// ResolveDuplicateGroup keeps the best photo of a duplicate group, merges
+// the metadata of the others into it and moves them to the trash, as one
+// undoable step
+func (a *App) ResolveDuplicateGroup(groupID int64) (*models.Photo, error) {
+	return a.manager.ResolveDuplicateGroup(groupID)
+}
+
+// ListDuplicateHits returns the files skipped as exact duplicates of library
+// photos, for one import session or all of them if sessionID is 0
+func (a *App) ListDuplicateHits(sessionID int64) ([]models.DuplicateHit, error) {
+	return a.manager.ListDuplicateHits(sessionID)
+}
+
+// runImport runs an import with progress events and cancellation support.
+func (a *App) runImport(run func(context.Context, library.ImportOptions) (library.ImportProgress, error)) (int, error) {
+	ctx, err := a.beginImport()
+	if err != nil {
+		return 0, err
+	}
+	defer a.endImport()
+
+	a.importMu.Lock()
+	workers := a.importWorkers
+	a.importMu.Unlock()
+
+	progress, err := run(ctx, library.ImportOptions{
+		Workers:    workers,
+		OnStart:    a.emitImportStart,
+		OnProgress: a.emitImportProgress,
+	})
+	cancelled := errors.Is(err, context.Canceled)
+	a.emitImportEnd(progress, cancelled)
+
+	if cancelled {
+		return progress.Imported, nil
+	}
+	return progress.Imported, err
+}
+
+func (a *App) emitImportStart(total int) {
 	if a.ctx != nil {
 		runtime.EventsEmit(a.ctx, "import:start", map[string]interface{}{
-			"total": totalCandidates,
+			"total": total,
 		})
 	}
+}
 
-	count := 0
-	duplicates := 0
-	errors := 0
+func (a *App) emitImportProgress(p library.ImportProgress) {
+	if a.ctx != nil {
+		runtime.EventsEmit(a.ctx, "import:progress", map[string]interface{}{
+			"sessionId":      p.SessionID,
+			"staged":         p.Staged,
+			"current":        p.Current,
+			"total":          p.Total,
+			"imported":       p.Imported,
+			"duplicates":     p.Duplicates,
+			"errors":         p.Errors,
+			"lastPath":       p.LastPath,
+			"filesPerSecond": p.FilesPerSecond,
+			"bytesPerSecond": p.BytesPerSecond,
+			"etaSeconds":     p.ETA.Seconds(),
+		})
+	}
+}
 
-	err := filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
+func (a *App) emitImportEnd(p library.ImportProgress, cancelled bool) {
+	if a.ctx != nil {
+		runtime.EventsEmit(a.ctx, "import:end", map[string]interface{}{
+			"sessionId":  p.SessionID,
+			"imported":   p.Imported,
+			"duplicates": p.Duplicates,
+			"errors":     p.Errors,
+			"total":      p.Total,
+			"cancelled":  cancelled,
+		})
+	}
+}
 
-		// Only import JPG/PNG/HEIC
-		ext := strings.ToLower(filepath.Ext(path))
-		if ext != ".jpg" && ext != ".png" && ext != ".heic" {
-			return nil
-		}
+// CancelImport stops the running import. Files already being copied are
+// finished; the remaining ones are skipped.
+func (a *App) CancelImport() {
+	a.importMu.Lock()
+	defer a.importMu.Unlock()
+	if a.cancelImport != nil {
+		a.cancelImport()
+	}
+}
 
-		_, err = a.manager.ImportPhoto(path)
-		if err == nil {
-			count++
-		} else if strings.Contains(err.Error(), "duplicate photo detected") {
-			duplicates++
-		} else {
-			errors++
-			fmt.Printf("[BACKEND] Import error for %s: %v\n", path, err)
-		}
+// SetImportWorkers sets the number of parallel import workers.
+// Zero or less uses one worker per CPU.
+func (a *App) SetImportWorkers(workers int) {
+	a.importMu.Lock()
+	defer a.importMu.Unlock()
+	a.importWorkers = workers
+}
 
-		// Emit progress
+// GetNamingTemplate returns the template imported photos are named and
+// filed with
+func (a *App) GetNamingTemplate() string {
+	return a.manager.NamingTemplate()
+}
+
+// SetNamingTemplate validates and saves the template for photos imported
+// from now on
+func (a *App) SetNamingTemplate(template string) error {
+	return a.manager.SetNamingTemplate(template)
+}
+
+// ReorganizeLibrary moves every photo to match template, saving it as the
+// naming template, with progress events. A dry run only returns the planned
+// moves. It cannot run while an import is running, and CancelImport stops
+// it. Undo moves the photos back.
+func (a *App) ReorganizeLibrary(template string, dryRun bool) ([]library.ReorganizeMove, error) {
+	ctx, err := a.beginImport()
+	if err != nil {
+		return nil, err
+	}
+	defer a.endImport()
+
+	var last library.ReorganizeProgress
+	moves, err := a.manager.ReorganizeLibrary(ctx, template, dryRun, func(p library.ReorganizeProgress) {
+		last = p
 		if a.ctx != nil {
-			runtime.EventsEmit(a.ctx, "import:progress", map[string]interface{}{
-				"current":    count + duplicates + errors,
-				"total":      totalCandidates,
-				"imported":   count,
-				"duplicates": duplicates,
-				"errors":     errors,
-				"lastPath":   filepath.Base(path),
+			runtime.EventsEmit(a.ctx, "reorganize:progress", map[string]interface{}{
+				"current":  p.Current,
+				"total":    p.Total,
+				"moved":    p.Moved,
+				"errors":   p.Errors,
+				"lastPath": p.LastPath,
+				"dryRun":   dryRun,
 			})
 		}
-		return nil
 	})
+	if a.ctx != nil {
+		runtime.EventsEmit(a.ctx, "reorganize:end", map[string]interface{}{
+			"moved":     last.Moved,
+			"errors":    last.Errors,
+			"total":     last.Total,
+			"dryRun":    dryRun,
+			"cancelled": errors.Is(err, context.Canceled),
+		})
+	}
+	return moves, err
+}
 
+// GetImportMode returns how files are brought into the library: copy,
+// move, hardlink or reflink
+func (a *App) GetImportMode() string {
+	return a.manager.ImportMode()
+}
+
+// SetImportMode sets how files are brought into the library: copy, move,
+// hardlink or reflink
+func (a *App) SetImportMode(mode string) error {
+	return a.manager.SetImportMode(mode)
+}
+
+// startWatch imports new files from the watch folders in the background,
+// reporting each batch with the usual import events and problems with a
+// watch:error event. Batches count as imports: they wait while another
+// import or a reorganization runs, and CancelImport stops them. If watching
+// fails, it is retried with a growing delay.
+func (a *App) startWatch() {
+	ctx, cancel := context.WithCancel(a.ctx)
+	a.stopWatch = cancel
+	opts := library.WatchOptions{
+		BeginBatch: a.beginImport,
+		EndBatch:   a.endImport,
+		OnStart:    a.emitImportStart,
+		OnProgress: a.emitImportProgress,
+		OnEnd: func(p library.ImportProgress) {
+			// Only cancellation cuts a batch short
+			a.emitImportEnd(p, p.Current < p.Total)
+		},
+		OnError: a.emitWatchError,
+	}
+	go func() {
+		delay := time.Second
+		for {
+			started := time.Now()
+			err := a.manager.Watch(ctx, opts)
+			if ctx.Err() != nil {
+				return
+			}
+			if time.Since(started) > time.Minute {
+				delay = time.Second
+			}
+			a.emitWatchError(fmt.Errorf("watch folders stopped, retrying in %s: %w", delay, err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(2*delay, time.Minute)
+		}
+	}()
+}
+
+func (a *App) emitWatchError(err error) {
+	log.Println(err)
 	if a.ctx != nil {
-		runtime.EventsEmit(a.ctx, "import:end", map[string]interface{}{
-			"imported":   count,
-			"duplicates": duplicates,
-			"errors":     errors,
-			"total":      totalCandidates,
+		runtime.EventsEmit(a.ctx, "watch:error", map[string]interface{}{
+			"message": err.Error(),
 		})
 	}
+}
+
+// ListWatchFolders returns the folders new files are imported from
+// automatically
+func (a *App) ListWatchFolders() ([]string, error) {
+	return a.manager.WatchFolders()
+}
 
-	return count, err
+// AddWatchFolder starts importing new files that appear in folder
+func (a *App) AddWatchFolder(folder string) error {
+	return a.manager.AddWatchFolder(folder)
+}
+
+// RemoveWatchFolder stops importing from folder
+func (a *App) RemoveWatchFolder(folder string) error {
+	return a.manager.RemoveWatchFolder(folder)
+}
+
+// SetWriteSidecars turns writing an XMP sidecar next to each edited photo
+// on or off. The choice is saved with the library.
+func (a *App) SetWriteSidecars(enabled bool) error {
+	return a.manager.SetWriteSidecars(enabled)
+}
+
+// beginImport registers a new cancellable import, refusing to start a second
+// one while another is running.
+func (a *App) beginImport() (context.Context, error) {
+	a.importMu.Lock()
+	defer a.importMu.Unlock()
+	if a.cancelImport != nil {
+		return nil, fmt.Errorf("an import is already running")
+	}
+
+	parent := a.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	a.cancelImport = cancel
+	return ctx, nil
+}
+
+func (a *App) endImport() {
+	a.importMu.Lock()
+	defer a.importMu.Unlock()
+	if a.cancelImport != nil {
+		a.cancelImport()
+		a.cancelImport = nil
+	}
 }
 
 // UpdatePhotoDate updates the capture date of a photo
 func (a *App) UpdatePhotoDate(photoID int64, newDate string) error {
-	parsedDate, err := time.Parse(time.RFC3339, newDate)
+	parsedDate, err := parseDateInput(newDate)
+	if err != nil {
+		return err
+	}
+
+	return a.manager.UpdateMetadata(photoID, "date_taken", parsedDate)
+}
+
+// UpdatePhotosDate sets the capture date of several photos as one undoable step
+func (a *App) UpdatePhotosDate(photoIDs []int64, newDate string) error {
+	parsedDate, err := parseDateInput(newDate)
+	if err != nil {
+		return err
+	}
+
+	changes := make([]library.MetadataChange, 0, len(photoIDs))
+	for _, id := range photoIDs {
+		changes = append(changes, library.MetadataChange{PhotoID: id, Field: "date_taken", Value: parsedDate})
+	}
+	_, err = a.manager.ApplyMetadataChanges(fmt.Sprintf("Change date of %d photos", len(photoIDs)), changes)
+	return err
+}
+
+// GetPhotoHistory returns the edit history of a photo, newest first
+func (a *App) GetPhotoHistory(photoID int64) ([]models.MetadataHistory, error) {
+	return a.manager.GetPhotoHistory(photoID)
+}
+
+// Undo reverts the last batch of metadata changes. Returns nil if there is
+// nothing to undo.
+func (a *App) Undo() (*models.EditBatch, error) {
+	return a.manager.Undo()
+}
+
+// Redo re-applies the last undone batch. Returns nil if there is nothing to redo.
+func (a *App) Redo() (*models.EditBatch, error) {
+	return a.manager.Redo()
+}
+
+// RevertPhotoHistory restores the value a field had before the given history entry
+func (a *App) RevertPhotoHistory(historyID int64) error {
+	return a.manager.RevertMetadata(historyID)
+}
+
+// parseDateInput accepts RFC3339 and the datetime-local input format. Only
+// the wall clock is used: the photo keeps the zone it was taken in.
+func parseDateInput(value string) (time.Time, error) {
+	parsedDate, err := time.Parse(time.RFC3339, value)
 	if err != nil {
 		// Try other formats if RFC3339 fails (e.g. from datetime-local input)
-		parsedDate, err = time.Parse("2006-01-02T15:04", newDate)
+		parsedDate, err = time.ParseInLocation("2006-01-02T15:04", value, time.Local)
 		if err != nil {
-			return fmt.Errorf("invalid date format: %w", err)
+			return time.Time{}, fmt.Errorf("invalid date format: %w", err)
 		}
 	}
-
-	return a.manager.UpdateMetadata(photoID, "date_taken", parsedDate)
+	return parsedDate, nil
 }
 
 // LogFrontendError allows the frontend to log errors to the Go terminal
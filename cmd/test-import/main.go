@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -41,12 +42,15 @@ func main() {
 		}
 
 		fmt.Printf("Importing: %s...\n", path)
-		photo, err := manager.ImportPhoto(path)
-		if err != nil {
+		result, err := manager.ImportPhoto(path)
+		if errors.Is(err, library.ErrDuplicate) {
+			fmt.Printf("  Skipped %s: already in library as photo %d\n", path, result.DuplicateOf)
+			return nil
+		} else if err != nil {
 			fmt.Printf("  Error importing %s: %v\n", path, err)
 			return nil
 		}
-		fmt.Printf("  Success! Filename in library: %s (Date: %s)\n", photo.Filename, photo.DateTaken)
+		fmt.Printf("  Success! Filename in library: %s (Date: %s)\n", result.Photo.Filename, result.Photo.DateTaken)
 		return nil
 	})
 
@@ -5,9 +5,11 @@ import { GetPhotosPaged } from '../wailsjs/go/main/App';
 
 // Mock Wails runtime calls
 vi.mock('../wailsjs/go/main/App', () => ({
+  GetPhoto: vi.fn(),
   GetPhotosPaged: vi.fn(),
   SelectFolder: vi.fn(),
   ImportFromFolder: vi.fn(),
+  CancelImport: vi.fn(),
   UpdatePhotoDate: vi.fn(),
   LogFrontendError: vi.fn(),
   LogUIState: vi.fn(),
@@ -1,6 +1,6 @@
 import {useState, useEffect} from 'react';
 import './App.css';
-import {GetPhotosPaged, SelectFolder, ImportFromFolder, UpdatePhotoDate, LogFrontendError, LogUIState} from "../wailsjs/go/main/App";
+import {GetPhoto, GetPhotosPaged, SelectFolder, ImportFromFolder, CancelImport, UpdatePhotoDate, LogFrontendError, LogUIState} from "../wailsjs/go/main/App";
 import {models} from "../wailsjs/go/models";
 
 // Declare global Events interface for Wails runtime
@@ -26,6 +26,8 @@ function App() {
         duplicates: 0,
         errors: 0,
         lastPath: "",
+        filesPerSecond: 0,
+        etaSeconds: 0,
         isVisible: false
     });
     const [selectedPhoto, setSelectedPhoto] = useState<models.Photo | null>(null);
@@ -132,6 +134,8 @@ function App() {
                     duplicates: 0,
                     errors: 0,
                     lastPath: "Initializing...",
+                    filesPerSecond: 0,
+                    etaSeconds: 0,
                     isVisible: true
                 });
                 setIsImporting(true);
@@ -144,7 +148,9 @@ function App() {
                     imported: data.imported,
                     duplicates: data.duplicates,
                     errors: data.errors,
-                    lastPath: data.lastPath
+                    lastPath: data.lastPath,
+                    filesPerSecond: data.filesPerSecond,
+                    etaSeconds: data.etaSeconds
                 }));
             });
 
@@ -155,6 +161,10 @@ function App() {
                     loadPhotos(true);
                 }, 2000); // Keep visible for 2 seconds to show final stats
             });
+
+            window.runtime.EventsOn("watch:error", (data: any) => {
+                console.error("Watch folder error:", data.message);
+            });
         }
 
         // Automated Sanity Monitor (The "Eyes")
@@ -215,11 +225,8 @@ function App() {
             await UpdatePhotoDate(selectedPhoto.id, editDate);
             setIsEditing(false);
             loadPhotos(true);
-            // Update local selection to reflect change
-            setSelectedPhoto(models.Photo.createFrom({
-                ...selectedPhoto,
-                date_taken: new Date(editDate).toISOString()
-            }));
+            // A new date moves the photo, so reload it for its new filename
+            setSelectedPhoto(await GetPhoto(selectedPhoto.id));
         } catch (error) {
             console.error("Failed to update date:", error);
             alert("Failed to update date");
@@ -346,6 +353,10 @@ function App() {
                         <div className="progress-last-path">
                             {importStatus.lastPath}
                         </div>
+                        <div className="progress-throughput">
+                            {importStatus.filesPerSecond.toFixed(1)} files/s, ~{Math.ceil(importStatus.etaSeconds)}s remaining
+                        </div>
+                        <button className="btn-cancel" onClick={() => CancelImport()}>Cancel</button>
                     </div>
                 </div>
             )}
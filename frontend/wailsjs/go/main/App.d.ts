@@ -3,26 +3,88 @@
 import {models} from '../models';
 import {library} from '../models';
 
+export function AddWatchFolder(arg1:string):Promise<void>;
+
+export function CancelImport():Promise<void>;
+
 export function GetAutomationLogs():Promise<Record<string, any>>;
 
+export function GetBucketPhotos(arg1:string,arg2:number,arg3:number):Promise<Array<models.Photo>>;
+
 export function GetDiagnostics():Promise<Record<string, any>>;
 
+export function GetImportMode():Promise<string>;
+
+export function GetImportSessionItems(arg1:number,arg2:string):Promise<Array<models.ImportSessionItem>>;
+
+export function GetNamingTemplate():Promise<string>;
+
+export function GetPhoto(arg1:number):Promise<models.Photo>;
+
+export function GetPhotoHistory(arg1:number):Promise<Array<models.MetadataHistory>>;
+
 export function GetPhotos():Promise<Array<models.Photo>>;
 
+export function GetPhotosPage(arg1:string,arg2:number):Promise<library.PhotoPage>;
+
 export function GetPhotosPaged(arg1:number,arg2:number):Promise<Array<models.Photo>>;
 
 export function GetThumbnail(arg1:string):Promise<string>;
 
+export function GetTimelineBuckets(arg1:string):Promise<Array<models.TimelineBucket>>;
+
+export function ImportFromArchive(arg1:Array<string>):Promise<number>;
+
 export function ImportFromFolder(arg1:string):Promise<number>;
 
+export function ListDuplicateGroups():Promise<Array<models.DuplicateGroup>>;
+
+export function ListDuplicateHits(arg1:number):Promise<Array<models.DuplicateHit>>;
+
+export function ListImportSessions():Promise<Array<models.ImportSession>>;
+
+export function ListWatchFolders():Promise<Array<string>>;
+
 export function LogFrontendError(arg1:string):Promise<void>;
 
 export function LogUIState(arg1:string):Promise<void>;
 
+export function Redo():Promise<models.EditBatch>;
+
+export function RemoveWatchFolder(arg1:string):Promise<void>;
+
+export function ReorganizeLibrary(arg1:string,arg2:boolean):Promise<Array<library.ReorganizeMove>>;
+
+export function ResolveDuplicateGroup(arg1:number):Promise<models.Photo>;
+
+export function ResumeImport(arg1:number):Promise<number>;
+
+export function RevertPhotoHistory(arg1:number):Promise<void>;
+
+export function SearchPhotos(arg1:library.PhotoFilter):Promise<Array<models.Photo>>;
+
+export function SearchPhotosByQuery(arg1:string,arg2:number,arg3:number):Promise<Array<models.Photo>>;
+
+export function SelectArchives():Promise<Array<string>>;
+
 export function SelectFolder():Promise<string>;
 
 export function SendCommand(arg1:string,arg2:any):Promise<void>;
 
+export function SetDuplicateKeeper(arg1:number,arg2:number):Promise<void>;
+
+export function SetImportMode(arg1:string):Promise<void>;
+
+export function SetImportWorkers(arg1:number):Promise<void>;
+
+export function SetNamingTemplate(arg1:string):Promise<void>;
+
 export function SetThumbnailHandler(arg1:library.ThumbnailHandler):Promise<void>;
 
+export function SetWriteSidecars(arg1:boolean):Promise<void>;
+
+export function Undo():Promise<models.EditBatch>;
+
 export function UpdatePhotoDate(arg1:number,arg2:string):Promise<void>;
+
+export function UpdatePhotosDate(arg1:Array<number>,arg2:string):Promise<void>;
@@ -2,18 +2,54 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function AddWatchFolder(arg1) {
+  return window['go']['main']['App']['AddWatchFolder'](arg1);
+}
+
+export function CancelImport() {
+  return window['go']['main']['App']['CancelImport']();
+}
+
 export function GetAutomationLogs() {
   return window['go']['main']['App']['GetAutomationLogs']();
 }
 
+export function GetBucketPhotos(arg1, arg2, arg3) {
+  return window['go']['main']['App']['GetBucketPhotos'](arg1, arg2, arg3);
+}
+
 export function GetDiagnostics() {
   return window['go']['main']['App']['GetDiagnostics']();
 }
 
+export function GetImportMode() {
+  return window['go']['main']['App']['GetImportMode']();
+}
+
+export function GetImportSessionItems(arg1, arg2) {
+  return window['go']['main']['App']['GetImportSessionItems'](arg1, arg2);
+}
+
+export function GetNamingTemplate() {
+  return window['go']['main']['App']['GetNamingTemplate']();
+}
+
+export function GetPhoto(arg1) {
+  return window['go']['main']['App']['GetPhoto'](arg1);
+}
+
+export function GetPhotoHistory(arg1) {
+  return window['go']['main']['App']['GetPhotoHistory'](arg1);
+}
+
 export function GetPhotos() {
   return window['go']['main']['App']['GetPhotos']();
 }
 
+export function GetPhotosPage(arg1, arg2) {
+  return window['go']['main']['App']['GetPhotosPage'](arg1, arg2);
+}
+
 export function GetPhotosPaged(arg1, arg2) {
   return window['go']['main']['App']['GetPhotosPaged'](arg1, arg2);
 }
@@ -22,10 +58,34 @@ export function GetThumbnail(arg1) {
   return window['go']['main']['App']['GetThumbnail'](arg1);
 }
 
+export function GetTimelineBuckets(arg1) {
+  return window['go']['main']['App']['GetTimelineBuckets'](arg1);
+}
+
+export function ImportFromArchive(arg1) {
+  return window['go']['main']['App']['ImportFromArchive'](arg1);
+}
+
 export function ImportFromFolder(arg1) {
   return window['go']['main']['App']['ImportFromFolder'](arg1);
 }
 
+export function ListDuplicateGroups() {
+  return window['go']['main']['App']['ListDuplicateGroups']();
+}
+
+export function ListDuplicateHits(arg1) {
+  return window['go']['main']['App']['ListDuplicateHits'](arg1);
+}
+
+export function ListImportSessions() {
+  return window['go']['main']['App']['ListImportSessions']();
+}
+
+export function ListWatchFolders() {
+  return window['go']['main']['App']['ListWatchFolders']();
+}
+
 export function LogFrontendError(arg1) {
   return window['go']['main']['App']['LogFrontendError'](arg1);
 }
@@ -34,6 +94,42 @@ export function LogUIState(arg1) {
   return window['go']['main']['App']['LogUIState'](arg1);
 }
 
+export function Redo() {
+  return window['go']['main']['App']['Redo']();
+}
+
+export function RemoveWatchFolder(arg1) {
+  return window['go']['main']['App']['RemoveWatchFolder'](arg1);
+}
+
+export function ReorganizeLibrary(arg1, arg2) {
+  return window['go']['main']['App']['ReorganizeLibrary'](arg1, arg2);
+}
+
+export function ResolveDuplicateGroup(arg1) {
+  return window['go']['main']['App']['ResolveDuplicateGroup'](arg1);
+}
+
+export function ResumeImport(arg1) {
+  return window['go']['main']['App']['ResumeImport'](arg1);
+}
+
+export function RevertPhotoHistory(arg1) {
+  return window['go']['main']['App']['RevertPhotoHistory'](arg1);
+}
+
+export function SearchPhotos(arg1) {
+  return window['go']['main']['App']['SearchPhotos'](arg1);
+}
+
+export function SearchPhotosByQuery(arg1, arg2, arg3) {
+  return window['go']['main']['App']['SearchPhotosByQuery'](arg1, arg2, arg3);
+}
+
+export function SelectArchives() {
+  return window['go']['main']['App']['SelectArchives']();
+}
+
 export function SelectFolder() {
   return window['go']['main']['App']['SelectFolder']();
 }
@@ -42,10 +138,38 @@ export function SendCommand(arg1, arg2) {
   return window['go']['main']['App']['SendCommand'](arg1, arg2);
 }
 
+export function SetDuplicateKeeper(arg1, arg2) {
+  return window['go']['main']['App']['SetDuplicateKeeper'](arg1, arg2);
+}
+
+export function SetImportMode(arg1) {
+  return window['go']['main']['App']['SetImportMode'](arg1);
+}
+
+export function SetImportWorkers(arg1) {
+  return window['go']['main']['App']['SetImportWorkers'](arg1);
+}
+
+export function SetNamingTemplate(arg1) {
+  return window['go']['main']['App']['SetNamingTemplate'](arg1);
+}
+
 export function SetThumbnailHandler(arg1) {
   return window['go']['main']['App']['SetThumbnailHandler'](arg1);
 }
 
+export function SetWriteSidecars(arg1) {
+  return window['go']['main']['App']['SetWriteSidecars'](arg1);
+}
+
+export function Undo() {
+  return window['go']['main']['App']['Undo']();
+}
+
 export function UpdatePhotoDate(arg1, arg2) {
   return window['go']['main']['App']['UpdatePhotoDate'](arg1, arg2);
 }
+
+export function UpdatePhotosDate(arg1, arg2) {
+  return window['go']['main']['App']['UpdatePhotosDate'](arg1, arg2);
+}
@@ -1,5 +1,161 @@
 export namespace library {
 	
+	export class BoundingBox {
+	    min_lat: number;
+	    min_lon: number;
+	    max_lat: number;
+	    max_lon: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new BoundingBox(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.min_lat = source["min_lat"];
+	        this.min_lon = source["min_lon"];
+	        this.max_lat = source["max_lat"];
+	        this.max_lon = source["max_lon"];
+	    }
+	}
+	export class PhotoFilter {
+	    // Go type: time
+	    date_from?: any;
+	    // Go type: time
+	    date_to?: any;
+	    camera_make?: string;
+	    camera_model?: string;
+	    lens_model?: string;
+	    f_number?: Range;
+	    exposure_time?: Range;
+	    iso?: Range;
+	    focal_length?: Range;
+	    rating?: Range;
+	    keywords?: string[];
+	    people?: string[];
+	    favorite?: boolean;
+	    media_type?: string;
+	    has_gps?: boolean;
+	    bounding_box?: BoundingBox;
+	    filename?: string;
+	    session_id?: number;
+	    sort?: string;
+	    offset: number;
+	    limit: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new PhotoFilter(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.date_from = this.convertValues(source["date_from"], null);
+	        this.date_to = this.convertValues(source["date_to"], null);
+	        this.camera_make = source["camera_make"];
+	        this.camera_model = source["camera_model"];
+	        this.lens_model = source["lens_model"];
+	        this.f_number = this.convertValues(source["f_number"], Range);
+	        this.exposure_time = this.convertValues(source["exposure_time"], Range);
+	        this.iso = this.convertValues(source["iso"], Range);
+	        this.focal_length = this.convertValues(source["focal_length"], Range);
+	        this.rating = this.convertValues(source["rating"], Range);
+	        this.keywords = source["keywords"];
+	        this.people = source["people"];
+	        this.favorite = source["favorite"];
+	        this.media_type = source["media_type"];
+	        this.has_gps = source["has_gps"];
+	        this.bounding_box = this.convertValues(source["bounding_box"], BoundingBox);
+	        this.filename = source["filename"];
+	        this.session_id = source["session_id"];
+	        this.sort = source["sort"];
+	        this.offset = source["offset"];
+	        this.limit = source["limit"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class PhotoPage {
+	    photos: models.Photo[];
+	    next_cursor: string;
+	    prev_cursor: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new PhotoPage(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.photos = this.convertValues(source["photos"], models.Photo);
+	        this.next_cursor = source["next_cursor"];
+	        this.prev_cursor = source["prev_cursor"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Range {
+	    min?: number;
+	    max?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Range(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.min = source["min"];
+	        this.max = source["max"];
+	    }
+	}
+	export class ReorganizeMove {
+	    photo_id: number;
+	    from: string;
+	    to: string;
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ReorganizeMove(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.photo_id = source["photo_id"];
+	        this.from = source["from"];
+	        this.to = source["to"];
+	        this.error = source["error"];
+	    }
+	}
 	export class ThumbnailHandler {
 	    History: string[];
 	
@@ -17,6 +173,261 @@ export namespace library {
 
 export namespace models {
 	
+	export class DuplicateGroup {
+	    id: number;
+	    keeper_id?: number;
+	    // Go type: time
+	    created_at: any;
+	    photos: Photo[];
+	
+	    static createFrom(source: any = {}) {
+	        return new DuplicateGroup(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.keeper_id = source["keeper_id"];
+	        this.created_at = this.convertValues(source["created_at"], null);
+	        this.photos = this.convertValues(source["photos"], Photo);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class DuplicateHit {
+	    id: number;
+	    source_path: string;
+	    hash: string;
+	    photo_id?: number;
+	    session_id?: number;
+	    // Go type: time
+	    detected_at: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new DuplicateHit(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.source_path = source["source_path"];
+	        this.hash = source["hash"];
+	        this.photo_id = source["photo_id"];
+	        this.session_id = source["session_id"];
+	        this.detected_at = this.convertValues(source["detected_at"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class EditBatch {
+	    id: number;
+	    description: string;
+	    state: string;
+	    // Go type: time
+	    created_at: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new EditBatch(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.description = source["description"];
+	        this.state = source["state"];
+	        this.created_at = this.convertValues(source["created_at"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ImportSession {
+	    id: number;
+	    source_path: string;
+	    status: string;
+	    // Go type: time
+	    started_at: any;
+	    // Go type: time
+	    finished_at?: any;
+	    total: number;
+	    pending: number;
+	    imported: number;
+	    duplicates: number;
+	    errors: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new ImportSession(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.source_path = source["source_path"];
+	        this.status = source["status"];
+	        this.started_at = this.convertValues(source["started_at"], null);
+	        this.finished_at = this.convertValues(source["finished_at"], null);
+	        this.total = source["total"];
+	        this.pending = source["pending"];
+	        this.imported = source["imported"];
+	        this.duplicates = source["duplicates"];
+	        this.errors = source["errors"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ImportSessionItem {
+	    id: number;
+	    session_id: number;
+	    source_path: string;
+	    status: string;
+	    photo_id?: number;
+	    hash: string;
+	    message: string;
+	    // Go type: time
+	    processed_at?: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new ImportSessionItem(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.session_id = source["session_id"];
+	        this.source_path = source["source_path"];
+	        this.status = source["status"];
+	        this.photo_id = source["photo_id"];
+	        this.hash = source["hash"];
+	        this.message = source["message"];
+	        this.processed_at = this.convertValues(source["processed_at"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class MetadataHistory {
+	    id: number;
+	    photo_id: number;
+	    field_name: string;
+	    old_value: string;
+	    new_value: string;
+	    // Go type: time
+	    changed_at: any;
+	    batch_id: number;
+	    state: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new MetadataHistory(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.photo_id = source["photo_id"];
+	        this.field_name = source["field_name"];
+	        this.old_value = source["old_value"];
+	        this.new_value = source["new_value"];
+	        this.changed_at = this.convertValues(source["changed_at"], null);
+	        this.batch_id = source["batch_id"];
+	        this.state = source["state"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 	export class Photo {
 	    id: number;
 	    original_path: string;
@@ -25,9 +436,34 @@ export namespace models {
 	    hash: string;
 	    // Go type: time
 	    date_taken: any;
+	    // Go type: time
+	    date_taken_utc: any;
+	    utc_offset?: number;
+	    camera_make: string;
 	    camera_model: string;
+	    lens_model: string;
+	    f_number?: number;
+	    exposure_time?: number;
+	    iso?: number;
+	    focal_length?: number;
+	    orientation: number;
+	    width: number;
+	    height: number;
 	    latitude?: number;
 	    longitude?: number;
+	    altitude?: number;
+	    rating: number;
+	    title: string;
+	    description: string;
+	    keywords: string[];
+	    favorite: boolean;
+	    people: string[];
+	    media_type: string;
+	    duration?: number;
+	    content_identifier?: string;
+	    motion_type?: string;
+	    motion_filename?: string;
+	    alternate_filename?: string;
 	    // Go type: time
 	    import_date: any;
 	
@@ -43,9 +479,33 @@ export namespace models {
 	        this.filename = source["filename"];
 	        this.hash = source["hash"];
 	        this.date_taken = this.convertValues(source["date_taken"], null);
+	        this.date_taken_utc = this.convertValues(source["date_taken_utc"], null);
+	        this.utc_offset = source["utc_offset"];
+	        this.camera_make = source["camera_make"];
 	        this.camera_model = source["camera_model"];
+	        this.lens_model = source["lens_model"];
+	        this.f_number = source["f_number"];
+	        this.exposure_time = source["exposure_time"];
+	        this.iso = source["iso"];
+	        this.focal_length = source["focal_length"];
+	        this.orientation = source["orientation"];
+	        this.width = source["width"];
+	        this.height = source["height"];
 	        this.latitude = source["latitude"];
 	        this.longitude = source["longitude"];
+	        this.altitude = source["altitude"];
+	        this.rating = source["rating"];
+	        this.title = source["title"];
+	        this.description = source["description"];
+	        this.keywords = source["keywords"];
+	        this.favorite = source["favorite"];
+	        this.people = source["people"];
+	        this.media_type = source["media_type"];
+	        this.duration = source["duration"];
+	        this.content_identifier = source["content_identifier"];
+	        this.motion_type = source["motion_type"];
+	        this.motion_filename = source["motion_filename"];
+	        this.alternate_filename = source["alternate_filename"];
 	        this.import_date = this.convertValues(source["import_date"], null);
 	    }
 	
@@ -67,6 +527,30 @@ export namespace models {
 		    return a;
 		}
 	}
+	export class TimelineBucket {
+	    key: string;
+	    year: number;
+	    month?: number;
+	    day?: number;
+	    count: number;
+	    first_photo_id: number;
+	    offset: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new TimelineBucket(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.key = source["key"];
+	        this.year = source["year"];
+	        this.month = source["month"];
+	        this.day = source["day"];
+	        this.count = source["count"];
+	        this.first_photo_id = source["first_photo_id"];
+	        this.offset = source["offset"];
+	    }
+	}
 
 }
 
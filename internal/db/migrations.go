@@ -0,0 +1,403 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// migration upgrades the schema by exactly one version. Migrations run in
+// their own transaction and must never be edited once released; add a new
+// one instead.
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// migrations lists all schema versions in order. Versions 1 and 2 use
+// IF NOT EXISTS because they describe tables that databases created before
+// versioning was introduced may already have.
+var migrations = []migration{
+	{1, "photos and metadata_history", migrateInitialSchema},
+	{2, "import sessions", migrateImportSessions},
+	{3, "undoable edit batches", migrateEditBatches},
+	{4, "search indexes", migrateSearchIndexes},
+	{5, "camera, lens and exposure details", migrateExposureDetails},
+	{6, "capture time zones", migrateCaptureTimeZones},
+	{7, "ratings, titles and keywords", migrateDescriptiveMetadata},
+	{8, "favorites and people", migrateFavoritesAndPeople},
+	{9, "videos", migrateVideos},
+	{10, "live and motion photos", migrateMotionPhotos},
+	{11, "raw and jpeg pairs", migrateRawPairs},
+	{12, "near-duplicate groups", migrateDuplicateGroups},
+	{13, "duplicate hits and trash", migrateDuplicateHits},
+	{14, "library settings", migrateSettings},
+	{15, "trashed duplicate lookups", migrateTrashLookups},
+}
+
+// LatestVersion is the schema version InitDB upgrades every database to.
+func LatestVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion returns the version recorded in schema_version, or 0 for a
+// database that has never been migrated.
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+func migrate(db *sql.DB, path string) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`)
+	if err != nil {
+		return err
+	}
+
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if current > LatestVersion() {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", current, LatestVersion())
+	}
+	if current == LatestVersion() {
+		return nil
+	}
+
+	if err := backupBeforeMigration(db, path, current); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+		log.Printf("Database migrated to version %d (%s).", m.version, m.description)
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (version, description) VALUES (?, ?)", m.version, m.description); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// backupBeforeMigration writes a consistent copy of an existing database next
+// to it before any migration touches it. Fresh and in-memory databases have
+// nothing worth backing up.
+func backupBeforeMigration(db *sql.DB, path string, current int) error {
+	if path == ":memory:" {
+		return nil
+	}
+	var tables int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'photos'").Scan(&tables)
+	if err != nil {
+		return fmt.Errorf("failed to inspect database: %w", err)
+	}
+	if tables == 0 {
+		return nil
+	}
+
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", path, current, time.Now().Format("20060102-150405"))
+	if _, err := db.Exec("VACUUM INTO ?", backupPath); err != nil {
+		return fmt.Errorf("failed to back up database to %s: %w", backupPath, err)
+	}
+	log.Printf("Database backed up to %s before migration.", backupPath)
+	return nil
+}
+
+func migrateInitialSchema(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS photos (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			original_path TEXT,
+			library_path TEXT NOT NULL,
+			filename TEXT NOT NULL UNIQUE,
+			hash TEXT NOT NULL,
+			date_taken DATETIME,
+			camera_model TEXT,
+			latitude REAL,
+			longitude REAL,
+			import_date DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_photos_hash ON photos(hash);`,
+		`CREATE INDEX IF NOT EXISTS idx_photos_date_taken ON photos(date_taken);`,
+		`CREATE TABLE IF NOT EXISTS metadata_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			photo_id INTEGER,
+			field_name TEXT NOT NULL,
+			old_value TEXT,
+			new_value TEXT,
+			changed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (photo_id) REFERENCES photos(id)
+		);`,
+	)
+}
+
+func migrateImportSessions(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS import_sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			source_path TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'running',
+			started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			finished_at DATETIME
+		);`,
+		`CREATE TABLE IF NOT EXISTS import_session_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id INTEGER NOT NULL,
+			source_path TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			photo_id INTEGER,
+			hash TEXT,
+			message TEXT,
+			processed_at DATETIME,
+			UNIQUE (session_id, source_path),
+			FOREIGN KEY (session_id) REFERENCES import_sessions(id),
+			FOREIGN KEY (photo_id) REFERENCES photos(id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_import_session_items_status ON import_session_items(session_id, status);`,
+	)
+}
+
+func migrateEditBatches(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE edit_batches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			description TEXT NOT NULL,
+			state TEXT NOT NULL DEFAULT 'applied',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE INDEX idx_edit_batches_state ON edit_batches(state);`,
+		`ALTER TABLE metadata_history ADD COLUMN batch_id INTEGER REFERENCES edit_batches(id);`,
+		`CREATE INDEX idx_metadata_history_photo ON metadata_history(photo_id);`,
+		`CREATE INDEX idx_metadata_history_batch ON metadata_history(batch_id);`,
+	)
+}
+
+func migrateSearchIndexes(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE INDEX idx_photos_camera_model ON photos(camera_model COLLATE NOCASE);`,
+		`CREATE INDEX idx_photos_location ON photos(latitude, longitude);`,
+		`CREATE INDEX idx_import_session_items_photo ON import_session_items(photo_id);`,
+	)
+}
+
+func migrateExposureDetails(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE photos ADD COLUMN camera_make TEXT;`,
+		`ALTER TABLE photos ADD COLUMN lens_model TEXT;`,
+		`ALTER TABLE photos ADD COLUMN f_number REAL;`,
+		`ALTER TABLE photos ADD COLUMN exposure_time REAL;`,
+		`ALTER TABLE photos ADD COLUMN iso INTEGER;`,
+		`ALTER TABLE photos ADD COLUMN focal_length REAL;`,
+		`ALTER TABLE photos ADD COLUMN orientation INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE photos ADD COLUMN width INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE photos ADD COLUMN height INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE photos ADD COLUMN altitude REAL;`,
+		`CREATE INDEX idx_photos_lens_model ON photos(lens_model COLLATE NOCASE);`,
+		`CREATE INDEX idx_photos_f_number ON photos(f_number);`,
+		// Earlier imports stored the quoted, space-padded EXIF string
+		`UPDATE photos SET camera_model = TRIM(SUBSTR(camera_model, 2, LENGTH(camera_model) - 2))
+			WHERE camera_model LIKE '"%"';`,
+	)
+}
+
+// migrateCaptureTimeZones adds the UTC instant of the capture time next to
+// the local wall-clock date_taken. Existing rows were imported without zone
+// information, so their offset stays unknown.
+func migrateCaptureTimeZones(tx *sql.Tx) error {
+	err := execAll(tx,
+		`ALTER TABLE photos ADD COLUMN date_taken_utc DATETIME;`,
+		`ALTER TABLE photos ADD COLUMN utc_offset INTEGER;`,
+		`CREATE INDEX idx_photos_date_taken_utc ON photos(date_taken_utc);`,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT id, date_taken FROM photos WHERE date_taken IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	instants := make(map[int64]time.Time)
+	for rows.Next() {
+		var id int64
+		var value interface{}
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		// Values the driver cannot parse as a time are left without an instant
+		if t, ok := value.(time.Time); ok {
+			instants[id] = t.UTC()
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, t := range instants {
+		if _, err := tx.Exec("UPDATE photos SET date_taken_utc = ? WHERE id = ?", t, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateDescriptiveMetadata(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE photos ADD COLUMN rating INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE photos ADD COLUMN title TEXT;`,
+		`ALTER TABLE photos ADD COLUMN description TEXT;`,
+		`CREATE INDEX idx_photos_rating ON photos(rating);`,
+		`CREATE TABLE photo_keywords (
+			photo_id INTEGER NOT NULL,
+			keyword TEXT NOT NULL,
+			PRIMARY KEY (photo_id, keyword),
+			FOREIGN KEY (photo_id) REFERENCES photos(id)
+		);`,
+		`CREATE INDEX idx_photo_keywords_keyword ON photo_keywords(keyword COLLATE NOCASE);`,
+	)
+}
+
+func migrateFavoritesAndPeople(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE photos ADD COLUMN favorite INTEGER NOT NULL DEFAULT 0;`,
+		`CREATE TABLE photo_people (
+			photo_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			PRIMARY KEY (photo_id, name),
+			FOREIGN KEY (photo_id) REFERENCES photos(id)
+		);`,
+		`CREATE INDEX idx_photo_people_name ON photo_people(name COLLATE NOCASE);`,
+	)
+}
+
+func migrateVideos(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE photos ADD COLUMN media_type TEXT NOT NULL DEFAULT 'photo';`,
+		`ALTER TABLE photos ADD COLUMN duration REAL;`,
+		`CREATE INDEX idx_photos_media_type ON photos(media_type);`,
+	)
+}
+
+func migrateMotionPhotos(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE photos ADD COLUMN content_identifier TEXT;`,
+		`ALTER TABLE photos ADD COLUMN motion_type TEXT;`,
+		`ALTER TABLE photos ADD COLUMN motion_filename TEXT;`,
+		`CREATE INDEX idx_photos_content_identifier ON photos(content_identifier);`,
+	)
+}
+
+func migrateRawPairs(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE photos ADD COLUMN alternate_filename TEXT;`,
+		`ALTER TABLE photos ADD COLUMN alternate_hash TEXT;`,
+		`CREATE INDEX idx_photos_alternate_hash ON photos(alternate_hash);`,
+	)
+}
+
+// migrateDuplicateGroups stores perceptual hashes and the groups of
+// near-duplicate photos found with them. A photo is in at most one group.
+func migrateDuplicateGroups(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE photos ADD COLUMN phash INTEGER;`,
+		`CREATE TABLE duplicate_groups (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			keeper_id INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (keeper_id) REFERENCES photos(id)
+		);`,
+		`CREATE TABLE duplicate_group_members (
+			group_id INTEGER NOT NULL,
+			photo_id INTEGER NOT NULL PRIMARY KEY,
+			FOREIGN KEY (group_id) REFERENCES duplicate_groups(id),
+			FOREIGN KEY (photo_id) REFERENCES photos(id)
+		);`,
+		`CREATE INDEX idx_duplicate_group_members_group ON duplicate_group_members(group_id);`,
+	)
+}
+
+// migrateDuplicateHits records the files skipped as exact duplicates, and
+// adds the trash that resolving duplicate groups moves photos to. Trashed
+// photos keep their row as JSON so they can be restored.
+func migrateDuplicateHits(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE duplicate_hits (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			source_path TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			photo_id INTEGER,
+			session_id INTEGER,
+			detected_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (photo_id) REFERENCES photos(id),
+			FOREIGN KEY (session_id) REFERENCES import_sessions(id)
+		);`,
+		`CREATE INDEX idx_duplicate_hits_photo ON duplicate_hits(photo_id);`,
+		`CREATE INDEX idx_duplicate_hits_session ON duplicate_hits(session_id);`,
+		`CREATE TABLE trash (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			photo_id INTEGER NOT NULL,
+			filename TEXT NOT NULL,
+			trash_path TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			photo TEXT NOT NULL,
+			reason TEXT,
+			trashed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+	)
+}
+
+// migrateSettings adds a key-value store for library-wide settings.
+func migrateSettings(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		);`,
+	)
+}
+
+// migrateTrashLookups indexes the trash by hash, so that imports can skip
+// files the user already resolved as duplicates, and records the photo each
+// trashed duplicate was replaced by. Earlier entries carry it in their reason.
+func migrateTrashLookups(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE trash ADD COLUMN replaced_by INTEGER;`,
+		`UPDATE trash SET replaced_by = CAST(SUBSTR(reason, 20) AS INTEGER) WHERE reason LIKE 'duplicate of photo %';`,
+		`CREATE INDEX idx_trash_hash ON trash(hash);`,
+	)
+}
+
+func execAll(tx *sql.Tx, queries ...string) error {
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// createLegacyDB writes a database in the format used before schema
+// versioning: photos and metadata_history only, no schema_version table.
+func createLegacyDB(t *testing.T, path string) {
+	t.Helper()
+	legacy, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer legacy.Close()
+
+	queries := []string{
+		`CREATE TABLE photos (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			original_path TEXT,
+			library_path TEXT NOT NULL,
+			filename TEXT NOT NULL UNIQUE,
+			hash TEXT NOT NULL,
+			date_taken DATETIME,
+			camera_model TEXT,
+			latitude REAL,
+			longitude REAL,
+			import_date DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE metadata_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			photo_id INTEGER,
+			field_name TEXT NOT NULL,
+			old_value TEXT,
+			new_value TEXT,
+			changed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (photo_id) REFERENCES photos(id)
+		);`,
+		`INSERT INTO photos (original_path, library_path, filename, hash, date_taken, camera_model)
+			VALUES ('orig.jpg', '/lib/2020/01/01/a.jpg', '2020/01/01/a.jpg', 'abc', '2020-01-01 10:00:00 +0100 CET', 'Old Camera');`,
+	}
+	for _, q := range queries {
+		if _, err := legacy.Exec(q); err != nil {
+			t.Fatalf("Failed to create legacy schema: %v", err)
+		}
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	tempDir := t.TempDir()
+	dbPath := filepath.Join(tempDir, "photoo.db")
+	createLegacyDB(t, dbPath)
+
+	dbConn, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB failed on legacy database: %v", err)
+	}
+	defer dbConn.Close()
+
+	version, err := SchemaVersion(dbConn)
+	if err != nil {
+		t.Fatalf("SchemaVersion failed: %v", err)
+	}
+	if version != LatestVersion() {
+		t.Errorf("Expected schema version %d, got %d", LatestVersion(), version)
+	}
+
+	// Existing data survives the upgrade
+	var model string
+	if err := dbConn.QueryRow("SELECT camera_model FROM photos WHERE hash = 'abc'").Scan(&model); err != nil {
+		t.Fatalf("Legacy row lost: %v", err)
+	}
+	if model != "Old Camera" {
+		t.Errorf("Expected 'Old Camera', got %q", model)
+	}
+
+	// The UTC instant is derived from the stored date, the offset is unknown
+	var utc time.Time
+	var offset sql.NullInt64
+	if err := dbConn.QueryRow("SELECT date_taken_utc, utc_offset FROM photos WHERE hash = 'abc'").Scan(&utc, &offset); err != nil {
+		t.Fatalf("Failed to read capture instant: %v", err)
+	}
+	if !utc.Equal(time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)) || offset.Valid {
+		t.Errorf("Unexpected capture instant %v, offset %v", utc, offset)
+	}
+
+	// Tables from later migrations exist
+	if _, err := dbConn.Exec("SELECT COUNT(*) FROM import_session_items"); err != nil {
+		t.Errorf("import_session_items missing after migration: %v", err)
+	}
+
+	// A backup of the pre-migration file was written
+	backups, _ := filepath.Glob(dbPath + ".v0-*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("Expected one backup file, got %v", backups)
+	}
+	backup, err := sql.Open("sqlite", backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	var tables int
+	backup.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'import_sessions'").Scan(&tables)
+	if tables != 0 {
+		t.Errorf("Expected backup to predate the migrations")
+	}
+	var rows int
+	backup.QueryRow("SELECT COUNT(*) FROM photos").Scan(&rows)
+	if rows != 1 {
+		t.Errorf("Expected backup to contain the legacy row, got %d rows", rows)
+	}
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "photoo.db")
+
+	for i := 0; i < 2; i++ {
+		dbConn, err := InitDB(dbPath)
+		if err != nil {
+			t.Fatalf("InitDB #%d failed: %v", i+1, err)
+		}
+		version, _ := SchemaVersion(dbConn)
+		if version != LatestVersion() {
+			t.Errorf("InitDB #%d: expected version %d, got %d", i+1, LatestVersion(), version)
+		}
+		dbConn.Close()
+	}
+
+	// A fresh database has nothing to back up
+	if backups, _ := filepath.Glob(dbPath + ".*.bak"); len(backups) != 0 {
+		t.Errorf("Expected no backups for a fresh database, got %v", backups)
+	}
+}
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("Migration at index %d has version %d, expected %d", i, m.version, i+1)
+		}
+	}
+}
+
+func TestRejectNewerSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "photoo.db")
+	dbConn, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbConn.Exec("INSERT INTO schema_version (version, description) VALUES (?, 'from the future')", LatestVersion()+1)
+	dbConn.Close()
+
+	if _, err := InitDB(dbPath); err == nil {
+		t.Error("Expected InitDB to refuse a newer schema version")
+	}
+}
@@ -1,88 +1,380 @@
 package exif
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/rwcarlsen/goexif/exif"
 )
 
 type Metadata struct {
-	DateTaken   time.Time
-	CameraModel string
-	Latitude    *float64
-	Longitude   *float64
+	// DateTaken is in the zone the photo was taken in when that is known,
+	// so its wall clock is the local capture time. Otherwise it is in
+	// time.Local and UTCOffset is nil.
+	DateTaken    time.Time
+	UTCOffset    *int // seconds east of UTC at capture
+	CameraMake   string
+	CameraModel  string
+	LensModel    string
+	FNumber      *float64 // aperture, e.g. 1.8
+	ExposureTime *float64 // seconds
+	ISO          *int
+	FocalLength  *float64 // millimetres
+	Orientation  int      // EXIF orientation 1-8, 0 if unknown
+	Width        int      // pixels, 0 if unknown
+	Height       int
+	Latitude     *float64
+	Longitude    *float64
+	Altitude     *float64 // metres above sea level
+	Rating       *int     // XMP rating: 1-5 stars, 0 unrated, -1 rejected
+	Title        string
+	Description  string
+	Keywords     []string
+	Favorite     bool     // starred in Google Photos
+	People       []string // names of the people tagged in the photo
+	Video        bool     // an MP4 or QuickTime video rather than a still image
+	Duration     *float64 // seconds, videos only
+	// ContentIdentifier is shared by the still and the video of an Apple
+	// Live Photo.
+	ContentIdentifier string
+	MotionPhoto       bool // a JPEG with an embedded video, see EmbeddedVideo
 }
 
 // GooglePhotosMetadata represents the structure of the .json sidecar files
 type GooglePhotosMetadata struct {
+	Title          string `json:"title"`
+	Description    string `json:"description"`
 	PhotoTakenTime struct {
 		Timestamp string `json:"timestamp"`
 	} `json:"photoTakenTime"`
-	GeoData struct {
-		Latitude  float64 `json:"latitude"`
-		Longitude float64 `json:"longitude"`
-	} `json:"geoData"`
+	// GeoData is the location shown in Google Photos, which may have been
+	// edited or estimated; GeoDataExif is the one read from the uploaded file.
+	GeoData     googleGeoData `json:"geoData"`
+	GeoDataExif googleGeoData `json:"geoDataExif"`
+	People      []struct {
+		Name string `json:"name"`
+	} `json:"people"`
+	Favorited bool `json:"favorited"`
 }
 
+// googleGeoData is a Takeout location; 0,0 means none.
+type googleGeoData struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude"`
+}
+
+func (g googleGeoData) valid() bool {
+	return g.Latitude != 0 || g.Longitude != 0
+}
+
+// Sidecars holds the sidecar files of a photo, for photos whose sidecars are
+// not next to them on disk, e.g. inside an archive.
+type Sidecars struct {
+	Name    string // the photo's original file name
+	XMP     []byte // nil if there is no XMP sidecar
+	Takeout []byte // Google Takeout JSON, nil if there is none
+}
+
+// ExtractMetadata reads a photo's or video's metadata. Dates and positions
+// are taken from, in order of preference: an XMP sidecar, a Google Photos
+// JSON sidecar, EXIF (the moov box for videos), and XMP embedded in the file. Rating, title, description and keywords
+// come from the XMP sidecar, else the embedded XMP.
 func ExtractMetadata(path string) (*Metadata, error) {
+	sidecars := Sidecars{Name: filepath.Base(path)}
+	if p := findSidecar(path); p != "" {
+		sidecars.XMP, _ = os.ReadFile(p)
+	}
+	if p := findTakeoutSidecar(path); p != "" {
+		sidecars.Takeout, _ = os.ReadFile(p)
+	}
+	return ExtractMetadataWithSidecars(path, sidecars)
+}
+
+// ExtractMetadataWithSidecars is ExtractMetadata with the given sidecars
+// instead of the ones next to path.
+func ExtractMetadataWithSidecars(path string, sidecars Sidecars) (*Metadata, error) {
 	metadata := &Metadata{}
+	var dates []captureDate
+	var positions [][3]*float64
 
-	// 1. Try to read from sidecar JSON (Google Photos style)
-	sidecarPath := path + ".supplemental-metadata.json"
-	if _, err := os.Stat(sidecarPath); err == nil {
-		if sm, err := readGooglePhotosJSON(sidecarPath); err == nil {
-			metadata.DateTaken = sm.DateTaken
-			metadata.Latitude = sm.Latitude
-			metadata.Longitude = sm.Longitude
+	// 1. XMP sidecar (written by photoo, Lightroom, darktable, digiKam...)
+	var sidecarXMP *xmpData
+	if sidecars.XMP != nil {
+		sidecarXMP, _ = parseXMP(sidecars.XMP)
+	}
+	if sidecarXMP != nil {
+		dates = append(dates, sidecarXMP.captureDate())
+		positions = append(positions, [3]*float64{sidecarXMP.latitude, sidecarXMP.longitude, sidecarXMP.altitude})
+	}
+
+	// 2. Try to read from sidecar JSON (Google Photos style)
+	var takeout *Metadata
+	if sidecars.Takeout != nil {
+		if sm, err := parseGooglePhotosJSON(sidecars.Takeout); err == nil {
+			takeout = sm
+			// Sidecar timestamps are instants; only the zone is missing
+			dates = append(dates, captureDate{t: sm.DateTaken, instant: true})
+			positions = append(positions, [3]*float64{sm.Latitude, sm.Longitude, sm.Altitude})
+			// Titles are usually just the uploaded file name
+			for _, t := range takeoutTitles(sidecars.Name) {
+				if strings.EqualFold(sm.Title, t.title) {
+					sm.Title = ""
+				}
+			}
 		}
-	} else {
-		// Try alternative sidecar name: .jpg.suppl.json or similar
-		// For simplicity, we just check the most common ones
 	}
 
-	// 2. Extract from EXIF (if possible)
-	f, err := os.Open(path)
-	if err == nil {
+	// 3. Extract from EXIF (if possible), or from the boxes of a video
+	var exifZone *time.Location
+	if IsVideo(path) {
+		metadata.Video = true
+		if v, err := readVideo(path); err == nil {
+			if d := v.captureDate(); !d.t.IsZero() {
+				dates = append(dates, d)
+				exifZone = d.zone
+			}
+			positions = append(positions, [3]*float64{v.latitude, v.longitude, v.altitude})
+			metadata.Width, metadata.Height, metadata.Orientation = v.width, v.height, v.orientation
+			if v.duration > 0 {
+				metadata.Duration = &v.duration
+			}
+			metadata.ContentIdentifier = v.contentID
+		}
+	} else if f, err := exifSource(path); err == nil {
 		defer f.Close()
 		x, err := exif.Decode(f)
 		if err == nil {
-			if dt, err := x.DateTime(); err == nil && metadata.DateTaken.IsZero() {
-				metadata.DateTaken = dt
+			if wall, loc, err := exifDateTime(x); err == nil {
+				// EXIF dates are wall-clock times of the camera clock
+				dates = append(dates, captureDate{t: wall, zone: loc})
+				exifZone = loc
+			}
+			metadata.CameraMake = tagString(x, exif.Make)
+			metadata.CameraModel = tagString(x, exif.Model)
+			metadata.LensModel = tagString(x, exif.LensModel)
+			metadata.ContentIdentifier = appleContentIdentifier(x)
+			metadata.FNumber = tagRat(x, exif.FNumber)
+			metadata.ExposureTime = tagRat(x, exif.ExposureTime)
+			metadata.FocalLength = tagRat(x, exif.FocalLength)
+			metadata.ISO = tagInt(x, exif.ISOSpeedRatings)
+			if o := tagInt(x, exif.Orientation); o != nil {
+				metadata.Orientation = *o
 			}
-			if model, err := x.Get(exif.Model); err == nil && model != nil {
-				metadata.CameraModel = model.String()
+			if w := tagInt(x, exif.PixelXDimension); w != nil {
+				metadata.Width = *w
+			} else if w := tagInt(x, exif.ImageWidth); w != nil {
+				metadata.Width = *w
 			}
-			if lat, lon, err := x.LatLong(); err == nil && metadata.Latitude == nil {
-				metadata.Latitude = &lat
-				metadata.Longitude = &lon
+			if h := tagInt(x, exif.PixelYDimension); h != nil {
+				metadata.Height = *h
+			} else if h := tagInt(x, exif.ImageLength); h != nil {
+				metadata.Height = *h
+			}
+			if lat, lon, err := x.LatLong(); err == nil {
+				positions = append(positions, [3]*float64{&lat, &lon, altitude(x)})
 			}
 		}
+		// IFD0 of a raw file often describes a small thumbnail
+		if IsRaw(path) {
+			if r, err := readRaw(path); err == nil && r.width*r.height > metadata.Width*metadata.Height {
+				metadata.Width, metadata.Height = r.width, r.height
+			}
+		}
+	}
+
+	if !metadata.Video {
+		if _, length, err := EmbeddedVideo(path); err == nil && length > 0 {
+			metadata.MotionPhoto = true
+		}
+	}
+
+	// 4. XMP embedded in the file
+	var embeddedXMP *xmpData
+	if data, err := readEmbeddedXMP(path); err == nil && data != nil {
+		embeddedXMP, _ = parseXMP(data)
+	}
+	if embeddedXMP != nil {
+		dates = append(dates, embeddedXMP.captureDate())
+		positions = append(positions, [3]*float64{embeddedXMP.latitude, embeddedXMP.longitude, embeddedXMP.altitude})
+	}
+
+	for _, p := range positions {
+		if p[0] != nil && p[1] != nil {
+			metadata.Latitude, metadata.Longitude, metadata.Altitude = p[0], p[1], p[2]
+			break
+		}
+	}
+	if sidecarXMP != nil {
+		metadata.applyXMP(sidecarXMP)
+	}
+	if takeout != nil {
+		if metadata.Title == "" {
+			metadata.Title = takeout.Title
+		}
+		if metadata.Description == "" {
+			metadata.Description = takeout.Description
+		}
+		metadata.Favorite = takeout.Favorite
+		metadata.People = takeout.People
+	}
+	if embeddedXMP != nil {
+		metadata.applyXMP(embeddedXMP)
+	}
+
+	var date captureDate
+	for _, d := range dates {
+		if !d.t.IsZero() {
+			date = d
+			break
+		}
+	}
+
+	// 5. Work out the zone the photo was taken in: the offset recorded with
+	// the date or by the camera, else the zone at the GPS position.
+	zone := date.zone
+	if zone == nil {
+		zone = exifZone
+	}
+	if zone == nil && metadata.Latitude != nil && metadata.Longitude != nil {
+		zone = ZoneAt(*metadata.Latitude, *metadata.Longitude)
 	}
 
-	// 3. Fallback to file modification time
-	if metadata.DateTaken.IsZero() {
-		info, _ := os.Stat(path)
+	switch {
+	case date.instant:
+		if zone != nil {
+			metadata.DateTaken = date.t.In(zone)
+		} else {
+			metadata.DateTaken = date.t.In(time.Local)
+		}
+	case !date.t.IsZero():
+		if zone != nil {
+			metadata.DateTaken = withWallClock(date.t, zone)
+		} else {
+			metadata.DateTaken = withWallClock(date.t, time.Local)
+		}
+	default:
+		// 6. Fallback to file modification time
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
 		metadata.DateTaken = info.ModTime()
+		if zone != nil {
+			metadata.DateTaken = metadata.DateTaken.In(zone)
+		}
+	}
+	if zone != nil {
+		_, offset := metadata.DateTaken.Zone()
+		metadata.UTCOffset = &offset
 	}
 
 	return metadata, nil
 }
 
-func readGooglePhotosJSON(path string) (*Metadata, error) {
-	data, err := os.ReadFile(path)
+// exifSource opens what exif.Decode reads: the EXIF item of a HEIF file, or
+// the file itself.
+func exifSource(path string) (io.ReadCloser, error) {
+	if tiff, err := readHEIFExif(path); err == nil && tiff != nil {
+		return io.NopCloser(bytes.NewReader(tiff)), nil
+	}
+	return os.Open(path)
+}
+
+// applyXMP fills in the descriptive fields not set by a preferred source.
+func (m *Metadata) applyXMP(x *xmpData) {
+	if m.Rating == nil {
+		m.Rating = x.rating
+	}
+	if m.Title == "" {
+		m.Title = x.title
+	}
+	if m.Description == "" {
+		m.Description = x.description
+	}
+	if m.Keywords == nil {
+		m.Keywords = x.keywords
+	}
+}
+
+// captureDate is a capture date found in one of a photo's metadata sources.
+// Instants are converted to the capture zone; wall-clock times are read in it.
+type captureDate struct {
+	t       time.Time
+	zone    *time.Location // nil if the source does not record it
+	instant bool
+}
+
+// tagString returns an ASCII tag without the padding some cameras add.
+func tagString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil || tag == nil {
+		return ""
+	}
+	v, err := tag.StringVal()
 	if err != nil {
-		return nil, err
+		return ""
 	}
+	return strings.TrimSpace(strings.TrimRight(v, "\x00"))
+}
+
+// tagRat returns the first value of a rational tag, or nil if it is missing
+// or has a zero denominator.
+func tagRat(x *exif.Exif, name exif.FieldName) *float64 {
+	tag, err := x.Get(name)
+	if err != nil || tag == nil {
+		return nil
+	}
+	num, denom, err := tag.Rat2(0)
+	if err != nil || denom == 0 {
+		return nil
+	}
+	v := float64(num) / float64(denom)
+	return &v
+}
+
+// tagInt returns the first value of an integer tag, or nil if it is missing.
+func tagInt(x *exif.Exif, name exif.FieldName) *int {
+	tag, err := x.Get(name)
+	if err != nil || tag == nil {
+		return nil
+	}
+	v, err := tag.Int(0)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
 
+// altitude combines GPSAltitude with GPSAltitudeRef (1 = below sea level).
+func altitude(x *exif.Exif) *float64 {
+	alt := tagRat(x, exif.GPSAltitude)
+	if alt == nil {
+		return nil
+	}
+	if ref := tagInt(x, exif.GPSAltitudeRef); ref != nil && *ref == 1 {
+		*alt = -*alt
+	}
+	return alt
+}
+
+func parseGooglePhotosJSON(data []byte) (*Metadata, error) {
 	var gp GooglePhotosMetadata
 	if err := json.Unmarshal(data, &gp); err != nil {
 		return nil, err
 	}
 
-	m := &Metadata{}
+	m := &Metadata{
+		Title:       strings.TrimSpace(gp.Title),
+		Description: strings.TrimSpace(gp.Description),
+		Favorite:    gp.Favorited,
+	}
 	// Google Photos uses Unix timestamps in seconds
 	ts := gp.PhotoTakenTime.Timestamp
 	var seconds int64
@@ -91,9 +383,21 @@ func readGooglePhotosJSON(path string) (*Metadata, error) {
 		m.DateTaken = time.Unix(seconds, 0)
 	}
 
-	if gp.GeoData.Latitude != 0 || gp.GeoData.Longitude != 0 {
-		m.Latitude = &gp.GeoData.Latitude
-		m.Longitude = &gp.GeoData.Longitude
+	// geoData includes locations set in Google Photos, so it wins
+	for _, geo := range []googleGeoData{gp.GeoData, gp.GeoDataExif} {
+		if geo.valid() {
+			m.Latitude = &geo.Latitude
+			m.Longitude = &geo.Longitude
+			if geo.Altitude != 0 {
+				m.Altitude = &geo.Altitude
+			}
+			break
+		}
+	}
+	for _, p := range gp.People {
+		if name := strings.TrimSpace(p.Name); name != "" {
+			m.People = append(m.People, name)
+		}
 	}
 
 	return m, nil
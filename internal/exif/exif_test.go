@@ -0,0 +1,120 @@
+package exif
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExtractMetadataDetails(t *testing.T) {
+	testCases := []struct {
+		file         string
+		make, model  string
+		fNumber      float64
+		exposureTime float64
+		iso          int
+		focalLength  float64
+		width        int
+		height       int
+	}{
+		{"source_digital_camera/RIMG0018.JPG", "RICOH", "Caplio R5", 4.2, 1.0 / 9, 200, 6.7, 3072, 2304},
+		{"source_google_photos/IMG_20211022_084955842.jpg", "motorola", "moto g(100)", 1.7, 1.0 / 50, 205, 4.829, 4624, 3472},
+		// EXIF item of a HEIF file
+		{"source_icloud/IMG_8299.HEIC", "Apple", "iPhone 13", 1.6, 1.0 / 60, 200, 5.1, 4032, 3024},
+	}
+
+	for _, tc := range testCases {
+		m, err := ExtractMetadata(filepath.Join("..", "..", "test_data", tc.file))
+		if err != nil {
+			t.Fatalf("%s: ExtractMetadata failed: %v", tc.file, err)
+		}
+		if m.CameraMake != tc.make || m.CameraModel != tc.model {
+			t.Errorf("%s: expected %q %q, got %q %q", tc.file, tc.make, tc.model, m.CameraMake, m.CameraModel)
+		}
+		if m.FNumber == nil || *m.FNumber != tc.fNumber {
+			t.Errorf("%s: expected f/%v, got %v", tc.file, tc.fNumber, m.FNumber)
+		}
+		if m.ExposureTime == nil || *m.ExposureTime != tc.exposureTime {
+			t.Errorf("%s: expected exposure %v, got %v", tc.file, tc.exposureTime, m.ExposureTime)
+		}
+		if m.ISO == nil || *m.ISO != tc.iso {
+			t.Errorf("%s: expected ISO %d, got %v", tc.file, tc.iso, m.ISO)
+		}
+		if m.FocalLength == nil || *m.FocalLength != tc.focalLength {
+			t.Errorf("%s: expected focal length %v, got %v", tc.file, tc.focalLength, m.FocalLength)
+		}
+		if m.Width != tc.width || m.Height != tc.height {
+			t.Errorf("%s: expected %dx%d, got %dx%d", tc.file, tc.width, tc.height, m.Width, m.Height)
+		}
+		if m.Orientation == 0 {
+			t.Errorf("%s: expected an orientation", tc.file)
+		}
+	}
+}
+
+func TestCaptureTimeZone(t *testing.T) {
+	// A Google sidecar gives the instant, its GPS position the zone
+	dir := t.TempDir()
+	data, err := os.ReadFile(filepath.Join("..", "..", "test_data", "source_digital_camera", "RIMG0018.JPG"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo := filepath.Join(dir, "tokyo.jpg")
+	os.WriteFile(photo, data, 0644)
+	os.WriteFile(photo+".supplemental-metadata.json", []byte(`{
+		"photoTakenTime": {"timestamp": "1685664000"},
+		"geoData": {"latitude": 35.6812, "longitude": 139.7671}
+	}`), 0644)
+
+	m, err := ExtractMetadata(photo)
+	if err != nil {
+		t.Fatalf("ExtractMetadata failed: %v", err)
+	}
+	if !m.DateTaken.Equal(time.Unix(1685664000, 0)) {
+		t.Errorf("Expected the sidecar instant, got %v", m.DateTaken)
+	}
+	// 2023-06-02 00:00 UTC is 09:00 in Tokyo
+	if m.DateTaken.Format("2006-01-02 15:04") != "2023-06-02 09:00" {
+		t.Errorf("Expected Tokyo wall-clock time, got %v", m.DateTaken)
+	}
+	if m.UTCOffset == nil || *m.UTCOffset != 9*3600 {
+		t.Errorf("Expected offset +09:00, got %v", m.UTCOffset)
+	}
+
+	// Without a zone the EXIF wall-clock time is kept as is
+	m, err = ExtractMetadata(filepath.Join("..", "..", "test_data", "source_digital_camera", "RIMG0018.JPG"))
+	if err != nil {
+		t.Fatalf("ExtractMetadata failed: %v", err)
+	}
+	if m.DateTaken.Format("2006-01-02 15:04:05") != "2011-08-10 10:30:36" || m.UTCOffset != nil {
+		t.Errorf("Unexpected capture time %v, offset %v", m.DateTaken, m.UTCOffset)
+	}
+}
+
+func TestParseOffset(t *testing.T) {
+	for s, expected := range map[string]int{"+09:00": 9 * 3600, "-03:30": -(3*3600 + 1800), "+05:45": 5*3600 + 45*60, "+00:00": 0} {
+		loc, err := parseOffset(s)
+		if err != nil {
+			t.Errorf("parseOffset(%q) failed: %v", s, err)
+			continue
+		}
+		if _, offset := time.Date(2023, 1, 1, 0, 0, 0, 0, loc).Zone(); offset != expected {
+			t.Errorf("parseOffset(%q) = %d, expected %d", s, offset, expected)
+		}
+	}
+	for _, s := range []string{"", "   :  ", "09:00", "+9"} {
+		if _, err := parseOffset(s); err == nil {
+			t.Errorf("Expected parseOffset(%q) to fail", s)
+		}
+	}
+}
+
+func TestZoneAt(t *testing.T) {
+	if loc := ZoneAt(52.52, 13.40); loc == nil || loc.String() != "Europe/Berlin" {
+		t.Errorf("Expected Europe/Berlin, got %v", loc)
+	}
+	if loc := ZoneAt(-33.87, 151.21); loc == nil || loc.String() != "Australia/Sydney" {
+		t.Errorf("Expected Australia/Sydney, got %v", loc)
+	}
+}
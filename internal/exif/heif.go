@@ -0,0 +1,508 @@
+package exif
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// HEIF files (HEIC, AVIF) use the same boxes as videos. Everything in them
+// is an item described by the meta box:
+//
+//	meta/pitm         the primary image
+//	meta/iinf         the type of each item, e.g. "hvc1", "grid" or "Exif"
+//	meta/iloc         where in the file (or in meta/idat) its bytes are
+//	meta/iref         thumbnails ("thmb") and grid tiles ("dimg")
+//	meta/iprp         properties: size, decoder configuration, rotation
+
+// maxHEIFMetaSize bounds the meta box read into memory.
+const maxHEIFMetaSize = 4 << 20
+
+// maxHEIFExifSize bounds the EXIF item read into memory.
+const maxHEIFExifSize = 4 << 20
+
+// maxHEIFImageSize bounds the coded image read into memory.
+const maxHEIFImageSize = 64 << 20
+
+// HEIFImage is a coded image item of a HEIF file. Photoo does not decode
+// HEVC or AV1 itself; see the HEIF decoder of the library package.
+type HEIFImage struct {
+	Type     string // item type: "hvc1" (HEVC), "av01" (AV1), "jpeg" or "grid"
+	Width    int    // pixels, from the ispe property
+	Height   int
+	Rotation int    // quarter turns counter-clockwise to apply when displaying
+	Config   []byte // decoder configuration (hvcC or av1C payload)
+	Data     []byte // coded image; nil for a grid
+	// A grid is made of Rows×Columns tiles in raster order, cropped to
+	// Width×Height.
+	Rows    int
+	Columns int
+	Tiles   []*HEIFImage
+}
+
+// heifMeta is the meta box of a HEIF file: its items, where their bytes are,
+// how they reference each other and their properties.
+type heifMeta struct {
+	f       io.ReaderAt
+	primary uint32
+	items   []heifItem
+	iloc    []byte
+	idat    []byte
+	refs    []heifReference
+	props   []box            // ipco children, referenced by 1-based index
+	assoc   map[uint32][]int // item ID to property indexes
+}
+
+type heifItem struct {
+	id  uint32
+	typ string
+}
+
+// heifReference is an iref entry: item from refers to the to items, e.g. a
+// thumbnail ("thmb") to its master image or a grid ("dimg") to its tiles.
+type heifReference struct {
+	typ  string
+	from uint32
+	to   []uint32
+}
+
+// openHEIF opens the file at path and reads its meta box. It returns a nil
+// meta if the file is not HEIF; otherwise the caller closes the file.
+func openHEIF(path string) (*os.File, *heifMeta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var head [12]byte
+	if _, err := io.ReadFull(f, head[:]); err != nil || string(head[4:8]) != "ftyp" || !heifBrands[string(head[8:12])] {
+		f.Close()
+		return nil, nil, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	for {
+		typ, size, err := readBoxHeader(f)
+		if err == io.EOF {
+			f.Close()
+			return nil, nil, nil
+		} else if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		if typ != "meta" {
+			if size < 0 {
+				f.Close()
+				return nil, nil, nil
+			}
+			if _, err := f.Seek(size, io.SeekCurrent); err != nil {
+				f.Close()
+				return nil, nil, err
+			}
+			continue
+		}
+		if size < 4 || size > maxHEIFMetaSize {
+			f.Close()
+			return nil, nil, fmt.Errorf("invalid meta box")
+		}
+		meta := make([]byte, size)
+		if _, err := io.ReadFull(f, meta); err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("failed to read meta box: %w", err)
+		}
+		return f, parseHEIFMeta(f, meta[4:]), nil
+	}
+}
+
+// parseHEIFMeta parses the children of a meta box.
+func parseHEIFMeta(f io.ReaderAt, meta []byte) *heifMeta {
+	m := &heifMeta{f: f, assoc: map[uint32][]int{}}
+	for _, b := range boxes(meta) {
+		switch b.typ {
+		case "pitm":
+			r := &byteReader{data: b.data}
+			if r.uint(1) == 0 {
+				r.skip(3)
+				m.primary = uint32(r.uint(2))
+			} else {
+				r.skip(3)
+				m.primary = uint32(r.uint(4))
+			}
+		case "iinf":
+			m.items = heifItems(b.data)
+		case "iloc":
+			m.iloc = b.data
+		case "idat":
+			m.idat = b.data
+		case "iref":
+			m.refs = heifReferences(b.data)
+		case "iprp":
+			m.parseProperties(b.data)
+		}
+	}
+	return m
+}
+
+// readHEIFExif returns the EXIF block of a HEIF file, starting at its TIFF
+// header, or nil if the file is not HEIF or has no EXIF.
+func readHEIFExif(path string) ([]byte, error) {
+	f, meta, err := openHEIF(path)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	id, ok := meta.itemOfType("Exif")
+	if !ok {
+		return nil, nil
+	}
+	data, err := meta.itemData(id, maxHEIFExifSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read EXIF item: %w", err)
+	}
+
+	// The item starts with the offset of the TIFF header, skipping "Exif\0\0"
+	if len(data) < 4 {
+		return nil, fmt.Errorf("EXIF item too short")
+	}
+	offset := uint64(binary.BigEndian.Uint32(data[:4])) + 4
+	if offset >= uint64(len(data)) {
+		return nil, fmt.Errorf("invalid EXIF header offset")
+	}
+	return data[offset:], nil
+}
+
+// HEIFPreview returns the image to build a thumbnail of the HEIF file at
+// path from: the thumbnail of the primary image if it has one, else the
+// primary image itself. It returns nil if the file is not HEIF.
+func HEIFPreview(path string) (*HEIFImage, error) {
+	f, meta, err := openHEIF(path)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	for _, ref := range meta.refs {
+		if ref.typ == "thmb" && slices.Contains(ref.to, meta.primary) {
+			if img, err := meta.image(ref.from, 0); err == nil {
+				return img, nil
+			}
+		}
+	}
+	return meta.image(meta.primary, 0)
+}
+
+// HEIFExifThumbnail returns the JPEG thumbnail stored in the EXIF block of
+// a HEIF file, or nil if it has none.
+func HEIFExifThumbnail(path string) ([]byte, error) {
+	tiff, err := readHEIFExif(path)
+	if err != nil || tiff == nil {
+		return nil, err
+	}
+	return tiffImages(tiff).preview, nil
+}
+
+// image reads item id and, for a grid, its tiles.
+func (m *heifMeta) image(id uint32, depth int) (*HEIFImage, error) {
+	typ, ok := m.itemType(id)
+	if !ok {
+		return nil, fmt.Errorf("item %d does not exist", id)
+	}
+	img := &HEIFImage{Type: typ}
+	for _, p := range m.properties(id) {
+		switch p.typ {
+		case "ispe":
+			if len(p.data) >= 12 {
+				img.Width = int(binary.BigEndian.Uint32(p.data[4:8]))
+				img.Height = int(binary.BigEndian.Uint32(p.data[8:12]))
+			}
+		case "hvcC", "av1C":
+			img.Config = p.data
+		case "irot":
+			if len(p.data) >= 1 {
+				img.Rotation = int(p.data[0] & 3)
+			}
+		}
+	}
+
+	data, err := m.itemData(id, maxHEIFImageSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read item %d: %w", id, err)
+	}
+	if typ != "grid" {
+		img.Data = data
+		return img, nil
+	}
+
+	// Grids of grids are not allowed
+	if depth > 0 {
+		return nil, fmt.Errorf("nested grid")
+	}
+	r := &byteReader{data: data}
+	r.skip(1)
+	flags := r.uint(1)
+	img.Rows, img.Columns = int(r.uint(1))+1, int(r.uint(1))+1
+	size := 2
+	if flags&1 != 0 {
+		size = 4
+	}
+	img.Width, img.Height = int(r.uint(size)), int(r.uint(size))
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid grid item %d", id)
+	}
+	for _, ref := range m.refs {
+		if ref.typ != "dimg" || ref.from != id {
+			continue
+		}
+		for _, tileID := range ref.to {
+			tile, err := m.image(tileID, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			img.Tiles = append(img.Tiles, tile)
+		}
+	}
+	if len(img.Tiles) != img.Rows*img.Columns {
+		return nil, fmt.Errorf("grid item %d has %d tiles, expected %d", id, len(img.Tiles), img.Rows*img.Columns)
+	}
+	return img, nil
+}
+
+func (m *heifMeta) itemType(id uint32) (string, bool) {
+	for _, item := range m.items {
+		if item.id == id {
+			return item.typ, true
+		}
+	}
+	return "", false
+}
+
+// itemOfType returns the ID of the first item of type typ.
+func (m *heifMeta) itemOfType(typ string) (uint32, bool) {
+	for _, item := range m.items {
+		if item.typ == typ {
+			return item.id, true
+		}
+	}
+	return 0, false
+}
+
+// itemData reads the bytes of item id, at most limit of them.
+func (m *heifMeta) itemData(id uint32, limit uint64) ([]byte, error) {
+	method, extents, err := heifItemLocation(m.iloc, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	for _, e := range extents {
+		if e[1] > limit || uint64(len(data))+e[1] > limit {
+			return nil, fmt.Errorf("item too large")
+		}
+		switch method {
+		case 0: // file offset
+			buf := make([]byte, e[1])
+			if _, err := m.f.ReadAt(buf, int64(e[0])); err != nil {
+				return nil, err
+			}
+			data = append(data, buf...)
+		case 1: // idat offset
+			if e[0]+e[1] > uint64(len(m.idat)) {
+				return nil, fmt.Errorf("item outside idat")
+			}
+			data = append(data, m.idat[e[0]:e[0]+e[1]]...)
+		default:
+			return nil, fmt.Errorf("unsupported construction method %d", method)
+		}
+	}
+	return data, nil
+}
+
+// parseProperties reads the ipco and ipma children of an iprp box.
+func (m *heifMeta) parseProperties(iprp []byte) {
+	for _, b := range boxes(iprp) {
+		switch b.typ {
+		case "ipco":
+			m.props = boxes(b.data)
+		case "ipma":
+			r := &byteReader{data: b.data}
+			version := r.uint(1)
+			flags := r.uint(3)
+			count := r.uint(4)
+			for i := uint64(0); i < count && r.err == nil; i++ {
+				var id uint64
+				if version < 1 {
+					id = r.uint(2)
+				} else {
+					id = r.uint(4)
+				}
+				n := r.uint(1)
+				for j := uint64(0); j < n && r.err == nil; j++ {
+					// The top bit marks essential properties
+					var index uint64
+					if flags&1 != 0 {
+						index = r.uint(2) & 0x7FFF
+					} else {
+						index = r.uint(1) & 0x7F
+					}
+					if r.err == nil {
+						m.assoc[uint32(id)] = append(m.assoc[uint32(id)], int(index))
+					}
+				}
+			}
+		}
+	}
+}
+
+// properties returns the properties associated with item id.
+func (m *heifMeta) properties(id uint32) []box {
+	var list []box
+	for _, index := range m.assoc[id] {
+		if index >= 1 && index <= len(m.props) {
+			list = append(list, m.props[index-1])
+		}
+	}
+	return list
+}
+
+// heifReferences parses an iref box.
+func heifReferences(iref []byte) []heifReference {
+	if len(iref) < 4 {
+		return nil
+	}
+	size := 2
+	if iref[0] != 0 {
+		size = 4
+	}
+	var refs []heifReference
+	for _, b := range boxes(iref[4:]) {
+		r := &byteReader{data: b.data}
+		ref := heifReference{typ: b.typ, from: uint32(r.uint(size))}
+		n := r.uint(2)
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			ref.to = append(ref.to, uint32(r.uint(size)))
+		}
+		if r.err == nil {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// heifItems lists the items of an iinf box.
+func heifItems(iinf []byte) []heifItem {
+	if len(iinf) < 6 {
+		return nil
+	}
+	entries := iinf[6:]
+	if iinf[0] != 0 {
+		if len(iinf) < 8 {
+			return nil
+		}
+		entries = iinf[8:]
+	}
+	var items []heifItem
+	for _, b := range boxes(entries) {
+		// infe version 2 has 16-bit item IDs, version 3 32-bit ones
+		if b.typ != "infe" || len(b.data) < 4 {
+			continue
+		}
+		switch version, d := b.data[0], b.data[4:]; {
+		case version == 2 && len(d) >= 8:
+			items = append(items, heifItem{id: uint32(binary.BigEndian.Uint16(d[:2])), typ: string(d[4:8])})
+		case version == 3 && len(d) >= 10:
+			items = append(items, heifItem{id: binary.BigEndian.Uint32(d[:4]), typ: string(d[6:10])})
+		}
+	}
+	return items
+}
+
+// heifItemLocation returns the construction method and the (offset, length)
+// extents of item id in an iloc box.
+func heifItemLocation(iloc []byte, id uint32) (int, [][2]uint64, error) {
+	r := &byteReader{data: iloc}
+	version := r.uint(1)
+	r.skip(3)
+	sizes := r.uint(2)
+	offsetSize, lengthSize := int(sizes>>12&0xF), int(sizes>>8&0xF)
+	baseOffsetSize, indexSize := int(sizes>>4&0xF), int(sizes&0xF)
+	if version == 0 {
+		indexSize = 0
+	}
+
+	var count uint64
+	if version < 2 {
+		count = r.uint(2)
+	} else {
+		count = r.uint(4)
+	}
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		var itemID uint64
+		if version < 2 {
+			itemID = r.uint(2)
+		} else {
+			itemID = r.uint(4)
+		}
+		method := 0
+		if version > 0 {
+			method = int(r.uint(2) & 0xF)
+		}
+		r.skip(2) // data reference index
+		base := r.uint(baseOffsetSize)
+		extentCount := r.uint(2)
+
+		var extents [][2]uint64
+		for j := uint64(0); j < extentCount && r.err == nil; j++ {
+			r.skip(indexSize)
+			offset := r.uint(offsetSize)
+			length := r.uint(lengthSize)
+			extents = append(extents, [2]uint64{base + offset, length})
+		}
+		if uint32(itemID) == id && r.err == nil {
+			return method, extents, nil
+		}
+	}
+	if r.err != nil {
+		return 0, nil, fmt.Errorf("invalid iloc box: %w", r.err)
+	}
+	return 0, nil, fmt.Errorf("item %d has no location", id)
+}
+
+// byteReader reads big-endian integers of any width up to 8 bytes. The
+// first read past the end sets err; later reads return 0.
+type byteReader struct {
+	data []byte
+	err  error
+}
+
+func (r *byteReader) uint(n int) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if n > len(r.data) || n > 8 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	var v uint64
+	for _, b := range r.data[:n] {
+		v = v<<8 | uint64(b)
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *byteReader) skip(n int) {
+	if r.err != nil {
+		return
+	}
+	if n > len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return
+	}
+	r.data = r.data[n:]
+}
@@ -0,0 +1,39 @@
+package exif
+
+import "testing"
+
+func TestHEIFPreview(t *testing.T) {
+	path := "../../test_data/source_icloud/IMG_8299.HEIC"
+	img, err := HEIFPreview(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The iPhone stores an HEVC thumbnail of the rotated photo
+	if img.Type != "hvc1" || img.Width != 320 || img.Height != 240 || img.Rotation != 3 {
+		t.Errorf("Unexpected thumbnail %s %dx%d, rotation %d", img.Type, img.Width, img.Height, img.Rotation)
+	}
+	if len(img.Config) == 0 || len(img.Data) == 0 {
+		t.Errorf("Expected the decoder configuration and coded data, got %d and %d bytes", len(img.Config), len(img.Data))
+	}
+
+	// The primary image is a grid of 512x512 tiles
+	f, meta, err := openHEIF(path)
+	if err != nil || meta == nil {
+		t.Fatalf("Failed to read meta box: %v", err)
+	}
+	defer f.Close()
+	primary, err := meta.image(meta.primary, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if primary.Type != "grid" || primary.Width != 4032 || primary.Height != 3024 || primary.Rows != 6 || primary.Columns != 8 || len(primary.Tiles) != 48 {
+		t.Errorf("Unexpected primary image %s %dx%d, %dx%d tiles", primary.Type, primary.Width, primary.Height, primary.Rows, primary.Columns)
+	}
+	if tile := primary.Tiles[0]; tile.Type != "hvc1" || tile.Width != 512 || len(tile.Config) == 0 {
+		t.Errorf("Unexpected tile %s %dx%d", tile.Type, tile.Width, tile.Height)
+	}
+
+	if img, err := HEIFPreview("../../test_data/source_digital_camera/RIMG0018.JPG"); img != nil || err != nil {
+		t.Errorf("Expected no preview for a JPEG, got %v, %v", img, err)
+	}
+}
@@ -0,0 +1,115 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// appleContentIdentifier returns the Live Photo identifier from Apple's
+// maker note (tag 0x0011), or "" if there is none.
+func appleContentIdentifier(x *exif.Exif) string {
+	tag, err := x.Get(exif.MakerNote)
+	if err != nil {
+		return ""
+	}
+	return parseAppleContentIdentifier(tag.Val)
+}
+
+func parseAppleContentIdentifier(note []byte) string {
+	// "Apple iOS\0", version, byte order, then an IFD whose offsets are
+	// relative to the start of the note
+	if len(note) < 16 || !bytes.HasPrefix(note, []byte("Apple iOS\x00")) {
+		return ""
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if string(note[12:14]) == "II" {
+		order = binary.LittleEndian
+	}
+	count := int(order.Uint16(note[14:16]))
+	for i := 0; i < count; i++ {
+		entry := 16 + i*12
+		if entry+12 > len(note) {
+			return ""
+		}
+		if order.Uint16(note[entry:]) != 0x0011 || order.Uint16(note[entry+2:]) != 2 {
+			continue
+		}
+		n := int(order.Uint32(note[entry+4:]))
+		value := note[entry+8 : entry+12]
+		if n > 4 {
+			offset := int(order.Uint32(note[entry+8:]))
+			if offset < 0 || offset+n > len(note) {
+				return ""
+			}
+			value = note[offset : offset+n]
+		} else {
+			value = value[:n]
+		}
+		return strings.TrimRight(string(value), "\x00 ")
+	}
+	return ""
+}
+
+// EmbeddedVideo returns where the video of a motion photo is stored inside
+// the JPEG at path. Google cameras append the MP4 and record its position in
+// the XMP (GCamera:MicroVideoOffset); Samsung and newer Google phones append
+// it as well, so files without the XMP property are searched for an MP4 after
+// the image. A zero length means the file has no embedded video.
+func EmbeddedVideo(path string) (offset, length int64, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0, 0, nil
+	}
+
+	if packet, _ := readEmbeddedXMP(path); packet != nil {
+		if x, _ := parseXMP(packet); x != nil && x.microVideoOffset > 0 && x.microVideoOffset < int64(len(data)) {
+			start := int64(len(data)) - x.microVideoOffset
+			if n := mp4Length(data[start:]); n > 0 {
+				return start, n, nil
+			}
+		}
+	}
+
+	for from := 2; ; {
+		i := bytes.Index(data[from:], []byte("ftyp"))
+		if i < 0 {
+			return 0, 0, nil
+		}
+		start := from + i - 4
+		if start >= 2 {
+			if n := mp4Length(data[start:]); n > 0 {
+				return int64(start), n, nil
+			}
+		}
+		from += i + 4
+	}
+}
+
+// mp4Length returns the length of the MP4 at the start of data: an ftyp box
+// followed by boxes including moov. It returns 0 if data does not start with
+// one.
+func mp4Length(data []byte) int64 {
+	list := boxes(data)
+	if len(list) < 2 || list[0].typ != "ftyp" {
+		return 0
+	}
+	var length int64
+	var moov bool
+	for _, b := range list {
+		if b.typ == "moov" {
+			moov = true
+		}
+		length += int64(b.size)
+	}
+	if !moov {
+		return 0
+	}
+	return length
+}
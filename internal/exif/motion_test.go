@@ -0,0 +1,51 @@
+package exif
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+func TestParseAppleContentIdentifier(t *testing.T) {
+	id := "5A3E1D2C-7B8F-4C3A-9E21-0F6D4B7C8A90"
+	note := append([]byte("Apple iOS\x00\x00\x01MM"), 0, 2)
+	// An unrelated tag, then ContentIdentifier pointing behind the IFD
+	note = append(note, 0, 0x08, 0, 9, 0, 0, 0, 1, 0, 0, 0, 7)
+	note = binary.BigEndian.AppendUint16(note, 0x0011)
+	note = binary.BigEndian.AppendUint16(note, 2)
+	note = binary.BigEndian.AppendUint32(note, uint32(len(id)+1))
+	note = binary.BigEndian.AppendUint32(note, uint32(len(note)+4))
+	note = append(append(note, id...), 0)
+
+	if got := parseAppleContentIdentifier(note); got != id {
+		t.Errorf("Expected %q, got %q", id, got)
+	}
+	if got := parseAppleContentIdentifier([]byte("Nikon\x00\x02\x10\x00\x00MM\x00*")); got != "" {
+		t.Errorf("Expected no identifier in another maker note, got %q", got)
+	}
+}
+
+func TestEmbeddedVideo(t *testing.T) {
+	path := copyTestFile(t, "source_digital_camera/RIMG0018.JPG")
+	still, _ := os.ReadFile(path)
+	video := testVideo("+52.5200+013.4050/")
+	// Samsung writes a marker and trailer data after the video
+	os.WriteFile(path, append(append(append(append([]byte{}, still...), video...), "MotionPhoto_Data"...), make([]byte, 24)...), 0644)
+
+	offset, length, err := EmbeddedVideo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != int64(len(still)) || length != int64(len(video)) {
+		t.Errorf("Expected the video at %d+%d, got %d+%d", len(still), len(video), offset, length)
+	}
+	m, err := ExtractMetadata(path)
+	if err != nil || !m.MotionPhoto || m.Video {
+		t.Errorf("Expected a motion photo, got %+v, %v", m, err)
+	}
+
+	// A plain JPEG has none
+	if _, length, err := EmbeddedVideo("../../test_data/source_digital_camera/RIMG0020.JPG"); err != nil || length != 0 {
+		t.Errorf("Expected no embedded video, got %d, %v", length, err)
+	}
+}
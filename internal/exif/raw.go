@@ -0,0 +1,194 @@
+package exif
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// Camera raw files (CR2, NEF, ARW, DNG) are TIFF files, so their EXIF is
+// read like any other. Besides the sensor data they carry JPEG previews,
+// referenced from IFD0, the IFDs chained after it or their SubIFDs:
+//
+//	JPEGInterchangeFormat/Length              NEF, ARW and DNG previews
+//	StripOffsets/ByteCounts, JPEG compression CR2 IFD0, DNG previews
+//
+// Lossless JPEG sensor data is stored the same way, so only baseline and
+// progressive JPEGs count as previews.
+
+// TIFF tags describing the images of a raw file
+const (
+	tagImageWidth                  = 0x0100
+	tagImageLength                 = 0x0101
+	tagCompression                 = 0x0103
+	tagStripOffsets                = 0x0111
+	tagStripByteCounts             = 0x0117
+	tagSubIFDs                     = 0x014A
+	tagJPEGInterchangeFormat       = 0x0201
+	tagJPEGInterchangeFormatLength = 0x0202
+)
+
+// maxRawIFDs bounds the IFDs visited in a raw file, guarding against loops.
+const maxRawIFDs = 64
+
+// tiffData holds what photoo reads from the IFDs of a TIFF structure.
+type tiffData struct {
+	preview []byte // largest displayable JPEG, nil if none
+	width   int    // largest image, usually the sensor data
+	height  int
+}
+
+// IsRaw reports whether the file at path is a TIFF-based camera raw file,
+// judging by its content.
+func IsRaw(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var head [4]byte
+	if _, err := io.ReadFull(f, head[:]); err != nil {
+		return false
+	}
+	return string(head[:]) == "II*\x00" || string(head[:]) == "MM\x00*"
+}
+
+// RawPreview returns the largest JPEG preview embedded in the raw file at
+// path, or nil if it has none.
+func RawPreview(path string) ([]byte, error) {
+	r, err := readRaw(path)
+	if err != nil {
+		return nil, err
+	}
+	return r.preview, nil
+}
+
+// readRaw walks the IFDs of the raw file at path.
+func readRaw(path string) (*tiffData, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return tiffImages(data), nil
+}
+
+// tiffImages walks the IFDs of a TIFF structure, such as a raw file or an
+// EXIF block, for its largest image and JPEG preview.
+func tiffImages(data []byte) *tiffData {
+	r := &tiffData{}
+	t := &tiffBlock{buf: data}
+	switch {
+	case len(data) < 8:
+		return r
+	case string(data[:4]) == "II*\x00":
+		t.order = binary.LittleEndian
+	case string(data[:4]) == "MM\x00*":
+		t.order = binary.BigEndian
+	default:
+		return r
+	}
+
+	queue := []uint32{t.order.Uint32(data[4:])}
+	visited := map[uint32]bool{}
+	for len(queue) > 0 && len(visited) < maxRawIFDs {
+		offset := queue[0]
+		queue = queue[1:]
+		if offset == 0 || visited[offset] {
+			continue
+		}
+		visited[offset] = true
+		entries, next, err := t.readIFD(offset)
+		if err != nil {
+			continue
+		}
+		queue = append(queue, next)
+
+		tags := map[uint16][]uint32{}
+		for _, e := range entries {
+			tags[e.tag] = t.values(e)
+		}
+		queue = append(queue, tags[tagSubIFDs]...)
+
+		if w, h := first(tags[tagImageWidth]), first(tags[tagImageLength]); w*h > uint64(r.width)*uint64(r.height) {
+			r.width, r.height = int(w), int(h)
+		}
+		var candidates [][2]uint64
+		if start, n := first(tags[tagJPEGInterchangeFormat]), first(tags[tagJPEGInterchangeFormatLength]); n > 0 {
+			candidates = append(candidates, [2]uint64{start, n})
+		}
+		if c := first(tags[tagCompression]); (c == 6 || c == 7) && len(tags[tagStripOffsets]) == 1 {
+			candidates = append(candidates, [2]uint64{first(tags[tagStripOffsets]), first(tags[tagStripByteCounts])})
+		}
+		for _, c := range candidates {
+			if c[0]+c[1] > uint64(len(data)) || c[1] <= uint64(len(r.preview)) {
+				continue
+			}
+			if jpeg := data[c[0] : c[0]+c[1]]; isDisplayableJPEG(jpeg) {
+				r.preview = jpeg
+			}
+		}
+	}
+	return r
+}
+
+// values decodes the SHORT, LONG or IFD values of an entry.
+func (t *tiffBlock) values(e ifdEntry) []uint32 {
+	var size uint64
+	switch e.typ {
+	case 3:
+		size = 2
+	case 4, 13:
+		size = 4
+	default:
+		return nil
+	}
+	raw := e.value[:]
+	if n := size * uint64(e.count); n > 4 {
+		offset := uint64(t.order.Uint32(e.value[:]))
+		if offset+n > uint64(len(t.buf)) {
+			return nil
+		}
+		raw = t.buf[offset : offset+n]
+	}
+	values := make([]uint32, 0, e.count)
+	for i := uint64(0); i+size <= uint64(len(raw)) && len(values) < int(e.count); i += size {
+		if size == 2 {
+			values = append(values, uint32(t.order.Uint16(raw[i:])))
+		} else {
+			values = append(values, t.order.Uint32(raw[i:]))
+		}
+	}
+	return values
+}
+
+func first(values []uint32) uint64 {
+	if len(values) == 0 {
+		return 0
+	}
+	return uint64(values[0])
+}
+
+// isDisplayableJPEG reports whether data is a baseline or progressive JPEG,
+// as opposed to the lossless JPEG raw files store sensor data in.
+func isDisplayableJPEG(data []byte) bool {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return false
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return false
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			i++
+			continue
+		case marker == 0xC0 || marker == 0xC1 || marker == 0xC2:
+			return true
+		case marker >= 0xC3 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC, marker == 0xDA:
+			return false
+		}
+		i += 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tiffBuilder lays out a little-endian TIFF file: blobs and IFDs are
+// appended in the order they are added, so IFDs must be added after the
+// ones they point to.
+type tiffBuilder struct {
+	buf []byte
+}
+
+type tiffField struct {
+	tag, typ uint16
+	count    uint32
+	data     []byte
+}
+
+func shortField(tag uint16, v uint16) tiffField {
+	return tiffField{tag, 3, 1, binary.LittleEndian.AppendUint16(nil, v)}
+}
+
+func longField(tag uint16, values ...uint32) tiffField {
+	var data []byte
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint32(data, v)
+	}
+	return tiffField{tag, 4, uint32(len(values)), data}
+}
+
+func asciiField(tag uint16, s string) tiffField {
+	return tiffField{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func newTIFFBuilder() *tiffBuilder {
+	return &tiffBuilder{buf: []byte("II*\x00\x00\x00\x00\x00")}
+}
+
+func (b *tiffBuilder) blob(data []byte) uint32 {
+	if len(b.buf)%2 == 1 {
+		b.buf = append(b.buf, 0)
+	}
+	offset := uint32(len(b.buf))
+	b.buf = append(b.buf, data...)
+	return offset
+}
+
+func (b *tiffBuilder) ifd(next uint32, fields ...tiffField) uint32 {
+	values := make([][]byte, len(fields))
+	for i, f := range fields {
+		values[i] = append(f.data, make([]byte, 4)...)[:4]
+		if len(f.data) > 4 {
+			values[i] = binary.LittleEndian.AppendUint32(nil, b.blob(f.data))
+		}
+	}
+	ifd := binary.LittleEndian.AppendUint16(nil, uint16(len(fields)))
+	for i, f := range fields {
+		ifd = binary.LittleEndian.AppendUint16(ifd, f.tag)
+		ifd = binary.LittleEndian.AppendUint16(ifd, f.typ)
+		ifd = binary.LittleEndian.AppendUint32(ifd, f.count)
+		ifd = append(ifd, values[i]...)
+	}
+	return b.blob(binary.LittleEndian.AppendUint32(ifd, next))
+}
+
+func (b *tiffBuilder) bytes(ifd0 uint32) []byte {
+	binary.LittleEndian.PutUint32(b.buf[4:], ifd0)
+	return b.buf
+}
+
+func TestRawFile(t *testing.T) {
+	preview, _ := os.ReadFile("../../test_data/source_digital_camera/RIMG0018.JPG")
+	thumbnail, _ := os.ReadFile("../../test_data/source_digital_camera/RIMG0020.JPG")
+	thumbnail = thumbnail[:len(preview)/2]
+	// Lossless JPEG sensor data, larger than any preview
+	sensor := append([]byte{0xFF, 0xD8, 0xFF, 0xC3, 0, 2}, make([]byte, 2*len(preview))...)
+
+	b := newTIFFBuilder()
+	previewAt, thumbnailAt, sensorAt := b.blob(preview), b.blob(thumbnail), b.blob(sensor)
+	raw := b.ifd(0, longField(tagImageWidth, 6000), longField(tagImageLength, 4000), shortField(tagCompression, 7),
+		longField(tagStripOffsets, sensorAt), longField(tagStripByteCounts, uint32(len(sensor))))
+	jpeg := b.ifd(0, longField(tagJPEGInterchangeFormat, previewAt), longField(tagJPEGInterchangeFormatLength, uint32(len(preview))))
+	exifIFD := b.ifd(0, asciiField(tagDateTimeOriginal, "2023:04:05 06:07:08"))
+	ifd0 := b.ifd(0,
+		longField(tagImageWidth, 160), longField(tagImageLength, 120),
+		asciiField(0x010F, "NIKON CORPORATION"), asciiField(0x0110, "NIKON D750"),
+		longField(tagSubIFDs, raw, jpeg),
+		longField(tagJPEGInterchangeFormat, thumbnailAt), longField(tagJPEGInterchangeFormatLength, uint32(len(thumbnail))),
+		longField(tagExifIFD, exifIFD))
+	path := filepath.Join(t.TempDir(), "DSC_0001.NEF")
+	os.WriteFile(path, b.bytes(ifd0), 0644)
+
+	if !IsRaw(path) || IsVideo(path) {
+		t.Fatal("Expected the file to be recognized as a raw file")
+	}
+	got, err := RawPreview(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, preview) {
+		t.Errorf("Expected the largest displayable preview (%d bytes), got %d bytes", len(preview), len(got))
+	}
+
+	m, err := ExtractMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CameraMake != "NIKON CORPORATION" || m.CameraModel != "NIKON D750" || m.DateTaken.Format("2006-01-02 15:04:05") != "2023-04-05 06:07:08" {
+		t.Errorf("Unexpected camera and date: %q, %q, %s", m.CameraMake, m.CameraModel, m.DateTaken)
+	}
+	if m.Width != 6000 || m.Height != 4000 || m.MotionPhoto || m.Video {
+		t.Errorf("Unexpected size %dx%d or media flags %+v", m.Width, m.Height, m)
+	}
+
+	if IsRaw("../../test_data/source_digital_camera/RIMG0018.JPG") {
+		t.Error("Expected JPEG not to be a raw file")
+	}
+}
@@ -0,0 +1,182 @@
+package exif
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Google Takeout names the JSON sidecar of a photo after its title, the name
+// the photo had when it was uploaded:
+//
+//	IMG_1234.jpg.supplemental-metadata.json   current exports
+//	IMG_1234.jpg.json                         older exports
+//	IMG_1234.jpg.supplemental-metada.json     name cut to 51 characters
+//	IMG_1234.jpg.supp.json                    suffix cut in other places
+//	IMG_1234.jpg.supplemental-metadata(1).json for IMG_1234(1).jpg
+//
+// Edited copies (IMG_1234-edited.jpg) have no sidecar of their own and use
+// the original's.
+const (
+	takeoutSuffix = ".supplemental-metadata"
+	// takeoutStemLength is the length Takeout cuts sidecar names to, not
+	// counting the "(1)" counter and ".json".
+	takeoutStemLength = 46
+)
+
+// takeoutCounter matches a "(1)" counter Takeout adds to repeated titles.
+var takeoutCounter = regexp.MustCompile(`\(\d+\)$`)
+
+// takeoutEdited matches the suffixes Takeout adds to edited copies, in the
+// languages of the Google Photos interface.
+var takeoutEdited = regexp.MustCompile(`(?i)-(edited|bearbeitet|modifié|modificato|editado|editada|bewerkt|redigerad|redigeret|muokattu|edytowane|upraveno|szerkesztett|düzenlendi|изменено|編集済み|已编辑|已編輯|편집됨)$`)
+
+// findTakeoutSidecar returns the Google Takeout JSON sidecar of a photo, or
+// "" if it has none.
+func findTakeoutSidecar(path string) string {
+	dir, name := filepath.Split(path)
+	index := takeoutIndexOf(filepath.Clean(dir))
+	if index == nil {
+		return ""
+	}
+	if sidecar := index.Match(name); sidecar != "" {
+		return filepath.Join(dir, sidecar)
+	}
+	return ""
+}
+
+// TakeoutIndex finds the Takeout sidecars of photos among the JSON files of
+// one folder, e.g. a folder spread over the parts of an archive.
+type TakeoutIndex struct {
+	sidecars []takeoutSidecar
+}
+
+// Add records a JSON file name. Other files are ignored.
+func (ix *TakeoutIndex) Add(name string) {
+	if !strings.EqualFold(filepath.Ext(name), ".json") {
+		return
+	}
+	stem := name[:len(name)-len(".json")]
+	counter := takeoutCounter.FindString(stem)
+	ix.sidecars = append(ix.sidecars, takeoutSidecar{name: name, stem: strings.TrimSuffix(stem, counter), counter: counter})
+}
+
+// Match returns the name of the sidecar of the photo named name, or "" if
+// it has none.
+func (ix *TakeoutIndex) Match(name string) string {
+	for _, c := range takeoutTitles(name) {
+		for _, s := range ix.sidecars {
+			if s.counter == c.counter && s.matches(c.title) {
+				return s.name
+			}
+		}
+	}
+	return ""
+}
+
+// takeoutTitle is a title a photo may have had in Google Photos, with the
+// counter Takeout moved from the photo's name into the sidecar's.
+type takeoutTitle struct {
+	title   string
+	counter string
+}
+
+// takeoutTitles returns the titles a photo file may belong to, most likely
+// first: its name, its name with the "(1)" counter moved out, its name
+// without an edited suffix, and its name without the extension.
+func takeoutTitles(name string) []takeoutTitle {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	titles := []takeoutTitle{{title: name}}
+
+	counter := takeoutCounter.FindString(base)
+	if counter != "" {
+		base = strings.TrimSuffix(base, counter)
+		titles = append(titles, takeoutTitle{title: base + ext, counter: counter})
+	}
+	if original := takeoutEdited.ReplaceAllString(base, ""); original != base {
+		titles = append(titles, takeoutTitle{title: original + ext, counter: counter})
+		if counter != "" {
+			titles = append(titles, takeoutTitle{title: original + ext})
+		}
+	}
+	if ext != "" {
+		titles = append(titles, takeoutTitle{title: base, counter: counter})
+	}
+	return titles
+}
+
+// takeoutSidecar is a JSON file name split into the stem derived from the
+// title and the counter.
+type takeoutSidecar struct {
+	name    string
+	stem    string
+	counter string
+}
+
+// matches reports whether the sidecar belongs to title: its stem is the
+// title, followed by all or part of the supplemental-metadata suffix, or is
+// the start of both cut to takeoutStemLength.
+func (s takeoutSidecar) matches(title string) bool {
+	if !strings.HasPrefix(title+takeoutSuffix, s.stem) {
+		return false
+	}
+	return len(s.stem) >= len(title) || utf8.RuneCountInString(s.stem) >= takeoutStemLength
+}
+
+// takeoutCache keeps the sidecars of the most recently scanned directories,
+// so importing a folder lists it once rather than once per photo. Entries
+// are dropped when the directory changes.
+var takeoutCache = struct {
+	sync.Mutex
+	dirs map[string]takeoutDir
+}{dirs: make(map[string]takeoutDir)}
+
+type takeoutDir struct {
+	modTime time.Time
+	index   *TakeoutIndex
+}
+
+const takeoutCacheSize = 32
+
+// takeoutIndexOf returns the index of the JSON files in dir, or nil if it
+// has none.
+func takeoutIndexOf(dir string) *TakeoutIndex {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil
+	}
+
+	takeoutCache.Lock()
+	cached, ok := takeoutCache.dirs[dir]
+	takeoutCache.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.index
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	index := &TakeoutIndex{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			index.Add(e.Name())
+		}
+	}
+	if len(index.sidecars) == 0 {
+		index = nil
+	}
+
+	takeoutCache.Lock()
+	if len(takeoutCache.dirs) >= takeoutCacheSize {
+		takeoutCache.dirs = make(map[string]takeoutDir)
+	}
+	takeoutCache.dirs[dir] = takeoutDir{modTime: info.ModTime(), index: index}
+	takeoutCache.Unlock()
+	return index
+}
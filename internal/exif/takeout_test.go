@@ -0,0 +1,115 @@
+package exif
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// takeoutCorpus maps photo names to the sidecar Takeout wrote for them, all
+// taken from a single export folder. An empty sidecar means the photo has
+// none, even though similarly named sidecars exist.
+var takeoutCorpus = []struct {
+	photo   string
+	sidecar string
+}{
+	// Current naming, and the name cut to 51 characters
+	{"IMG_1002.jpg", "IMG_1002.jpg.supplemental-metadata.json"},
+	{"IMG_20211022_084955842.jpg", "IMG_20211022_084955842.jpg.supplemental-metada.json"},
+	// Older exports
+	{"IMG_1001.jpg", "IMG_1001.jpg.json"},
+	{"IMG_1005.jpg", "IMG_1005.json"},
+	// The suffix cut short elsewhere
+	{"IMG_1004.JPG", "IMG_1004.JPG.supp.json"},
+	{"VID_1008.mp4", "VID_1008.mp4.s.json"},
+	// Long titles are cut into the title itself
+	{"Screenshot_20230512-154233_Google Maps Navigation.png", "Screenshot_20230512-154233_Google Maps Navigat.json"},
+	{"20190823_163512_Original Photo From Holiday.jpg", "20190823_163512_Original Photo From Holiday.jp.json"},
+	// Counters move behind the suffix
+	{"IMG_1002(1).jpg", "IMG_1002.jpg.supplemental-metadata(1).json"},
+	{"20190823_163512_Original Photo From Holiday(1).jpg", "20190823_163512_Original Photo From Holiday.jp(1).json"},
+	// Edited copies share the original's sidecar
+	{"IMG_1003.jpg", "IMG_1003.jpg.supplemental-metadata.json"},
+	{"IMG_1003-edited.jpg", "IMG_1003.jpg.supplemental-metadata.json"},
+	{"IMG_1006-bearbeitet(2).jpg", "IMG_1006.jpg.supplemental-metadata(2).json"},
+	// Lookalikes
+	{"IMG_100.jpg", ""},
+	{"IMG_1007.jpg", ""},
+	{"IMG_1007(1).jpg", "IMG_1007.jpg.supplemental-metadata(1).json"},
+	{"IMG_10021.jpg", ""},
+}
+
+func TestFindTakeoutSidecar(t *testing.T) {
+	dir := t.TempDir()
+	for _, c := range takeoutCorpus {
+		os.WriteFile(filepath.Join(dir, c.photo), []byte("photo"), 0644)
+		if c.sidecar != "" {
+			os.WriteFile(filepath.Join(dir, c.sidecar), []byte("{}"), 0644)
+		}
+	}
+
+	for _, c := range takeoutCorpus {
+		got := findTakeoutSidecar(filepath.Join(dir, c.photo))
+		if got != "" {
+			got = filepath.Base(got)
+		}
+		if got != c.sidecar {
+			t.Errorf("Sidecar of %q: expected %q, got %q", c.photo, c.sidecar, got)
+		}
+	}
+}
+
+func TestTakeoutSidecarDetails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "IMG_1234(1).jpg")
+	os.WriteFile(path, []byte("photo"), 0644)
+	os.WriteFile(filepath.Join(dir, "IMG_1234.jpg.supplemental-metadata(1).json"), []byte(`{
+		"title": "Harbour at dusk",
+		"description": "Ferry leaving ",
+		"photoTakenTime": {"timestamp": "1600000000"},
+		"geoData": {"latitude": 0.0, "longitude": 0.0, "altitude": 0.0},
+		"geoDataExif": {"latitude": 59.3293, "longitude": 18.0686, "altitude": 12.5},
+		"people": [{"name": "Ada"}, {"name": " "}, {"name": "Grace"}],
+		"favorited": true
+	}`), 0644)
+
+	m, err := ExtractMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "Harbour at dusk" || m.Description != "Ferry leaving" || !m.Favorite {
+		t.Errorf("Unexpected title %q, description %q, favorite %v", m.Title, m.Description, m.Favorite)
+	}
+	if !reflect.DeepEqual(m.People, []string{"Ada", "Grace"}) {
+		t.Errorf("Unexpected people %v", m.People)
+	}
+	// geoData is empty, so the position comes from geoDataExif
+	if m.Latitude == nil || math.Abs(*m.Latitude-59.3293) > 1e-9 || m.Altitude == nil || *m.Altitude != 12.5 {
+		t.Errorf("Expected the geoDataExif position, got %v, %v", m.Latitude, m.Altitude)
+	}
+	if m.DateTaken.Unix() != 1600000000 {
+		t.Errorf("Unexpected date %v", m.DateTaken)
+	}
+
+	// A title that is just the file name is not kept
+	path = filepath.Join(dir, "IMG_5678.jpg")
+	os.WriteFile(path, []byte("photo"), 0644)
+	os.WriteFile(path+".supplemental-metadata.json", []byte(`{
+		"title": "IMG_5678.jpg",
+		"geoData": {"latitude": 48.1, "longitude": 11.5},
+		"geoDataExif": {"latitude": 59.3, "longitude": 18.0}
+	}`), 0644)
+	m, err = ExtractMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "" || m.Favorite {
+		t.Errorf("Expected no title and no favorite, got %q, %v", m.Title, m.Favorite)
+	}
+	// geoData includes edits made in Google Photos and wins
+	if m.Latitude == nil || *m.Latitude != 48.1 {
+		t.Errorf("Expected the geoData position, got %v", m.Latitude)
+	}
+}
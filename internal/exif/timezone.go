@@ -0,0 +1,119 @@
+package exif
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	_ "time/tzdata" // zone rules for GPS-derived zones on systems without zoneinfo
+
+	"github.com/bradfitz/latlong"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// EXIF 2.31 offset tags. goexif does not know them and drops unknown tags,
+// so offsetParser loads them from the Exif IFD.
+const (
+	offsetTime         exif.FieldName = "OffsetTime"
+	offsetTimeOriginal exif.FieldName = "OffsetTimeOriginal"
+)
+
+var offsetFields = map[uint16]exif.FieldName{
+	tagOffsetTime:         offsetTime,
+	tagOffsetTimeOriginal: offsetTimeOriginal,
+}
+
+func init() {
+	exif.RegisterParsers(offsetParser{})
+}
+
+type offsetParser struct{}
+
+// Parse loads the offset tags. A missing or unreadable Exif IFD is not an
+// error; goexif's own parser reports it.
+func (offsetParser) Parse(x *exif.Exif) error {
+	tag, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return nil
+	}
+	offset, err := tag.Int64(0)
+	if err != nil {
+		return nil
+	}
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil
+	}
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil
+	}
+	x.LoadTags(dir, offsetFields, false)
+	return nil
+}
+
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// exifDateTime returns the capture wall-clock time (DateTimeOriginal, else
+// DateTime) parsed in UTC as a placeholder zone, and the offset recorded for
+// it, or nil if the file does not say which zone the camera clock was in.
+func exifDateTime(x *exif.Exif) (time.Time, *time.Location, error) {
+	field, offsetField := exif.DateTimeOriginal, offsetTimeOriginal
+	value := tagString(x, field)
+	if value == "" {
+		field, offsetField = exif.DateTime, offsetTime
+		value = tagString(x, field)
+	}
+	if value == "" {
+		return time.Time{}, nil, fmt.Errorf("no capture date in EXIF")
+	}
+	wall, err := time.Parse(exifTimeLayout, value)
+	if err != nil {
+		return time.Time{}, nil, fmt.Errorf("invalid %s %q: %w", field, value, err)
+	}
+
+	for _, name := range []exif.FieldName{offsetField, offsetTime} {
+		if loc, err := parseOffset(tagString(x, name)); err == nil {
+			return wall, loc, nil
+		}
+	}
+	if loc, err := x.TimeZone(); err == nil {
+		return wall, loc, nil
+	}
+	return wall, nil, nil
+}
+
+// parseOffset parses an EXIF offset such as "+09:00" or "-03:30".
+func parseOffset(s string) (*time.Location, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("empty offset")
+	}
+	t, err := time.Parse("-07:00", s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid offset %q", s)
+	}
+	_, offset := t.Zone()
+	return time.FixedZone("", offset), nil
+}
+
+// ZoneAt returns the time zone in effect at a location, looked up in the
+// embedded zone boundary data. It returns nil for open sea and unknown zones.
+func ZoneAt(lat, lon float64) *time.Location {
+	name := latlong.LookupZoneName(lat, lon)
+	if name == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
+
+// withWallClock returns the time with the wall clock of t in loc.
+func withWallClock(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
@@ -0,0 +1,380 @@
+package exif
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Videos (MP4, MOV, M4V) are ISO base media files: a tree of boxes, each
+// starting with a 32-bit size and a four-character type. The metadata lives
+// in the moov box:
+//
+//	moov/mvhd                 creation time and duration
+//	moov/trak/tkhd            track size and rotation
+//	moov/udta/©xyz            GPS position (ISO 6709)
+//	moov/udta/meta/ilst/covr  cover image
+//	moov/meta/keys + ilst     Apple's creation date and position
+//
+// Only the moov box is read; the media data is skipped.
+
+// quickTimeEpoch is the origin of mvhd times.
+var quickTimeEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// heifBrands are ftyp brands of still images that share the box format.
+var heifBrands = map[string]bool{
+	"heic": true, "heix": true, "heim": true, "heis": true, "hevc": true, "hevx": true,
+	"mif1": true, "msf1": true, "avif": true, "avis": true,
+}
+
+// maxMoovSize bounds the moov box read into memory.
+const maxMoovSize = 64 << 20
+
+// videoData holds what photoo reads from a video's boxes.
+type videoData struct {
+	created     time.Time // UTC, zero if unknown
+	date        time.Time // Apple creation date with its offset
+	duration    float64   // seconds
+	width       int
+	height      int
+	orientation int
+	latitude    *float64
+	longitude   *float64
+	altitude    *float64
+	cover       []byte
+	contentID   string // Apple's Live Photo identifier
+}
+
+// IsVideo reports whether the file at path is an MP4 or QuickTime video,
+// judging by its content.
+func IsVideo(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var head [12]byte
+	if _, err := io.ReadFull(f, head[:]); err != nil {
+		return false
+	}
+	switch string(head[4:8]) {
+	case "ftyp":
+		return !heifBrands[string(head[8:12])]
+	case "moov", "mdat", "wide", "free", "skip":
+		// QuickTime files written before ftyp existed
+		return true
+	}
+	return false
+}
+
+// VideoCover returns the cover image embedded in a video, or nil if it has
+// none.
+func VideoCover(path string) ([]byte, error) {
+	v, err := readVideo(path)
+	if err != nil {
+		return nil, err
+	}
+	return v.cover, nil
+}
+
+// readVideo parses the moov box of the video at path.
+func readVideo(path string) (*videoData, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	for {
+		typ, size, err := readBoxHeader(f)
+		if err == io.EOF {
+			return nil, fmt.Errorf("no moov box found")
+		} else if err != nil {
+			return nil, err
+		}
+		if typ != "moov" {
+			if size < 0 {
+				return nil, fmt.Errorf("no moov box found")
+			}
+			if _, err := f.Seek(size, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if size < 0 || size > maxMoovSize {
+			return nil, fmt.Errorf("moov box too large")
+		}
+		moov := make([]byte, size)
+		if _, err := io.ReadFull(f, moov); err != nil {
+			return nil, fmt.Errorf("failed to read moov box: %w", err)
+		}
+		v := &videoData{}
+		v.parseMoov(moov)
+		return v, nil
+	}
+}
+
+// readBoxHeader reads a box header and returns the box type and the size of
+// its payload, -1 for a box that extends to the end of the file.
+func readBoxHeader(r io.Reader) (string, int64, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return "", 0, err
+	}
+	size := int64(binary.BigEndian.Uint32(hdr[:4]))
+	typ := string(hdr[4:8])
+	switch size {
+	case 0:
+		return typ, -1, nil
+	case 1:
+		var large [8]byte
+		if _, err := io.ReadFull(r, large[:]); err != nil {
+			return "", 0, err
+		}
+		size = int64(binary.BigEndian.Uint64(large[:])) - 16
+	default:
+		size -= 8
+	}
+	if size < 0 {
+		return "", 0, fmt.Errorf("invalid %q box size", typ)
+	}
+	return typ, size, nil
+}
+
+// box is a box read from memory.
+type box struct {
+	typ  string
+	data []byte
+	size int // including the header
+}
+
+// boxes splits data into the boxes it contains. A malformed box ends the
+// list.
+func boxes(data []byte) []box {
+	var list []box
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		typ := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return list
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return list
+		}
+		list = append(list, box{typ: typ, data: data[header:size], size: int(size)})
+		data = data[size:]
+	}
+	return list
+}
+
+func (v *videoData) parseMoov(moov []byte) {
+	for _, b := range boxes(moov) {
+		switch b.typ {
+		case "mvhd":
+			v.parseMvhd(b.data)
+		case "trak":
+			v.parseTrak(b.data)
+		case "udta":
+			v.parseUdta(b.data)
+		case "meta":
+			v.parseMeta(b.data)
+		}
+	}
+}
+
+func (v *videoData) parseMvhd(data []byte) {
+	var created uint64
+	var timescale uint32
+	var duration uint64
+	switch {
+	case len(data) >= 32 && data[0] == 1:
+		created = binary.BigEndian.Uint64(data[4:12])
+		timescale = binary.BigEndian.Uint32(data[20:24])
+		duration = binary.BigEndian.Uint64(data[24:32])
+	case len(data) >= 20:
+		created = uint64(binary.BigEndian.Uint32(data[4:8]))
+		timescale = binary.BigEndian.Uint32(data[12:16])
+		duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	default:
+		return
+	}
+	// Many cameras leave the creation time at zero
+	if created != 0 {
+		v.created = quickTimeEpoch.Add(time.Duration(created) * time.Second)
+	}
+	if timescale != 0 {
+		v.duration = float64(duration) / float64(timescale)
+	}
+}
+
+func (v *videoData) parseTrak(trak []byte) {
+	for _, b := range boxes(trak) {
+		if b.typ != "tkhd" {
+			continue
+		}
+		// The matrix and size follow the version-dependent times
+		offset := 40
+		if len(b.data) > 0 && b.data[0] == 1 {
+			offset = 52
+		}
+		if len(b.data) < offset+44 {
+			return
+		}
+		matrix := b.data[offset : offset+36]
+		width := int(binary.BigEndian.Uint32(b.data[offset+36:]) >> 16)
+		height := int(binary.BigEndian.Uint32(b.data[offset+40:]) >> 16)
+		// Audio tracks have no size; the first video track wins
+		if width == 0 || height == 0 || v.width != 0 {
+			return
+		}
+		v.width, v.height = width, height
+		v.orientation = matrixOrientation(matrix)
+	}
+}
+
+// matrixOrientation maps a track's transformation matrix to the EXIF
+// orientation with the same rotation. Phones record portrait videos in
+// landscape and rotate them on playback.
+func matrixOrientation(matrix []byte) int {
+	a := int32(binary.BigEndian.Uint32(matrix[0:4]))
+	b := int32(binary.BigEndian.Uint32(matrix[4:8]))
+	switch {
+	case a > 0 && b == 0:
+		return 1
+	case a == 0 && b > 0:
+		return 6 // 90° clockwise
+	case a < 0 && b == 0:
+		return 3
+	case a == 0 && b < 0:
+		return 8 // 90° counter-clockwise
+	}
+	return 0
+}
+
+func (v *videoData) parseUdta(udta []byte) {
+	for _, b := range boxes(udta) {
+		switch b.typ {
+		case "\xa9xyz":
+			// QuickTime text: 16-bit length, 16-bit language, text
+			if len(b.data) < 4 {
+				continue
+			}
+			n := int(binary.BigEndian.Uint16(b.data[:2]))
+			if 4+n > len(b.data) {
+				n = len(b.data) - 4
+			}
+			v.setISO6709(string(b.data[4 : 4+n]))
+		case "meta":
+			v.parseMeta(b.data)
+		}
+	}
+}
+
+// parseMeta reads the item list of a meta box. MP4 meta boxes carry a
+// version and flags before their children, QuickTime ones do not.
+func (v *videoData) parseMeta(meta []byte) {
+	if len(meta) < 8 {
+		return
+	}
+	switch string(meta[4:8]) {
+	case "hdlr", "keys", "ilst":
+	default:
+		meta = meta[4:]
+	}
+
+	var keys []string
+	for _, b := range boxes(meta) {
+		if b.typ == "keys" && len(b.data) >= 8 {
+			for _, k := range boxes(b.data[8:]) {
+				// The key namespace takes the place of the type
+				keys = append(keys, string(k.data))
+			}
+		}
+	}
+	for _, b := range boxes(meta) {
+		if b.typ != "ilst" {
+			continue
+		}
+		for _, item := range boxes(b.data) {
+			name := item.typ
+			if index := binary.BigEndian.Uint32([]byte(item.typ)); index >= 1 && int(index) <= len(keys) {
+				name = keys[index-1]
+			}
+			value := itemValue(item.data)
+			if value == nil {
+				continue
+			}
+			switch name {
+			case "covr":
+				if v.cover == nil {
+					v.cover = value
+				}
+			case "\xa9xyz", "com.apple.quicktime.location.ISO6709":
+				v.setISO6709(string(value))
+			case "com.apple.quicktime.content.identifier":
+				v.contentID = string(value)
+			case "com.apple.quicktime.creationdate":
+				if t, err := time.Parse("2006-01-02T15:04:05-0700", string(value)); err == nil {
+					v.date = t
+				} else if t, err := time.Parse(time.RFC3339, string(value)); err == nil {
+					v.date = t
+				}
+			}
+		}
+	}
+}
+
+// itemValue returns the payload of the data box of an item list entry.
+func itemValue(item []byte) []byte {
+	for _, b := range boxes(item) {
+		// Type indicator and locale precede the value
+		if b.typ == "data" && len(b.data) >= 8 {
+			return b.data[8:]
+		}
+	}
+	return nil
+}
+
+// iso6709 matches positions like "+52.5200+013.4050+034.000/".
+var iso6709 = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)?`)
+
+func (v *videoData) setISO6709(s string) {
+	m := iso6709.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return
+	}
+	lat, err1 := strconv.ParseFloat(m[1], 64)
+	lon, err2 := strconv.ParseFloat(m[2], 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return
+	}
+	v.latitude, v.longitude = &lat, &lon
+	if alt, err := strconv.ParseFloat(m[3], 64); err == nil {
+		v.altitude = &alt
+	}
+}
+
+// captureDate returns the best date of the video: Apple's creation date has
+// the local offset, mvhd only the instant.
+func (v *videoData) captureDate() captureDate {
+	if !v.date.IsZero() {
+		return captureDate{t: v.date, zone: v.date.Location()}
+	}
+	return captureDate{t: v.created, instant: true}
+}
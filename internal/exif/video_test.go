@@ -0,0 +1,91 @@
+package exif
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mp4Box builds a box from its type and payload.
+func mp4Box(typ string, payload ...[]byte) []byte {
+	var body []byte
+	for _, p := range payload {
+		body = append(body, p...)
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+func u32(values ...uint32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+// testVideo builds an MP4 recorded on 2022-06-01 10:00:00 UTC, 12.5 seconds
+// long, 1920x1080 rotated for portrait playback, at the given ©xyz position,
+// with a cover image.
+func testVideo(xyz string) []byte {
+	created := uint32(time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC).Sub(quickTimeEpoch).Seconds())
+	mvhd := mp4Box("mvhd", u32(0, created, created, 1000, 12500), make([]byte, 80))
+	// 90° rotation: a=0, b=1, c=-1, d=0
+	matrix := u32(0, 0x10000, 0xFFFF0000, 0, 0, 0, 0, 0, 0x40000000)
+	tkhd := mp4Box("tkhd", u32(0, created, created, 1, 0, 12500, 0, 0, 0, 0), matrix, u32(1920<<16, 1080<<16))
+	soundTkhd := mp4Box("tkhd", u32(0, created, created, 2, 0, 12500, 0, 0, 0, 0x01000000), matrix, u32(0, 0))
+
+	text := append(binary.BigEndian.AppendUint16(nil, uint16(len(xyz))), 0x15, 0xC7)
+	udta := mp4Box("udta",
+		mp4Box("\xa9xyz", text, []byte(xyz)),
+		mp4Box("meta", u32(0),
+			mp4Box("hdlr", u32(0, 0), []byte("mdirappl"), make([]byte, 9)),
+			mp4Box("ilst", mp4Box("covr", mp4Box("data", u32(13, 0), []byte("cover-jpeg"))))))
+
+	return append(append(mp4Box("ftyp", []byte("isom"), u32(512), []byte("isomiso2mp41")),
+		mp4Box("mdat", make([]byte, 64))...),
+		mp4Box("moov", mvhd, mp4Box("trak", soundTkhd), mp4Box("trak", tkhd), udta)...)
+}
+
+func TestExtractVideoMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "VID_0001.mp4")
+	os.WriteFile(path, testVideo("+52.5200+013.4050+034.500/"), 0644)
+
+	if !IsVideo(path) {
+		t.Fatal("Expected the file to be recognized as a video")
+	}
+	m, err := ExtractMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Video || m.Duration == nil || *m.Duration != 12.5 {
+		t.Errorf("Unexpected video flag and duration %v, %v", m.Video, m.Duration)
+	}
+	if m.Width != 1920 || m.Height != 1080 || m.Orientation != 6 {
+		t.Errorf("Unexpected size %dx%d, orientation %d", m.Width, m.Height, m.Orientation)
+	}
+	if m.Latitude == nil || math.Abs(*m.Latitude-52.52) > 1e-9 || m.Longitude == nil || math.Abs(*m.Longitude-13.405) > 1e-9 || m.Altitude == nil || *m.Altitude != 34.5 {
+		t.Errorf("Unexpected position %v, %v, %v", m.Latitude, m.Longitude, m.Altitude)
+	}
+	// The instant is shown in the zone of the position
+	if got := m.DateTaken.Format("2006-01-02 15:04:05 -07:00"); got != "2022-06-01 12:00:00 +02:00" {
+		t.Errorf("Unexpected date %s", got)
+	}
+
+	cover, err := VideoCover(path)
+	if err != nil || string(cover) != "cover-jpeg" {
+		t.Errorf("Unexpected cover %q, %v", cover, err)
+	}
+}
+
+func TestIsVideoSkipsHEIC(t *testing.T) {
+	if IsVideo("../../test_data/source_icloud/IMG_8299.HEIC") {
+		t.Error("Expected HEIC not to be a video")
+	}
+	if IsVideo("../../test_data/source_digital_camera/RIMG0018.JPG") {
+		t.Error("Expected JPEG not to be a video")
+	}
+}
@@ -0,0 +1,492 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Changes are the fields WriteMetadata records in a file. Fields that are
+// not set are left as they are.
+type Changes struct {
+	DateTaken *time.Time // written as wall-clock time
+	UTCOffset *int       // written along with DateTaken when the capture zone is known
+	// SetLocation writes Latitude and Longitude; leaving both nil removes
+	// the position from the file.
+	SetLocation bool
+	Latitude    *float64
+	Longitude   *float64
+	// Rating, Title, Description and Keywords have no EXIF tags and are
+	// always written to the XMP sidecar. An empty title or description and
+	// an empty, non-nil keyword list remove the property.
+	Rating      *int
+	Title       *string
+	Description *string
+	Keywords    []string
+}
+
+// xmpOnly reports whether c sets fields only XMP can hold.
+func (c Changes) xmpOnly() bool {
+	return c.Rating != nil || c.Title != nil || c.Description != nil || c.Keywords != nil
+}
+
+// WriteMetadata records c in the file at path. JPEG files are patched in
+// place, keeping all other segments byte for byte; formats whose metadata
+// cannot be patched get an XMP sidecar next to them instead.
+func WriteMetadata(path string, c Changes) error {
+	if c.SetLocation && (c.Latitude == nil) != (c.Longitude == nil) {
+		return fmt.Errorf("latitude and longitude must be set together")
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		if c.DateTaken != nil || c.SetLocation {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			patched, err := patchJPEG(data, c)
+			if err != nil {
+				return fmt.Errorf("failed to patch EXIF of %s: %w", path, err)
+			}
+			if err := replaceFile(path, patched); err != nil {
+				return err
+			}
+		}
+		if c.xmpOnly() {
+			return writeXMPSidecar(path, Changes{Rating: c.Rating, Title: c.Title, Description: c.Description, Keywords: c.Keywords})
+		}
+		return nil
+	default:
+		return writeXMPSidecar(path, c)
+	}
+}
+
+// TIFF tags written by patchTIFF
+const (
+	tagDateTime            = 0x0132
+	tagExifIFD             = 0x8769
+	tagGPSIFD              = 0x8825
+	tagDateTimeOriginal    = 0x9003
+	tagDateTimeDigitized   = 0x9004
+	tagOffsetTime          = 0x9010
+	tagOffsetTimeOriginal  = 0x9011
+	tagOffsetTimeDigitized = 0x9012
+	tagGPSVersionID        = 0x0000
+	tagGPSLatitudeRef      = 0x0001
+	tagGPSLatitude         = 0x0002
+	tagGPSLongitudeRef     = 0x0003
+	tagGPSLongitude        = 0x0004
+)
+
+// TIFF field types
+const (
+	typeByte     = 1
+	typeASCII    = 2
+	typeLong     = 4
+	typeRational = 5
+)
+
+var typeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// maxTIFFSize is the largest TIFF block that fits into an APP1 segment after
+// the length field and the "Exif\0\0" header.
+const maxTIFFSize = 0xFFFF - 2 - 6
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// patchJPEG returns data with c written into its EXIF APP1 segment, which is
+// created if the file has none. Everything else is copied unchanged.
+func patchJPEG(data []byte, c Changes) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, fmt.Errorf("not a JPEG file")
+	}
+
+	// Walk the segments up to the image data to find the EXIF block. New
+	// EXIF goes after any leading APP0 (JFIF) segments.
+	exifStart, exifEnd := -1, -1
+	insertAt := 2
+	leadingAPP0 := true
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, fmt.Errorf("invalid JPEG marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+		if marker == 0xFF { // fill byte
+			pos++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 { // start of scan, end of image
+			break
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) { // no length
+			pos += 2
+			continue
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) {
+			return nil, fmt.Errorf("truncated JPEG segment at offset %d", pos)
+		}
+		if marker == 0xE1 && exifStart < 0 && bytes.HasPrefix(data[pos+4:end], exifHeader) {
+			exifStart, exifEnd = pos, end
+		}
+		if marker == 0xE0 && leadingAPP0 {
+			insertAt = end
+		} else {
+			leadingAPP0 = false
+		}
+		pos = end
+	}
+
+	var block []byte
+	if exifStart >= 0 {
+		block = data[exifStart+4+len(exifHeader) : exifEnd]
+	} else {
+		exifStart, exifEnd = insertAt, insertAt
+		// Big-endian header with an empty IFD0
+		block = []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 0, 0, 0, 0, 0}
+	}
+
+	patched, err := patchTIFF(block, c)
+	if err != nil {
+		return nil, err
+	}
+	if len(patched) > maxTIFFSize {
+		return nil, fmt.Errorf("EXIF data too large (%d bytes)", len(patched))
+	}
+
+	out := make([]byte, 0, len(data)+len(patched)-len(block)+10)
+	out = append(out, data[:exifStart]...)
+	out = append(out, 0xFF, 0xE1)
+	out = binary.BigEndian.AppendUint16(out, uint16(2+len(exifHeader)+len(patched)))
+	out = append(out, exifHeader...)
+	out = append(out, patched...)
+	out = append(out, data[exifEnd:]...)
+	return out, nil
+}
+
+// tiffBlock is a TIFF structure being patched. Existing bytes are never
+// moved, so offsets into them (including those inside maker notes) stay
+// valid: values of unchanged size are overwritten in place, and IFDs that
+// need new entries are copied to the end of the block.
+type tiffBlock struct {
+	order interface {
+		binary.ByteOrder
+		binary.AppendByteOrder
+	}
+	buf []byte
+}
+
+// ifdEntry is one 12-byte IFD record. For entries read from the block, pos
+// is the record's offset and value holds its raw value field. Entries to be
+// written carry their encoded value in data.
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	pos   int
+	value [4]byte
+	data  []byte
+}
+
+func (e ifdEntry) size() uint32 {
+	return typeSizes[e.typ] * e.count
+}
+
+func patchTIFF(block []byte, c Changes) ([]byte, error) {
+	if len(block) < 8 {
+		return nil, fmt.Errorf("truncated TIFF header")
+	}
+	t := &tiffBlock{buf: append([]byte(nil), block...)}
+	switch string(block[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid TIFF byte order %q", block[:2])
+	}
+	ifd0 := t.order.Uint32(t.buf[4:])
+
+	var ifd0Set, exifSet, gpsSet []ifdEntry
+	var gpsRemove []uint16
+
+	if c.DateTaken != nil {
+		stamp := t.ascii(c.DateTaken.Format("2006:01:02 15:04:05"))
+		ifd0Set = append(ifd0Set, t.entry(tagDateTime, typeASCII, stamp))
+		exifSet = append(exifSet,
+			t.entry(tagDateTimeOriginal, typeASCII, stamp),
+			t.entry(tagDateTimeDigitized, typeASCII, stamp),
+		)
+		if c.UTCOffset != nil {
+			offset := t.ascii(formatOffset(*c.UTCOffset))
+			exifSet = append(exifSet,
+				t.entry(tagOffsetTime, typeASCII, offset),
+				t.entry(tagOffsetTimeOriginal, typeASCII, offset),
+				t.entry(tagOffsetTimeDigitized, typeASCII, offset),
+			)
+		}
+	}
+	if c.SetLocation {
+		if c.Latitude != nil {
+			latRef, lonRef := "N", "E"
+			if *c.Latitude < 0 {
+				latRef = "S"
+			}
+			if *c.Longitude < 0 {
+				lonRef = "W"
+			}
+			gpsSet = append(gpsSet,
+				t.entry(tagGPSVersionID, typeByte, []byte{2, 3, 0, 0}),
+				t.entry(tagGPSLatitudeRef, typeASCII, t.ascii(latRef)),
+				t.entry(tagGPSLatitude, typeRational, t.degrees(*c.Latitude)),
+				t.entry(tagGPSLongitudeRef, typeASCII, t.ascii(lonRef)),
+				t.entry(tagGPSLongitude, typeRational, t.degrees(*c.Longitude)),
+			)
+		} else {
+			gpsRemove = []uint16{tagGPSLatitudeRef, tagGPSLatitude, tagGPSLongitudeRef, tagGPSLongitude}
+		}
+	}
+
+	entries, _, err := t.readIFD(ifd0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IFD0: %w", err)
+	}
+	if len(exifSet) > 0 {
+		offset, err := t.updateIFD(t.pointer(entries, tagExifIFD), exifSet, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update Exif IFD: %w", err)
+		}
+		ifd0Set = append(ifd0Set, t.entry(tagExifIFD, typeLong, t.long(offset)))
+	}
+	if len(gpsSet) > 0 || (len(gpsRemove) > 0 && t.pointer(entries, tagGPSIFD) != 0) {
+		offset, err := t.updateIFD(t.pointer(entries, tagGPSIFD), gpsSet, gpsRemove)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update GPS IFD: %w", err)
+		}
+		ifd0Set = append(ifd0Set, t.entry(tagGPSIFD, typeLong, t.long(offset)))
+	}
+
+	offset, err := t.updateIFD(ifd0, ifd0Set, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update IFD0: %w", err)
+	}
+	t.order.PutUint32(t.buf[4:], offset)
+	return t.buf, nil
+}
+
+// readIFD returns the entries of the IFD at offset and the offset of the
+// next IFD.
+func (t *tiffBlock) readIFD(offset uint32) ([]ifdEntry, uint32, error) {
+	if uint64(offset)+2 > uint64(len(t.buf)) {
+		return nil, 0, fmt.Errorf("IFD offset %d out of range", offset)
+	}
+	n := int(t.order.Uint16(t.buf[offset:]))
+	start := int(offset) + 2
+	if start+12*n+4 > len(t.buf) {
+		return nil, 0, fmt.Errorf("truncated IFD at offset %d", offset)
+	}
+	entries := make([]ifdEntry, n)
+	for i := range entries {
+		pos := start + 12*i
+		e := ifdEntry{
+			tag:   t.order.Uint16(t.buf[pos:]),
+			typ:   t.order.Uint16(t.buf[pos+2:]),
+			count: t.order.Uint32(t.buf[pos+4:]),
+			pos:   pos,
+		}
+		copy(e.value[:], t.buf[pos+8:pos+12])
+		entries[i] = e
+	}
+	return entries, t.order.Uint32(t.buf[start+12*n:]), nil
+}
+
+// updateIFD writes set into the IFD at offset and drops the remove tags.
+// When every entry in set replaces one of the same type and size, the values
+// are overwritten in place and offset is returned. Otherwise the IFD is
+// copied to the end of the block and the new offset returned. An offset of
+// 0 creates a new IFD.
+func (t *tiffBlock) updateIFD(offset uint32, set []ifdEntry, remove []uint16) (uint32, error) {
+	var entries []ifdEntry
+	var next uint32
+	if offset != 0 {
+		var err error
+		if entries, next, err = t.readIFD(offset); err != nil {
+			return 0, err
+		}
+	}
+
+	index := make(map[uint16]int, len(entries))
+	for i, e := range entries {
+		index[e.tag] = i
+	}
+	inPlace := offset != 0
+	for _, tag := range remove {
+		if _, ok := index[tag]; ok {
+			inPlace = false
+		}
+	}
+	for _, s := range set {
+		i, ok := index[s.tag]
+		if !ok || entries[i].typ != s.typ || entries[i].count != s.count {
+			inPlace = false
+		}
+	}
+
+	if inPlace {
+		for _, s := range set {
+			e := entries[index[s.tag]]
+			dst := e.pos + 8
+			if s.size() > 4 {
+				dst = int(t.order.Uint32(e.value[:]))
+				if uint64(dst)+uint64(s.size()) > uint64(len(t.buf)) {
+					return 0, fmt.Errorf("value of tag 0x%04x out of range", s.tag)
+				}
+			}
+			copy(t.buf[dst:], s.data)
+		}
+		return offset, nil
+	}
+
+	removed := make(map[uint16]bool, len(remove)+len(set))
+	for _, tag := range remove {
+		removed[tag] = true
+	}
+	for _, s := range set {
+		removed[s.tag] = true
+	}
+	var merged []ifdEntry
+	for _, e := range entries {
+		if !removed[e.tag] {
+			merged = append(merged, e)
+		}
+	}
+	merged = append(merged, set...)
+	sort.Slice(merged, func(i, j int) bool { return merged[i].tag < merged[j].tag })
+	return t.appendIFD(merged, next), nil
+}
+
+// appendIFD writes entries as a new IFD at the end of the block, followed by
+// the values that do not fit into the entries.
+func (t *tiffBlock) appendIFD(entries []ifdEntry, next uint32) uint32 {
+	t.align()
+	offset := len(t.buf)
+	t.buf = t.order.AppendUint16(t.buf, uint16(len(entries)))
+	t.buf = append(t.buf, make([]byte, 12*len(entries)+4)...)
+	t.order.PutUint32(t.buf[offset+2+12*len(entries):], next)
+
+	for i, e := range entries {
+		pos := offset + 2 + 12*i
+		t.order.PutUint16(t.buf[pos:], e.tag)
+		t.order.PutUint16(t.buf[pos+2:], e.typ)
+		t.order.PutUint32(t.buf[pos+4:], e.count)
+		switch {
+		case e.data == nil:
+			copy(t.buf[pos+8:], e.value[:])
+		case len(e.data) <= 4:
+			copy(t.buf[pos+8:], e.data)
+		default:
+			t.align()
+			t.order.PutUint32(t.buf[pos+8:], uint32(len(t.buf)))
+			t.buf = append(t.buf, e.data...)
+		}
+	}
+	return uint32(offset)
+}
+
+// align pads the block to a word boundary, as TIFF offsets must be even.
+func (t *tiffBlock) align() {
+	if len(t.buf)%2 != 0 {
+		t.buf = append(t.buf, 0)
+	}
+}
+
+// pointer returns the LONG value of tag in entries, or 0 if it is missing.
+func (t *tiffBlock) pointer(entries []ifdEntry, tag uint16) uint32 {
+	for _, e := range entries {
+		if e.tag == tag && e.typ == typeLong && e.count == 1 {
+			return t.order.Uint32(e.value[:])
+		}
+	}
+	return 0
+}
+
+func (t *tiffBlock) entry(tag, typ uint16, data []byte) ifdEntry {
+	return ifdEntry{tag: tag, typ: typ, count: uint32(len(data)) / typeSizes[typ], data: data}
+}
+
+func (t *tiffBlock) ascii(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func (t *tiffBlock) long(v uint32) []byte {
+	return t.order.AppendUint32(nil, v)
+}
+
+// degrees encodes the absolute value of a coordinate as degrees, minutes and
+// seconds rationals.
+func (t *tiffBlock) degrees(v float64) []byte {
+	v = math.Abs(v)
+	deg := math.Floor(v)
+	minutes := math.Floor((v - deg) * 60)
+	seconds := ((v-deg)*60 - minutes) * 60
+
+	var out []byte
+	for _, r := range [][2]uint32{
+		{uint32(deg), 1},
+		{uint32(minutes), 1},
+		{uint32(math.Round(seconds * 10000)), 10000},
+	} {
+		out = t.order.AppendUint32(out, r[0])
+		out = t.order.AppendUint32(out, r[1])
+	}
+	return out
+}
+
+// formatOffset formats seconds east of UTC as an EXIF offset like "+09:00".
+func formatOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign = '-'
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%c%02d:%02d", sign, seconds/3600, seconds%3600/60)
+}
+
+// replaceFile atomically replaces the file at path with data, keeping its
+// permissions.
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".exif-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
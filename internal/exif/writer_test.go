@@ -0,0 +1,192 @@
+package exif
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// copyTestFile copies a file from test_data into a temporary directory.
+func copyTestFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "test_data", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), filepath.Base(name))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// jpegSegments returns the marker segments of a JPEG other than the EXIF
+// block, followed by the image data.
+func jpegSegments(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	var segments [][]byte
+	pos := 2
+	for data[pos+1] != 0xDA {
+		end := pos + 2 + int(data[pos+2])<<8 + int(data[pos+3])
+		if !(data[pos+1] == 0xE1 && bytes.HasPrefix(data[pos+4:], exifHeader)) {
+			segments = append(segments, data[pos:end])
+		}
+		pos = end
+	}
+	return append(segments, data[pos:])
+}
+
+func TestWriteMetadataRoundTrip(t *testing.T) {
+	for _, name := range []string{
+		"source_digital_camera/RIMG0018.JPG",
+		"source_google_photos/IMG_20211022_084955842.jpg",
+	} {
+		path := copyTestFile(t, name)
+		original, _ := os.ReadFile(path)
+		before, err := ExtractMetadata(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		date := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+		offset := -(3*3600 + 1800)
+		lat, lon := -22.951916, -43.210487
+		err = WriteMetadata(path, Changes{
+			DateTaken:   &date,
+			UTCOffset:   &offset,
+			SetLocation: true,
+			Latitude:    &lat,
+			Longitude:   &lon,
+		})
+		if err != nil {
+			t.Fatalf("%s: WriteMetadata failed: %v", name, err)
+		}
+
+		after, err := ExtractMetadata(path)
+		if err != nil {
+			t.Fatalf("%s: ExtractMetadata failed: %v", name, err)
+		}
+		if after.DateTaken.Format("2006-01-02 15:04:05 -07:00") != "2022-03-04 05:06:07 -03:30" {
+			t.Errorf("%s: expected new date with offset, got %v", name, after.DateTaken)
+		}
+		if after.Latitude == nil || math.Abs(*after.Latitude-lat) > 1e-6 || math.Abs(*after.Longitude-lon) > 1e-6 {
+			t.Errorf("%s: expected location %v,%v, got %v,%v", name, lat, lon, after.Latitude, after.Longitude)
+		}
+		// Everything else is untouched
+		if after.CameraModel != before.CameraModel || *after.FNumber != *before.FNumber || after.Width != before.Width {
+			t.Errorf("%s: other EXIF fields changed: %+v -> %+v", name, before, after)
+		}
+		patched, _ := os.ReadFile(path)
+		origSegments, newSegments := jpegSegments(t, original), jpegSegments(t, patched)
+		if len(origSegments) != len(newSegments) {
+			t.Fatalf("%s: expected %d segments, got %d", name, len(origSegments), len(newSegments))
+		}
+		for i := range origSegments {
+			if !bytes.Equal(origSegments[i], newSegments[i]) {
+				t.Errorf("%s: segment %d changed", name, i)
+			}
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(patched)); err != nil {
+			t.Errorf("%s: patched file no longer decodes: %v", name, err)
+		}
+
+		// A second edit overwrites the values in place
+		date = date.Add(time.Hour)
+		if err := WriteMetadata(path, Changes{DateTaken: &date, UTCOffset: &offset}); err != nil {
+			t.Fatalf("%s: second WriteMetadata failed: %v", name, err)
+		}
+		again, _ := os.ReadFile(path)
+		if len(again) != len(patched) {
+			t.Errorf("%s: expected in-place update, size changed from %d to %d", name, len(patched), len(again))
+		}
+		if m, _ := ExtractMetadata(path); m.DateTaken.Hour() != 6 {
+			t.Errorf("%s: expected updated hour, got %v", name, m.DateTaken)
+		}
+
+		// Removing the location
+		if err := WriteMetadata(path, Changes{SetLocation: true}); err != nil {
+			t.Fatalf("%s: removing location failed: %v", name, err)
+		}
+		if m, _ := ExtractMetadata(path); m.Latitude != nil {
+			t.Errorf("%s: expected location to be removed, got %v", name, *m.Latitude)
+		}
+	}
+}
+
+func TestWriteMetadataAddsExif(t *testing.T) {
+	var buf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	img.Set(1, 1, color.White)
+	jpeg.Encode(&buf, img, nil)
+	path := filepath.Join(t.TempDir(), "plain.jpg")
+	os.WriteFile(path, buf.Bytes(), 0644)
+
+	date := time.Date(2020, 2, 29, 23, 59, 0, 0, time.UTC)
+	lat, lon := 52.52, 13.405
+	if err := WriteMetadata(path, Changes{DateTaken: &date, SetLocation: true, Latitude: &lat, Longitude: &lon}); err != nil {
+		t.Fatalf("WriteMetadata failed: %v", err)
+	}
+	m, err := ExtractMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No offset was given, so the zone is derived from the location
+	if m.DateTaken.Format("2006-01-02 15:04") != "2020-02-29 23:59" || m.DateTaken.Location().String() != "Europe/Berlin" {
+		t.Errorf("Unexpected date %v", m.DateTaken)
+	}
+	if m.Latitude == nil || math.Abs(*m.Latitude-lat) > 1e-6 {
+		t.Errorf("Unexpected location %v", m.Latitude)
+	}
+	data, _ := os.ReadFile(path)
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("File no longer decodes: %v", err)
+	}
+}
+
+func TestWriteMetadataSidecar(t *testing.T) {
+	path := copyTestFile(t, "source_icloud/IMG_8299.HEIC")
+	original, _ := os.ReadFile(path)
+
+	date := time.Date(2021, 7, 1, 18, 30, 0, 0, time.UTC)
+	offset := 2 * 3600
+	lat, lon := 48.8584, 2.2945
+	if err := WriteMetadata(path, Changes{DateTaken: &date, UTCOffset: &offset, SetLocation: true, Latitude: &lat, Longitude: &lon}); err != nil {
+		t.Fatalf("WriteMetadata failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, original) {
+		t.Error("HEIC file must not be modified")
+	}
+
+	xmp, err := os.ReadFile(SidecarPath(path))
+	if err != nil {
+		t.Fatalf("Expected a sidecar: %v", err)
+	}
+	for _, want := range []string{
+		`exif:DateTimeOriginal="2021-07-01T20:30:00+02:00"`,
+		`exif:GPSLatitude="48,51.5040000N"`,
+		`exif:GPSLongitude="2,17.6700000E"`,
+		`xmlns:exif="http://ns.adobe.com/exif/1.0/"`,
+	} {
+		if !strings.Contains(string(xmp), want) {
+			t.Errorf("Sidecar is missing %s:\n%s", want, xmp)
+		}
+	}
+
+	// Updating keeps foreign properties and replaces ours
+	os.WriteFile(SidecarPath(path), []byte(strings.Replace(string(xmp), `rdf:about=""`, `rdf:about="" xmp:Rating="4"`, 1)), 0644)
+	if err := WriteMetadata(path, Changes{SetLocation: true}); err != nil {
+		t.Fatalf("WriteMetadata failed: %v", err)
+	}
+	xmp, _ = os.ReadFile(SidecarPath(path))
+	if strings.Contains(string(xmp), "GPSLatitude") || !strings.Contains(string(xmp), `xmp:Rating="4"`) ||
+		strings.Count(string(xmp), "DateTimeOriginal") != 1 {
+		t.Errorf("Unexpected sidecar after update:\n%s", xmp)
+	}
+}
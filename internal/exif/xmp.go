@@ -0,0 +1,452 @@
+package exif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XMP namespaces of the properties photoo reads and writes
+const (
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsXMP       = "http://ns.adobe.com/xap/1.0/"
+	nsExif      = "http://ns.adobe.com/exif/1.0/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsGCamera   = "http://ns.google.com/photos/1.0/camera/"
+)
+
+var xmpNamespaces = map[string]string{
+	"xmp":       nsXMP,
+	"exif":      nsExif,
+	"photoshop": nsPhotoshop,
+	"dc":        nsDC,
+}
+
+const xmpTemplate = `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""/>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>
+`
+
+// SidecarPath returns the XMP sidecar written for a photo, e.g.
+// IMG_0001.HEIC.xmp. Keeping the extension avoids clashes between files
+// that differ only in format.
+func SidecarPath(path string) string {
+	return path + ".xmp"
+}
+
+// findSidecar returns the XMP sidecar of a photo, or "" if it has none.
+// Besides photoo's own naming (IMG_0001.HEIC.xmp, also used by darktable and
+// digiKam) it finds Lightroom's IMG_0001.xmp.
+func findSidecar(path string) string {
+	for _, candidate := range XMPSidecarNames(path) {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// XMPSidecarNames returns the names an XMP sidecar of the photo at path may
+// have, most likely first.
+func XMPSidecarNames(path string) []string {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	return []string{path + ".xmp", path + ".XMP", base + ".xmp", base + ".XMP"}
+}
+
+// xmpData holds the properties photoo reads from an XMP packet.
+type xmpData struct {
+	date        time.Time
+	dateZone    *time.Location // nil if the date has no offset
+	latitude    *float64
+	longitude   *float64
+	altitude    *float64
+	rating      *int
+	title       string
+	description string
+	keywords    []string
+	// microVideoOffset is the distance of an embedded motion photo video
+	// from the end of the file, 0 if there is none.
+	microVideoOffset int64
+}
+
+// parseXMP reads an XMP packet. Properties may be given as attributes of
+// rdf:Description or as elements; prefixes are resolved to namespaces, so
+// packets using unusual prefixes are read too.
+func parseXMP(data []byte) (*xmpData, error) {
+	props := map[string]string{}
+	lists := map[string][]string{}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	var stack []xml.Name
+	var text strings.Builder
+	var liDefault bool
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid XMP: %w", err)
+		}
+
+		switch el := tok.(type) {
+		case xml.StartElement:
+			if el.Name.Space == nsRDF && el.Name.Local == "Description" {
+				for _, attr := range el.Attr {
+					if attr.Name.Space != "xmlns" && attr.Name.Space != nsRDF && attr.Name.Space != "" {
+						props[attr.Name.Space+attr.Name.Local] = attr.Value
+					}
+				}
+			}
+			if el.Name.Space == nsRDF && el.Name.Local == "li" {
+				liDefault = false
+				for _, attr := range el.Attr {
+					if attr.Name.Local == "lang" && attr.Value == "x-default" {
+						liDefault = true
+					}
+				}
+			}
+			stack = append(stack, el.Name)
+			text.Reset()
+		case xml.CharData:
+			text.Write(el)
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+			text.Reset()
+			if len(stack) == 0 {
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			if el.Name.Space == nsRDF && el.Name.Local == "li" {
+				// The property is the nearest ancestor outside the rdf containers
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i].Space != nsRDF {
+						key := stack[i].Space + stack[i].Local
+						if liDefault {
+							lists[key] = append([]string{value}, lists[key]...)
+						} else {
+							lists[key] = append(lists[key], value)
+						}
+						break
+					}
+				}
+			} else if len(stack) > 0 && stack[len(stack)-1].Space == nsRDF && stack[len(stack)-1].Local == "Description" && value != "" {
+				props[el.Name.Space+el.Name.Local] = value
+			}
+		}
+	}
+
+	x := &xmpData{}
+	for _, key := range []string{nsExif + "DateTimeOriginal", nsPhotoshop + "DateCreated", nsXMP + "CreateDate"} {
+		if v, ok := props[key]; ok {
+			if t, zone, err := parseXMPDate(v); err == nil {
+				x.date, x.dateZone = t, zone
+				break
+			}
+		}
+	}
+	lat, errLat := parseXMPCoordinate(props[nsExif+"GPSLatitude"])
+	lon, errLon := parseXMPCoordinate(props[nsExif+"GPSLongitude"])
+	if errLat == nil && errLon == nil {
+		x.latitude, x.longitude = &lat, &lon
+		if alt, err := parseRational(props[nsExif+"GPSAltitude"]); err == nil {
+			if props[nsExif+"GPSAltitudeRef"] == "1" {
+				alt = -alt
+			}
+			x.altitude = &alt
+		}
+	}
+	if v, ok := props[nsXMP+"Rating"]; ok {
+		if r, err := strconv.ParseFloat(v, 64); err == nil {
+			rating := int(r)
+			x.rating = &rating
+		}
+	}
+	if titles := lists[nsDC+"title"]; len(titles) > 0 {
+		x.title = titles[0]
+	}
+	if descriptions := lists[nsDC+"description"]; len(descriptions) > 0 {
+		x.description = descriptions[0]
+	}
+	for _, k := range lists[nsDC+"subject"] {
+		if k != "" {
+			x.keywords = append(x.keywords, k)
+		}
+	}
+	if v, err := strconv.ParseInt(props[nsGCamera+"MicroVideoOffset"], 10, 64); err == nil && v > 0 {
+		x.microVideoOffset = v
+	}
+	return x, nil
+}
+
+// captureDate returns the XMP date. Dates with an offset are instants in
+// their zone; dates without one are wall-clock times.
+func (x *xmpData) captureDate() captureDate {
+	return captureDate{t: x.date, zone: x.dateZone, instant: x.dateZone != nil}
+}
+
+// parseXMPDate parses an XMP date, which may omit the seconds, the time or
+// the offset. The zone is nil when there is no offset.
+func parseXMPDate(s string) (time.Time, *time.Location, error) {
+	for _, layout := range []string{"2006-01-02T15:04:05Z07:00", "2006-01-02T15:04Z07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			_, offset := t.Zone()
+			return t, time.FixedZone("", offset), nil
+		}
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil, nil
+		}
+	}
+	return time.Time{}, nil, fmt.Errorf("invalid XMP date %q", s)
+}
+
+// parseXMPCoordinate parses the XMP GPS form "DDD,MM,SSk" or "DDD,MM.mmk",
+// where k is N, S, E or W.
+func parseXMPCoordinate(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 {
+		return 0, fmt.Errorf("invalid XMP coordinate %q", s)
+	}
+	ref := strings.ToUpper(s[len(s)-1:])
+	if !strings.Contains("NSEW", ref) {
+		return 0, fmt.Errorf("invalid XMP coordinate %q", s)
+	}
+	parts := strings.Split(s[:len(s)-1], ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid XMP coordinate %q", s)
+	}
+	v := 0.0
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid XMP coordinate %q", s)
+		}
+		v += f / math.Pow(60, float64(i))
+	}
+	if ref == "S" || ref == "W" {
+		v = -v
+	}
+	return v, nil
+}
+
+func parseRational(s string) (float64, error) {
+	num, denom, found := strings.Cut(s, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || !found {
+		return n, err
+	}
+	d, err := strconv.ParseFloat(denom, 64)
+	if err != nil || d == 0 {
+		return 0, fmt.Errorf("invalid rational %q", s)
+	}
+	return n / d, nil
+}
+
+var xmpAPP1Header = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+// readEmbeddedXMP returns the XMP packet of a JPEG file, or nil if it has
+// none. Only the segments before the image data are read.
+func readEmbeddedXMP(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil, nil
+	}
+	for {
+		var marker [2]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return nil, nil
+		}
+		if marker[0] != 0xFF || marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil, nil
+		}
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil || length < 2 {
+			return nil, nil
+		}
+		payload := make([]byte, length-2)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, nil
+		}
+		if marker[1] == 0xE1 && bytes.HasPrefix(payload, xmpAPP1Header) {
+			return payload[len(xmpAPP1Header):], nil
+		}
+	}
+}
+
+// WriteSidecar records c in the photo's XMP sidecar, whatever its format.
+func WriteSidecar(path string, c Changes) error {
+	return writeXMPSidecar(path, c)
+}
+
+// writeXMPSidecar records c in the photo's XMP sidecar. An existing sidecar
+// is updated, keeping all properties photoo does not manage.
+func writeXMPSidecar(path string, c Changes) error {
+	sidecar := SidecarPath(path)
+	doc := xmpTemplate
+	if data, err := os.ReadFile(sidecar); err == nil {
+		doc = string(data)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	props := map[string]*xmpValue{}
+	if c.DateTaken != nil {
+		date := c.DateTaken.Format("2006-01-02T15:04:05")
+		if c.UTCOffset != nil {
+			date = c.DateTaken.In(time.FixedZone("", *c.UTCOffset)).Format("2006-01-02T15:04:05-07:00")
+		}
+		props["exif:DateTimeOriginal"] = &xmpValue{text: date}
+		props["photoshop:DateCreated"] = &xmpValue{text: date}
+	}
+	if c.SetLocation {
+		props["exif:GPSLatitude"], props["exif:GPSLongitude"] = nil, nil
+		if c.Latitude != nil {
+			props["exif:GPSLatitude"] = &xmpValue{text: xmpCoordinate(*c.Latitude, "N", "S")}
+			props["exif:GPSLongitude"] = &xmpValue{text: xmpCoordinate(*c.Longitude, "E", "W")}
+		}
+	}
+	if c.Rating != nil {
+		props["xmp:Rating"] = &xmpValue{text: strconv.Itoa(*c.Rating)}
+	}
+	if c.Title != nil {
+		props["dc:title"] = nil
+		if *c.Title != "" {
+			props["dc:title"] = &xmpValue{container: "Alt", items: []string{*c.Title}}
+		}
+	}
+	if c.Description != nil {
+		props["dc:description"] = nil
+		if *c.Description != "" {
+			props["dc:description"] = &xmpValue{container: "Alt", items: []string{*c.Description}}
+		}
+	}
+	if c.Keywords != nil {
+		props["dc:subject"] = nil
+		if len(c.Keywords) > 0 {
+			props["dc:subject"] = &xmpValue{container: "Bag", items: c.Keywords}
+		}
+	}
+
+	doc, err := setXMPProperties(doc, props)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", sidecar, err)
+	}
+	if _, err := os.Stat(sidecar); os.IsNotExist(err) {
+		return os.WriteFile(sidecar, []byte(doc), 0644)
+	}
+	return replaceFile(sidecar, []byte(doc))
+}
+
+// xmpValue is a property value: simple text, or a list of items in an
+// rdf:Alt (language alternatives, written as x-default) or rdf:Bag.
+type xmpValue struct {
+	text      string
+	container string
+	items     []string
+}
+
+var descriptionTag = regexp.MustCompile(`<rdf:Description\b[^>]*?(/?)>`)
+
+// setXMPProperties sets properties on the first rdf:Description of doc.
+// Simple values are written as attributes, lists as elements. A nil value
+// removes the property. Existing values are removed in both attribute and
+// element form.
+func setXMPProperties(doc string, props map[string]*xmpValue) (string, error) {
+	for name := range props {
+		quoted := regexp.QuoteMeta(name)
+		doc = regexp.MustCompile(`\s+`+quoted+`\s*=\s*("[^"]*"|'[^']*')`).ReplaceAllString(doc, "")
+		doc = regexp.MustCompile(`(?s)\s*<`+quoted+`\b[^>]*?(/>|>.*?</`+quoted+`>)`).ReplaceAllString(doc, "")
+	}
+
+	loc := descriptionTag.FindStringSubmatchIndex(doc)
+	if loc == nil {
+		return "", fmt.Errorf("no rdf:Description element")
+	}
+	selfClosing := loc[3] > loc[2]
+
+	var attrs, elements strings.Builder
+	declared := map[string]bool{}
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prefix, _, _ := strings.Cut(name, ":")
+		if !declared[prefix] && !strings.Contains(doc, "xmlns:"+prefix+"=") {
+			fmt.Fprintf(&attrs, "\n    xmlns:%s=\"%s\"", prefix, xmpNamespaces[prefix])
+		}
+		declared[prefix] = true
+
+		v := props[name]
+		switch {
+		case v == nil:
+		case v.container == "":
+			fmt.Fprintf(&attrs, "\n    %s=\"%s\"", name, xmlEscape(v.text))
+		default:
+			fmt.Fprintf(&elements, "\n   <%s>\n    <rdf:%s>", name, v.container)
+			for _, item := range v.items {
+				if v.container == "Alt" {
+					fmt.Fprintf(&elements, "\n     <rdf:li xml:lang=\"x-default\">%s</rdf:li>", xmlEscape(item))
+				} else {
+					fmt.Fprintf(&elements, "\n     <rdf:li>%s</rdf:li>", xmlEscape(item))
+				}
+			}
+			fmt.Fprintf(&elements, "\n    </rdf:%s>\n   </%s>", v.container, name)
+		}
+	}
+
+	// Attributes go before the closing "/>" or ">" of the start tag, elements
+	// right after it
+	startTag := doc[loc[0]:loc[2]] + attrs.String()
+	if elements.Len() == 0 {
+		return doc[:loc[0]] + startTag + doc[loc[2]:], nil
+	}
+	if selfClosing {
+		return doc[:loc[0]] + startTag + ">" + elements.String() + "\n  </rdf:Description>" + doc[loc[1]:], nil
+	}
+	return doc[:loc[0]] + startTag + ">" + elements.String() + doc[loc[1]:], nil
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// xmpCoordinate formats a coordinate the way XMP stores GPS positions,
+// e.g. "52,31.2000000N".
+func xmpCoordinate(v float64, pos, neg string) string {
+	ref := pos
+	if v < 0 {
+		ref = neg
+	}
+	v = math.Abs(v)
+	deg := math.Floor(v)
+	return fmt.Sprintf("%d,%.7f%s", int(deg), (v-deg)*60, ref)
+}
@@ -0,0 +1,193 @@
+package exif
+
+import (
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lightroomSidecar mixes attribute and element properties the way Lightroom
+// writes them, with non-default prefixes for two namespaces.
+const lightroomSidecar = `<x:xmpmeta xmlns:x="adobe:ns:meta/" x:xmptk="Adobe XMP Core 7.0">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xap="http://ns.adobe.com/xap/1.0/"
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/"
+    xmlns:d="http://purl.org/dc/elements/1.1/"
+    xap:Rating="4"
+    exif:GPSLatitude="52,31.2N"
+    exif:GPSLongitude="13,24,36W"
+    exif:GPSAltitude="345/10"
+    exif:GPSAltitudeRef="1">
+   <exif:DateTimeOriginal>2019-05-04T13:14:15.50+02:00</exif:DateTimeOriginal>
+   <photoshop:DateCreated>2000-01-01</photoshop:DateCreated>
+   <d:title>
+    <rdf:Alt>
+     <rdf:li xml:lang="de-DE">Am Strand</rdf:li>
+     <rdf:li xml:lang="x-default">At the beach</rdf:li>
+    </rdf:Alt>
+   </d:title>
+   <d:description>
+    <rdf:Alt>
+     <rdf:li xml:lang="x-default">Sunset &amp; surf</rdf:li>
+    </rdf:Alt>
+   </d:description>
+   <d:subject>
+    <rdf:Bag>
+     <rdf:li>beach</rdf:li>
+     <rdf:li>holiday</rdf:li>
+    </rdf:Bag>
+   </d:subject>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+func TestParseXMP(t *testing.T) {
+	x, err := parseXMP([]byte(lightroomSidecar))
+	if err != nil {
+		t.Fatalf("parseXMP failed: %v", err)
+	}
+	if got := x.date.Format(time.RFC3339Nano); got != "2019-05-04T13:14:15.5+02:00" || x.dateZone == nil {
+		t.Errorf("Unexpected date %s (zone %v)", got, x.dateZone)
+	}
+	if x.latitude == nil || math.Abs(*x.latitude-52.52) > 1e-9 || x.longitude == nil || math.Abs(*x.longitude+13.41) > 1e-9 {
+		t.Errorf("Unexpected position %v, %v", x.latitude, x.longitude)
+	}
+	if x.altitude == nil || *x.altitude != -34.5 {
+		t.Errorf("Unexpected altitude %v", x.altitude)
+	}
+	if x.rating == nil || *x.rating != 4 {
+		t.Errorf("Unexpected rating %v", x.rating)
+	}
+	if x.title != "At the beach" || x.description != "Sunset & surf" {
+		t.Errorf("Unexpected title %q and description %q", x.title, x.description)
+	}
+	if !reflect.DeepEqual(x.keywords, []string{"beach", "holiday"}) {
+		t.Errorf("Unexpected keywords %v", x.keywords)
+	}
+
+	if _, err := parseXMP([]byte("<x:xmpmeta><rdf:RDF><rdf:Description")); err == nil {
+		t.Error("Expected invalid XMP to fail")
+	}
+}
+
+func TestParseXMPDate(t *testing.T) {
+	for value, want := range map[string]string{
+		"2021-03-04T05:06:07Z":         "2021-03-04 05:06:07 +0000",
+		"2021-03-04T05:06-03:30":       "2021-03-04 05:06:00 -0330",
+		"2021-03-04T05:06:07":          "2021-03-04 05:06:07 wall",
+		"2021-03-04":                   "2021-03-04 00:00:00 wall",
+		"2021":                         "2021-01-01 00:00:00 wall",
+		"2021-03-04T05:06:07.25+01:00": "2021-03-04 05:06:07 +0100",
+	} {
+		d, zone, err := parseXMPDate(value)
+		if err != nil {
+			t.Errorf("parseXMPDate(%q) failed: %v", value, err)
+			continue
+		}
+		got := d.Format("2006-01-02 15:04:05 -0700")
+		if zone == nil {
+			got = d.Format("2006-01-02 15:04:05") + " wall"
+		}
+		if got != want {
+			t.Errorf("parseXMPDate(%q) = %s, want %s", value, got, want)
+		}
+	}
+	if _, _, err := parseXMPDate("yesterday"); err == nil {
+		t.Error("Expected an invalid date to fail")
+	}
+}
+
+func TestExtractMetadataXMPSidecar(t *testing.T) {
+	path := copyTestFile(t, "source_digital_camera/RIMG0018.JPG")
+	// Lightroom names the sidecar after the file without its extension
+	sidecar := strings.TrimSuffix(path, ".JPG") + ".xmp"
+	if err := os.WriteFile(sidecar, []byte(lightroomSidecar), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ExtractMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The sidecar wins over EXIF for the date and position
+	if got := m.DateTaken.Format("2006-01-02 15:04:05 -07:00"); got != "2019-05-04 13:14:15 +02:00" || m.UTCOffset == nil || *m.UTCOffset != 7200 {
+		t.Errorf("Expected the sidecar date, got %s (offset %v)", got, m.UTCOffset)
+	}
+	if m.Latitude == nil || math.Abs(*m.Latitude-52.52) > 1e-9 {
+		t.Errorf("Expected the sidecar position, got %v", m.Latitude)
+	}
+	if m.Rating == nil || *m.Rating != 4 || m.Title != "At the beach" || len(m.Keywords) != 2 {
+		t.Errorf("Expected sidecar rating, title and keywords, got %v %q %v", m.Rating, m.Title, m.Keywords)
+	}
+	// Camera details still come from EXIF
+	if m.CameraModel != "Caplio R5" {
+		t.Errorf("Expected the EXIF camera model, got %q", m.CameraModel)
+	}
+}
+
+func TestExtractMetadataEmbeddedXMP(t *testing.T) {
+	path := copyTestFile(t, "source_digital_camera/RIMG0018.JPG")
+	data, _ := os.ReadFile(path)
+
+	packet := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:exif="http://ns.adobe.com/exif/1.0/"
+ xmlns:dc="http://purl.org/dc/elements/1.1/" xmp:Rating="2" exif:DateTimeOriginal="1999-01-01T00:00:00">
+<dc:subject><rdf:Bag><rdf:li>family</rdf:li></rdf:Bag></dc:subject>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+	payload := append(append([]byte{}, xmpAPP1Header...), packet...)
+	segment := append([]byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+	// Cameras write the XMP segment after the EXIF one
+	exifEnd := 4 + int(data[4])<<8 + int(data[5])
+	withXMP := append(append(append([]byte{}, data[:exifEnd]...), segment...), data[exifEnd:]...)
+	os.WriteFile(path, withXMP, 0644)
+
+	m, err := ExtractMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Rating == nil || *m.Rating != 2 || !reflect.DeepEqual(m.Keywords, []string{"family"}) {
+		t.Errorf("Expected embedded rating and keywords, got %v %v", m.Rating, m.Keywords)
+	}
+	// EXIF takes precedence over embedded XMP for the date
+	if got := m.DateTaken.Format("2006-01-02 15:04:05"); got != "2011-08-10 10:30:36" {
+		t.Errorf("Expected the EXIF date, got %s", got)
+	}
+}
+
+func TestWriteSidecarDescriptiveFields(t *testing.T) {
+	path := copyTestFile(t, "source_icloud/IMG_8299.HEIC")
+
+	rating, title, description := 5, `Rock & "Roll"`, ""
+	c := Changes{Rating: &rating, Title: &title, Description: &description, Keywords: []string{"music", "<live>"}}
+	if err := WriteSidecar(path, c); err != nil {
+		t.Fatalf("WriteSidecar failed: %v", err)
+	}
+	data, _ := os.ReadFile(SidecarPath(path))
+	x, err := parseXMP(data)
+	if err != nil {
+		t.Fatalf("Written sidecar does not parse: %v\n%s", err, data)
+	}
+	if x.rating == nil || *x.rating != 5 || x.title != title || !reflect.DeepEqual(x.keywords, c.Keywords) {
+		t.Errorf("Unexpected values read back: %+v\n%s", x, data)
+	}
+
+	// Rewriting replaces the lists instead of adding to them
+	c.Keywords = []string{"music"}
+	if err := WriteSidecar(path, c); err != nil {
+		t.Fatalf("WriteSidecar failed: %v", err)
+	}
+	data, _ = os.ReadFile(SidecarPath(path))
+	if x, _ := parseXMP(data); x == nil || !reflect.DeepEqual(x.keywords, []string{"music"}) || strings.Count(string(data), "<dc:title>") != 1 {
+		t.Errorf("Unexpected sidecar after rewrite:\n%s", data)
+	}
+
+	m, err := ExtractMetadata(path)
+	if err != nil || m.Title != title {
+		t.Errorf("Expected ExtractMetadata to read the sidecar, got %+v, %v", m, err)
+	}
+}
@@ -0,0 +1,468 @@
+package library
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"photoo/internal/exif"
+	"photoo/internal/models"
+)
+
+// archiveEntrySeparator joins an archive's path and the name of an entry in
+// it into the source path recorded for the entry, e.g.
+// /exports/takeout-001.zip!/Takeout/Google Photos/IMG_1234.jpg
+const archiveEntrySeparator = "!/"
+
+// stagingDir is the folder below the library that archive entries are copied
+// to while they are imported.
+const stagingDir = ".staging"
+
+// maxArchiveSidecarSize bounds the sidecars kept in memory during an archive
+// import; larger .json and .xmp entries are not sidecars.
+const maxArchiveSidecarSize = 1 << 20
+
+// IsArchive reports whether path names an archive ImportArchives can read.
+func IsArchive(path string) bool {
+	lower := strings.ToLower(path)
+	for _, suffix := range []string{".zip", ".tgz", ".tar.gz", ".tar"} {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportArchives imports the supported files in one or more zip or tar(.gz)
+// archives, e.g. the parts of a Google Takeout export. Entries are streamed
+// out of the archives into the library one at a time, and duplicates are
+// skipped without being written, so the export is never extracted as a
+// whole. Sidecars are looked up across all parts, since Takeout often puts a
+// photo and its JSON file into different parts.
+//
+// The session is created once every part has been read for sidecars;
+// cancelling before that leaves nothing behind.
+func (m *Manager) ImportArchives(ctx context.Context, parts []string, opts ImportOptions) (ImportProgress, error) {
+	if len(parts) == 0 {
+		return ImportProgress{}, fmt.Errorf("no archive selected")
+	}
+	for _, part := range parts {
+		if !IsArchive(part) {
+			return ImportProgress{}, fmt.Errorf("unsupported archive %s", part)
+		}
+		if strings.ContainsRune(part, os.PathListSeparator) {
+			return ImportProgress{}, fmt.Errorf("unsupported archive path %s", part)
+		}
+	}
+	return m.importArchives(ctx, parts, nil, ImportProgress{}, opts)
+}
+
+// archiveParts returns the archives an import session read from, or nil if
+// its source is not a set of archives.
+func archiveParts(sourcePath string) []string {
+	parts := filepath.SplitList(sourcePath)
+	for _, part := range parts {
+		if !IsArchive(part) {
+			return nil
+		}
+		if info, err := os.Stat(part); err != nil || info.IsDir() {
+			return nil
+		}
+	}
+	return parts
+}
+
+// importArchives imports the entries of parts in two passes. The first
+// reads the sidecars of every part, and lists the supported entries; the
+// second streams the entries through the import one at a time. With a
+// session in progress only the pending entries are imported, otherwise a new
+// session is created for every supported entry.
+func (m *Manager) importArchives(ctx context.Context, parts []string, pending []string, progress ImportProgress, opts ImportOptions) (ImportProgress, error) {
+	var want map[string]bool
+	if progress.SessionID != 0 {
+		want = make(map[string]bool, len(pending))
+		for _, p := range pending {
+			want[p] = true
+		}
+	}
+
+	index, err := indexArchives(ctx, parts, want)
+	if err != nil {
+		if progress.SessionID != 0 {
+			if err := m.setSessionStatus(progress.SessionID, models.SessionCancelled); err != nil {
+				fmt.Printf("[BACKEND] %v\n", err)
+			}
+		}
+		return progress, err
+	}
+
+	if progress.SessionID == 0 {
+		sources := make([]string, len(index.jobs))
+		for i, job := range index.jobs {
+			sources[i] = job.sourcePath
+		}
+		sessionID, err := m.createImportSession(strings.Join(parts, string(os.PathListSeparator)), sources)
+		if err != nil {
+			return progress, err
+		}
+		progress.SessionID = sessionID
+		progress.Total = len(index.jobs)
+	}
+
+	dir := filepath.Join(m.LibraryPath, stagingDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return progress, fmt.Errorf("failed to create staging folder: %w", err)
+	}
+	dir, err = os.MkdirTemp(dir, "archive-*")
+	if err != nil {
+		return progress, fmt.Errorf("failed to create staging folder: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	return m.runPipeline(ctx, func(send func(*importJob) bool) error {
+		if err := m.streamArchives(ctx, parts, dir, index, send); err != nil {
+			return err
+		}
+		// Entries that disappeared from the archives are recorded as errors
+		for _, p := range pending {
+			if index.byPath[p] == nil {
+				if !send(&importJob{sourcePath: p, err: fmt.Errorf("file not found in archive")}) {
+					return nil
+				}
+			}
+		}
+		return nil
+	}, progress, opts)
+}
+
+// archiveIndex is what the first pass over a set of archives finds.
+type archiveIndex struct {
+	sidecars *archiveSidecars
+	jobs     []*importJob // supported entries, in archive order
+	byPath   map[string]*importJob
+}
+
+// indexArchives reads the sidecars of parts and lists their supported
+// entries. If want is non-nil only the entries it contains are listed.
+// Entries of tar archives are hashed on the way, as they cannot be read
+// again before they are written; nothing is written to disk.
+func indexArchives(ctx context.Context, parts []string, want map[string]bool) (*archiveIndex, error) {
+	index := &archiveIndex{
+		sidecars: &archiveSidecars{takeout: make(map[string]*exif.TakeoutIndex), files: make(map[string][]byte)},
+		byPath:   make(map[string]*importJob),
+	}
+	for _, part := range parts {
+		reopenable := isZip(part)
+		err := walkArchive(ctx, part, func(e archiveEntry) error {
+			switch ext := strings.ToLower(path.Ext(e.name)); {
+			case ext == ".json" || ext == ".xmp":
+				if e.size <= maxArchiveSidecarSize {
+					return index.sidecars.add(e)
+				}
+				return nil
+			case !IsSupportedFile(e.name):
+				return nil
+			}
+
+			sourcePath := part + archiveEntrySeparator + e.name
+			if (want != nil && !want[sourcePath]) || index.byPath[sourcePath] != nil {
+				return nil
+			}
+			job := &importJob{sourcePath: sourcePath, size: e.size}
+			if !reopenable {
+				hash, err := hashEntry(ctx, e)
+				if err != nil {
+					return err
+				}
+				job.hash = hash
+			}
+			index.jobs = append(index.jobs, job)
+			index.byPath[sourcePath] = job
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive %s: %w", filepath.Base(part), err)
+		}
+	}
+	return index, nil
+}
+
+// streamArchives walks parts again and hands every indexed entry to send,
+// with its sidecars attached. Entries are copied into dir only once they
+// are known not to be duplicates, so at most the entries in flight take up
+// disk space.
+func (m *Manager) streamArchives(ctx context.Context, parts []string, dir string, index *archiveIndex, send func(*importJob) bool) error {
+	remaining := make(map[string]*importJob, len(index.byPath))
+	for path, job := range index.byPath {
+		remaining[path] = job
+	}
+	for _, part := range parts {
+		err := walkArchive(ctx, part, func(e archiveEntry) error {
+			sourcePath := part + archiveEntrySeparator + e.name
+			job := remaining[sourcePath]
+			if job == nil {
+				return nil
+			}
+			delete(remaining, sourcePath)
+
+			s := index.sidecars.forPhoto(e.name)
+			job.sidecars = &s
+			job.err = m.streamEntry(ctx, e, dir, job)
+			if !send(job) {
+				m.releaseJob(job)
+				return ctx.Err()
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read archive %s: %w", filepath.Base(part), err)
+		}
+	}
+
+	// Entries that changed between the two passes
+	for _, job := range index.jobs {
+		if remaining[job.sourcePath] != nil {
+			job.err = fmt.Errorf("file not found in archive")
+			if !send(job) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// streamEntry checks an archive entry against the library and, unless it is
+// a duplicate, copies it into a synced temporary file in dir with the
+// entry's modification time. Zip entries are hashed by reading them once
+// more first.
+func (m *Manager) streamEntry(ctx context.Context, e archiveEntry, dir string, job *importJob) error {
+	if job.hash == "" {
+		hash, err := hashEntry(ctx, e)
+		if err != nil {
+			return fmt.Errorf("failed to read file from archive: %w", err)
+		}
+		job.hash = hash
+	}
+	if err := m.hashStage(job); err != nil {
+		return err
+	}
+
+	r, err := e.open()
+	if err != nil {
+		return fmt.Errorf("failed to copy file from archive: %w", err)
+	}
+	defer r.Close()
+
+	// The staged file keeps the entry's extension, which tells the metadata
+	// and thumbnail readers how to decode it
+	f, err := os.CreateTemp(dir, ".import-*"+path.Ext(e.name))
+	if err != nil {
+		return fmt.Errorf("failed to copy file from archive: %w", err)
+	}
+	job.tempPath = f.Name()
+
+	h := sha256.New()
+	job.size, err = io.Copy(io.MultiWriter(f, h), contextReader{ctx, r})
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy file from archive: %w", err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != job.hash {
+		return fmt.Errorf("%w: %s changed while it was imported", ErrCopy, e.name)
+	}
+	if !e.modTime.IsZero() {
+		os.Chtimes(job.tempPath, e.modTime, e.modTime)
+	}
+	return nil
+}
+
+// hashEntry returns the SHA-256 of an archive entry's content.
+func hashEntry(ctx context.Context, e archiveEntry) (string, error) {
+	r, err := e.open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, contextReader{ctx, r}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// contextReader stops a copy once ctx is cancelled, so large entries do not
+// hold up cancellation.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// archiveSidecars holds the sidecars found in a set of archives, keyed by
+// entry name. Folders of the same name in different parts are merged.
+type archiveSidecars struct {
+	takeout map[string]*exif.TakeoutIndex // per folder
+	files   map[string][]byte
+}
+
+func (s *archiveSidecars) add(e archiveEntry) error {
+	r, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, maxArchiveSidecarSize))
+	if err != nil {
+		return err
+	}
+	s.files[e.name] = data
+
+	if strings.EqualFold(path.Ext(e.name), ".json") {
+		dir := path.Dir(e.name)
+		if s.takeout[dir] == nil {
+			s.takeout[dir] = &exif.TakeoutIndex{}
+		}
+		s.takeout[dir].Add(path.Base(e.name))
+	}
+	return nil
+}
+
+// forPhoto returns the sidecars of the entry called name.
+func (s *archiveSidecars) forPhoto(name string) exif.Sidecars {
+	sidecars := exif.Sidecars{Name: path.Base(name)}
+	for _, n := range exif.XMPSidecarNames(name) {
+		if data, ok := s.files[n]; ok {
+			sidecars.XMP = data
+			break
+		}
+	}
+	dir := path.Dir(name)
+	if index := s.takeout[dir]; index != nil {
+		if match := index.Match(sidecars.Name); match != "" {
+			sidecars.Takeout = s.files[path.Join(dir, match)]
+		}
+	}
+	return sidecars
+}
+
+// archiveEntry is a regular file in an archive.
+type archiveEntry struct {
+	name    string // slash-separated path inside the archive
+	modTime time.Time
+	size    int64
+	// open returns the entry's content. For tar archives it is only valid
+	// until the walk moves on.
+	open func() (io.ReadCloser, error)
+}
+
+// walkArchive calls fn for every regular file in the archive at path, in
+// archive order.
+func walkArchive(ctx context.Context, archivePath string, fn func(archiveEntry) error) error {
+	if isZip(archivePath) {
+		return walkZip(ctx, archivePath, fn)
+	}
+	return walkTar(ctx, archivePath, fn)
+}
+
+// isZip reports whether archivePath is a zip archive, whose entries can be
+// opened more than once.
+func isZip(archivePath string) bool {
+	return strings.HasSuffix(strings.ToLower(archivePath), ".zip")
+}
+
+func walkZip(ctx context.Context, archivePath string, fn func(archiveEntry) error) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		err := fn(archiveEntry{
+			name:    entryName(f.Name),
+			modTime: f.Modified,
+			size:    int64(f.UncompressedSize64),
+			open:    f.Open,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(ctx context.Context, archivePath string, fn func(archiveEntry) error) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if lower := strings.ToLower(archivePath); strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = fn(archiveEntry{
+			name:    entryName(hdr.Name),
+			modTime: hdr.ModTime,
+			size:    hdr.Size,
+			open:    func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// entryName normalizes the name of an archive entry, e.g. "./a/b.jpg" to
+// "a/b.jpg".
+func entryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
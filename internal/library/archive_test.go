@@ -0,0 +1,161 @@
+package library
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"photoo/internal/models"
+)
+
+func writeZip(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarGz(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: time.Now(), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportArchives(t *testing.T) {
+	manager := newTestManager(t)
+
+	// Takeout split the photo and its sidecar over two parts
+	dir := t.TempDir()
+	part1 := filepath.Join(dir, "takeout-001.zip")
+	part2 := filepath.Join(dir, "takeout-002.tgz")
+	writeZip(t, part1, map[string]string{
+		"Takeout/Google Photos/Spring/PXL_20220301_101500000.jpg": "first-photo",
+		"Takeout/Google Photos/Spring/notes.txt":                  "not a photo",
+	})
+	writeTarGz(t, part2, map[string]string{
+		"./Takeout/Google Photos/Spring/PXL_20220301_101500000.jpg.supplemental-metadata.json": `{
+			"description": "Blossoms",
+			"photoTakenTime": {"timestamp": "1646129700"},
+			"people": [{"name": "Ada"}]
+		}`,
+		"./Takeout/Google Photos/Spring/IMG_0002.png": "second-photo",
+	})
+
+	var staged int
+	progress, err := manager.ImportArchives(context.Background(), []string{part1, part2}, ImportOptions{
+		Workers:    2,
+		OnProgress: func(p ImportProgress) { staged = p.Staged },
+	})
+	if err != nil {
+		t.Fatalf("ImportArchives failed: %v", err)
+	}
+	if progress.Imported != 2 || progress.Errors != 0 || staged != 2 {
+		t.Fatalf("Unexpected progress %+v (staged %d)", progress, staged)
+	}
+
+	items, _ := manager.GetImportSessionItems(progress.SessionID, "")
+	if len(items) != 2 || items[0].SourcePath != part1+"!/Takeout/Google Photos/Spring/PXL_20220301_101500000.jpg" {
+		t.Fatalf("Unexpected session items %+v", items)
+	}
+	photo, err := manager.GetPhoto(*items[0].PhotoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if photo.Description != "Blossoms" || !reflect.DeepEqual(photo.People, []string{"Ada"}) || photo.DateTakenUTC.Unix() != 1646129700 {
+		t.Errorf("Expected the sidecar from the other part to be read, got %+v", photo)
+	}
+	if content, _ := os.ReadFile(photo.LibraryPath); string(content) != "first-photo" {
+		t.Errorf("Unexpected library file content %q", content)
+	}
+	// Without a sidecar the date comes from the archive entry
+	second, _ := manager.GetPhoto(*items[1].PhotoID)
+	if second == nil || second.DateTaken.Year() != time.Now().Year() {
+		t.Errorf("Unexpected second photo %+v", second)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(manager.LibraryPath, stagingDir)); len(entries) != 0 {
+		t.Errorf("Expected the staging folder to be cleaned up, found %d entries", len(entries))
+	}
+
+	// Entries already in the library are rejected before anything is written
+	walkArchive(context.Background(), part1, func(e archiveEntry) error {
+		if IsSupportedFile(e.name) {
+			job := &importJob{sourcePath: part1 + archiveEntrySeparator + e.name}
+			if err := manager.streamEntry(context.Background(), e, filepath.Join(dir, "missing"), job); !errors.Is(err, ErrDuplicate) {
+				t.Errorf("Expected %s to be a duplicate, got %v", e.name, err)
+			}
+		}
+		return nil
+	})
+
+	// Resuming streams only the pending entries out of the archives again
+	manager.DB.Exec("DELETE FROM photos WHERE id = ?", photo.ID)
+	manager.DB.Exec("UPDATE import_session_items SET status = ?, photo_id = NULL WHERE id = ?", models.ItemPending, items[0].ID)
+	manager.setSessionStatus(progress.SessionID, models.SessionCancelled)
+
+	staged = 0
+	progress, err = manager.ResumeImport(context.Background(), progress.SessionID, ImportOptions{
+		OnProgress: func(p ImportProgress) { staged = p.Staged },
+	})
+	if err != nil {
+		t.Fatalf("ResumeImport failed: %v", err)
+	}
+	if progress.Imported != 2 || progress.Current != 2 || staged != 1 {
+		t.Errorf("Unexpected progress after resume %+v (staged %d)", progress, staged)
+	}
+}
+
+func TestImportArchiveRawEntry(t *testing.T) {
+	manager := newTestManager(t)
+
+	dir := t.TempDir()
+	writeTestRaw(t, filepath.Join(dir, "RIMG0024.DNG"), "RIMG0024.JPG")
+	raw := mustRead(t, filepath.Join(dir, "RIMG0024.DNG"))
+	part := filepath.Join(dir, "takeout-001.zip")
+	writeZip(t, part, map[string]string{"Takeout/Google Photos/RIMG0024.DNG": string(raw)})
+
+	progress, err := manager.ImportArchives(context.Background(), []string{part}, ImportOptions{})
+	if err != nil || progress.Imported != 1 {
+		t.Fatalf("ImportArchives failed: %v, %+v", err, progress)
+	}
+	photos, _ := manager.SearchPhotos(PhotoFilter{})
+	if len(photos) != 1 || photos[0].CameraModel != "Caplio R5" {
+		t.Fatalf("Expected the raw entry with its EXIF, got %+v", photos)
+	}
+	// The embedded preview was decoded for the near-duplicate check
+	var phash *int64
+	manager.DB.QueryRow("SELECT phash FROM photos WHERE id = ?", photos[0].ID).Scan(&phash)
+	if phash == nil {
+		t.Error("Expected a perceptual hash from the raw preview")
+	}
+}
@@ -0,0 +1,191 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"photoo/internal/models"
+)
+
+// PhotoPage is one page of the timeline returned by PhotosPage.
+type PhotoPage struct {
+	Photos []models.Photo `json:"photos"`
+	// NextCursor and PrevCursor load the adjacent pages; they are empty when
+	// there is nothing more in that direction.
+	NextCursor string `json:"next_cursor"`
+	PrevCursor string `json:"prev_cursor"`
+}
+
+// pageCursor is the decoded form of an opaque page cursor. It points at a row
+// by its (date_taken, id) key, so it stays valid while photos are inserted.
+type pageCursor struct {
+	DateTaken *string `json:"d"` // raw date_taken text; nil for undated photos
+	ID        int64   `json:"i"`
+	Backward  bool    `json:"b,omitempty"` // page ends before the row instead of starting after it
+}
+
+func (c pageCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var c pageCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &c, nil
+}
+
+// PhotosPage returns up to limit photos in timeline order (date_taken DESC,
+// id DESC, undated photos last), starting from cursor. An empty cursor
+// returns the first page. Unlike LIMIT/OFFSET paging the cost does not grow
+// with the depth, because the (date_taken, id) key is looked up in
+// idx_photos_date_taken, which implicitly ends with the rowid id.
+func (m *Manager) PhotosPage(cursor string, limit int) (*PhotoPage, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive")
+	}
+
+	var c *pageCursor
+	if cursor != "" {
+		var err error
+		if c, err = decodeCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	photos, keys, err := m.pageRows(pageSegments(c), limit+1)
+	if err != nil {
+		return nil, err
+	}
+	// One extra row tells whether there is another page
+	more := len(photos) > limit
+	if more {
+		photos, keys = photos[:limit], keys[:limit]
+	}
+	backward := c != nil && c.Backward
+	if backward {
+		for i, j := 0, len(photos)-1; i < j; i, j = i+1, j-1 {
+			photos[i], photos[j] = photos[j], photos[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	page := &PhotoPage{Photos: photos}
+	if len(photos) == 0 {
+		return page, nil
+	}
+	first, last := keys[0], keys[len(keys)-1]
+	first.Backward = true
+	hasPrev := backward && more
+	if !backward && c != nil {
+		// The photos before the cursor may be gone, e.g. trashed since
+		before, _, err := m.pageRows(pageSegments(&first), 1)
+		if err != nil {
+			return nil, err
+		}
+		hasPrev = len(before) > 0
+	}
+	if hasPrev {
+		page.PrevCursor = first.encode()
+	}
+	if (!backward && more) || backward {
+		page.NextCursor = last.encode()
+	}
+	return page, nil
+}
+
+// pageSegment is one query of a page, a WHERE clause with its ORDER BY.
+type pageSegment struct {
+	query string
+	args  []interface{}
+}
+
+// pageSegments returns the queries that load the rows after c, or before it
+// for a backward cursor, in the order they are paged through.
+func pageSegments(c *pageCursor) []pageSegment {
+	// Dated and undated photos are fetched by separate queries: an OR across
+	// both would keep SQLite from seeking in the index.
+	const (
+		datedDesc   = "date_taken IS NOT NULL ORDER BY date_taken DESC, id DESC"
+		undatedDesc = "date_taken IS NULL ORDER BY id DESC"
+	)
+	switch {
+	case c == nil:
+		return []pageSegment{{datedDesc, nil}, {undatedDesc, nil}}
+	case !c.Backward && c.DateTaken != nil:
+		return []pageSegment{
+			{"(date_taken, id) < (?, ?) ORDER BY date_taken DESC, id DESC", []interface{}{*c.DateTaken, c.ID}},
+			{undatedDesc, nil},
+		}
+	case !c.Backward:
+		return []pageSegment{{"date_taken IS NULL AND id < ? ORDER BY id DESC", []interface{}{c.ID}}}
+	// Backward pages walk towards the start, nearest rows first, and are
+	// reversed afterwards
+	case c.DateTaken != nil:
+		return []pageSegment{{"(date_taken, id) > (?, ?) ORDER BY date_taken ASC, id ASC", []interface{}{*c.DateTaken, c.ID}}}
+	default:
+		return []pageSegment{
+			{"date_taken IS NULL AND id > ? ORDER BY id ASC", []interface{}{c.ID}},
+			{"date_taken IS NOT NULL ORDER BY date_taken ASC, id ASC", nil},
+		}
+	}
+}
+
+// pageRows runs segments until limit rows are loaded, and returns them with
+// their keys.
+func (m *Manager) pageRows(segments []pageSegment, limit int) ([]models.Photo, []pageCursor, error) {
+	var photos []models.Photo
+	var keys []pageCursor
+	for _, seg := range segments {
+		remaining := limit - len(photos)
+		if remaining <= 0 {
+			break
+		}
+		rows, err := m.DB.Query(
+			"SELECT "+PhotoColumns+", CAST(date_taken AS TEXT) FROM photos WHERE "+seg.query+" LIMIT ?",
+			append(seg.args, remaining)...,
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load photos: %w", err)
+		}
+		for rows.Next() {
+			var rawDate sql.NullString
+			p, err := scanPhoto(extraScanner{rows, []interface{}{&rawDate}})
+			if err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+			key := pageCursor{ID: p.ID}
+			if rawDate.Valid {
+				key.DateTaken = &rawDate.String
+			}
+			photos = append(photos, p)
+			keys = append(keys, key)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return photos, keys, nil
+}
+
+// extraScanner appends additional destinations to every Scan call, so that
+// scanPhoto can be reused for queries selecting extra columns after
+// PhotoColumns.
+type extraScanner struct {
+	rowScanner
+	extra []interface{}
+}
+
+func (s extraScanner) Scan(dest ...interface{}) error {
+	return s.rowScanner.Scan(append(dest, s.extra...)...)
+}
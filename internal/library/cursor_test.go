@@ -0,0 +1,145 @@
+package library
+
+import (
+	"fmt"
+	"path/filepath"
+	"photoo/internal/db"
+	"testing"
+	"time"
+)
+
+func TestPhotosPage(t *testing.T) {
+	manager := newTestManager(t)
+
+	base := time.Date(2023, 6, 1, 12, 0, 0, 0, time.Local)
+	var expected []int64
+	for i := 0; i < 8; i++ {
+		// Two photos share each timestamp so the id breaks ties
+		id := insertTestPhoto(t, manager, fmt.Sprintf("p%d.jpg", i), base.Add(time.Duration(-(i/2))*time.Hour))
+		expected = append(expected, id)
+	}
+	for i := 0; i < 2; i++ {
+		res, _ := manager.DB.Exec("INSERT INTO photos (library_path, filename, hash) VALUES ('x', ?, 'h')", fmt.Sprintf("undated%d.jpg", i))
+		id, _ := res.LastInsertId()
+		expected = append(expected, id)
+	}
+	// Timeline order: newest first, id DESC within a timestamp, undated last
+	for i := 0; i < 8; i += 2 {
+		expected[i], expected[i+1] = expected[i+1], expected[i]
+	}
+	expected[8], expected[9] = expected[9], expected[8]
+
+	var got []int64
+	var pages []*PhotoPage
+	cursor := ""
+	for {
+		page, err := manager.PhotosPage(cursor, 3)
+		if err != nil {
+			t.Fatalf("PhotosPage failed: %v", err)
+		}
+		pages = append(pages, page)
+		for _, p := range page.Photos {
+			got = append(got, p.ID)
+		}
+
+		if len(pages) == 2 {
+			// A photo imported mid-scroll at the top must not shift later pages
+			insertTestPhoto(t, manager, "new.jpg", base.Add(time.Hour))
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("Expected order %v, got %v", expected, got)
+	}
+	if pages[0].PrevCursor != "" {
+		t.Error("First page must not have a previous cursor")
+	}
+
+	// Walking back from the last page returns the page before it
+	prev, err := manager.PhotosPage(pages[len(pages)-1].PrevCursor, 3)
+	if err != nil {
+		t.Fatalf("PhotosPage backwards failed: %v", err)
+	}
+	if fmt.Sprint(prev.Photos) != fmt.Sprint(pages[len(pages)-2].Photos) {
+		t.Errorf("Backward page differs from forward page:\n%v\n%v", prev.Photos, pages[len(pages)-2].Photos)
+	}
+
+	// Nothing precedes a page once the photos before its cursor are gone
+	for _, p := range pages[0].Photos {
+		manager.DB.Exec("DELETE FROM photos WHERE id = ?", p.ID)
+	}
+	manager.DB.Exec("DELETE FROM photos WHERE filename = 'new.jpg'")
+	page, err := manager.PhotosPage(pages[0].NextCursor, 3)
+	if err != nil || len(page.Photos) != 3 {
+		t.Fatalf("Unexpected page %+v, %v", page, err)
+	}
+	if page.PrevCursor != "" {
+		t.Error("Expected no previous cursor without photos before the page")
+	}
+
+	if _, err := manager.PhotosPage("not-a-cursor", 3); err == nil {
+		t.Error("Expected error for invalid cursor")
+	}
+}
+
+// BenchmarkPaging compares keyset and OFFSET paging deep into a synthetic
+// 200k-photo library. Run with: go test -bench Paging ./internal/library/
+func BenchmarkPaging(b *testing.B) {
+	const photos = 200000
+	const depth = 150000
+	const pageSize = 100
+
+	dbConn, err := db.InitDB(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer dbConn.Close()
+	manager := &Manager{DB: dbConn}
+
+	tx, _ := dbConn.Begin()
+	stmt, _ := tx.Prepare("INSERT INTO photos (library_path, filename, hash, date_taken) VALUES ('x', ?, 'h', ?)")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	for i := 0; i < photos; i++ {
+		if _, err := stmt.Exec(fmt.Sprintf("p%d.jpg", i), base.Add(time.Duration(-i)*time.Minute)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	stmt.Close()
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+
+	// Cursor pointing at the row just before the benchmarked page
+	var id int64
+	var rawDate string
+	err = dbConn.QueryRow("SELECT id, CAST(date_taken AS TEXT) FROM photos ORDER BY date_taken DESC, id DESC LIMIT 1 OFFSET ?", depth-1).Scan(&id, &rawDate)
+	if err != nil {
+		b.Fatal(err)
+	}
+	cursor := pageCursor{DateTaken: &rawDate, ID: id}.encode()
+
+	b.Run("Keyset", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			page, err := manager.PhotosPage(cursor, pageSize)
+			if err != nil || len(page.Photos) != pageSize {
+				b.Fatalf("unexpected page: %v", err)
+			}
+		}
+	})
+
+	b.Run("Offset", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rows, err := dbConn.Query("SELECT "+PhotoColumns+" FROM photos ORDER BY date_taken DESC LIMIT ? OFFSET ?", pageSize, depth)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if page, err := ScanPhotos(rows); err != nil || len(page) != pageSize {
+				b.Fatalf("unexpected page: %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,404 @@
+package library
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"photoo/internal/models"
+)
+
+// Every imported still gets a perceptual hash. Photos whose hashes are
+// within nearDuplicateDistance of each other are put into a duplicate group
+// at insert time, where the user can pick the copy to keep.
+
+// similarIndex is the in-memory BK-tree of the library's perceptual hashes.
+// It is loaded from the database on first use and kept up to date by
+// imports. Photos removed behind its back may still be returned; the
+// database has the final say.
+type similarIndex struct {
+	mu     sync.Mutex
+	loaded bool
+	tree   bkTree
+	hashes map[int64]uint64 // photo ID to hash, for removal
+}
+
+// nearDuplicates returns the photos whose perceptual hash is close to hash.
+// It must not be called while a transaction holds the database connection.
+func (m *Manager) nearDuplicates(hash uint64) ([]bkMatch, error) {
+	m.similar.mu.Lock()
+	defer m.similar.mu.Unlock()
+
+	if !m.similar.loaded {
+		rows, err := m.DB.Query("SELECT id, phash FROM photos WHERE phash IS NOT NULL")
+		if err != nil {
+			return nil, fmt.Errorf("failed to load perceptual hashes: %w", err)
+		}
+		defer rows.Close()
+		m.similar.hashes = map[int64]uint64{}
+		for rows.Next() {
+			var id, h int64
+			if err := rows.Scan(&id, &h); err != nil {
+				return nil, fmt.Errorf("failed to load perceptual hashes: %w", err)
+			}
+			m.similar.tree.add(uint64(h), id)
+			m.similar.hashes[id] = uint64(h)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to load perceptual hashes: %w", err)
+		}
+		m.similar.loaded = true
+	}
+	return m.similar.tree.search(hash, nearDuplicateDistance), nil
+}
+
+// indexPerceptualHash adds a newly imported photo to the index.
+func (m *Manager) indexPerceptualHash(id int64, hash uint64) {
+	m.similar.mu.Lock()
+	defer m.similar.mu.Unlock()
+	if m.similar.loaded {
+		m.similar.tree.add(hash, id)
+		m.similar.hashes[id] = hash
+	}
+}
+
+// unindexPhoto removes a photo that is no longer a library item.
+func (m *Manager) unindexPhoto(id int64) {
+	m.similar.mu.Lock()
+	defer m.similar.mu.Unlock()
+	if hash, ok := m.similar.hashes[id]; ok {
+		m.similar.tree.remove(hash, id)
+		delete(m.similar.hashes, id)
+	}
+}
+
+// groupNearDuplicates puts photo id into one duplicate group with the
+// matched photos still in the library, and returns those. Groups of matched
+// photos are merged into the oldest one.
+func groupNearDuplicates(tx *sql.Tx, id int64, matches []bkMatch) ([]int64, error) {
+	var members []int64
+	var target int64
+	var others []int64
+	for _, match := range matches {
+		var group sql.NullInt64
+		err := tx.QueryRow("SELECT m.group_id FROM photos p LEFT JOIN duplicate_group_members m ON m.photo_id = p.id WHERE p.id = ?", match.ID).Scan(&group)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		members = append(members, match.ID)
+		switch {
+		case !group.Valid || group.Int64 == target:
+		case target == 0:
+			target = group.Int64
+		case group.Int64 < target:
+			others = append(others, target)
+			target = group.Int64
+		default:
+			others = append(others, group.Int64)
+		}
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	if target == 0 {
+		res, err := tx.Exec("INSERT INTO duplicate_groups DEFAULT VALUES")
+		if err != nil {
+			return nil, err
+		}
+		target, _ = res.LastInsertId()
+	}
+	for _, other := range others {
+		if _, err := tx.Exec("UPDATE duplicate_group_members SET group_id = ? WHERE group_id = ?", target, other); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("DELETE FROM duplicate_groups WHERE id = ?", other); err != nil {
+			return nil, err
+		}
+	}
+	for _, photoID := range append(members, id) {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO duplicate_group_members (group_id, photo_id) VALUES (?, ?)", target, photoID); err != nil {
+			return nil, err
+		}
+	}
+	return members, nil
+}
+
+// ListDuplicateGroups returns the groups of near-duplicate photos that
+// still have more than one member, oldest first.
+func (m *Manager) ListDuplicateGroups() ([]models.DuplicateGroup, error) {
+	rows, err := m.DB.Query(`SELECT g.id, g.keeper_id, g.created_at FROM duplicate_groups g
+		WHERE (SELECT COUNT(*) FROM duplicate_group_members WHERE group_id = g.id) > 1 ORDER BY g.id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list duplicate groups: %w", err)
+	}
+	var groups []models.DuplicateGroup
+	for rows.Next() {
+		var g models.DuplicateGroup
+		var keeper sql.NullInt64
+		var created sql.NullTime
+		if err := rows.Scan(&g.ID, &keeper, &created); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to list duplicate groups: %w", err)
+		}
+		if keeper.Valid {
+			g.KeeperID = &keeper.Int64
+		}
+		g.CreatedAt = created.Time
+		groups = append(groups, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list duplicate groups: %w", err)
+	}
+
+	for i := range groups {
+		if groups[i].Photos, err = m.groupPhotos(groups[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+func (m *Manager) groupPhotos(groupID int64) ([]models.Photo, error) {
+	rows, err := m.DB.Query("SELECT "+PhotoColumns+" FROM photos WHERE id IN (SELECT photo_id FROM duplicate_group_members WHERE group_id = ?) ORDER BY id", groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load duplicate group %d: %w", groupID, err)
+	}
+	photos, err := ScanPhotos(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load duplicate group %d: %w", groupID, err)
+	}
+	return photos, nil
+}
+
+// SetDuplicateKeeper records which photo of a duplicate group to keep.
+func (m *Manager) SetDuplicateKeeper(groupID, photoID int64) error {
+	res, err := m.DB.Exec(`UPDATE duplicate_groups SET keeper_id = ? WHERE id = ?
+		AND EXISTS (SELECT 1 FROM duplicate_group_members WHERE group_id = ? AND photo_id = ?)`, photoID, groupID, groupID, photoID)
+	if err != nil {
+		return fmt.Errorf("failed to set keeper: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("photo %d is not in duplicate group %d", photoID, groupID)
+	}
+	return nil
+}
+
+// ResolveDuplicateGroup keeps one photo of a duplicate group and moves the
+// others to the trash. The keeper is the one set with SetDuplicateKeeper, or
+// else the copy with the largest resolution and then the richest metadata.
+// GPS and keywords of the other copies are merged into it, and it takes the
+// earliest date of the group, as copies tend to carry the date they were
+// made. Trashed copies count as duplicates in later imports. It is one edit
+// batch: undo restores the trashed copies and the keeper's metadata. The
+// merged keeper is returned.
+func (m *Manager) ResolveDuplicateGroup(groupID int64) (*models.Photo, error) {
+	var keeperID sql.NullInt64
+	err := m.DB.QueryRow("SELECT keeper_id FROM duplicate_groups WHERE id = ?", groupID).Scan(&keeperID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("duplicate group %d not found", groupID)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load duplicate group %d: %w", groupID, err)
+	}
+	photos, err := m.groupPhotos(groupID)
+	if err != nil {
+		return nil, err
+	}
+	if len(photos) < 2 {
+		return nil, fmt.Errorf("duplicate group %d has nothing to resolve", groupID)
+	}
+
+	keeper := pickKeeper(photos, keeperID.Int64)
+	var losers []*models.Photo
+	for i := range photos {
+		if photos[i].ID != keeper.ID {
+			losers = append(losers, &photos[i])
+		}
+	}
+
+	// Merging and trashing happen in one transaction, so a failure leaves the
+	// group as it was
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+	moves := &fileMoves{m: m}
+	committed := false
+	defer func() { moves.finish(committed) }()
+
+	// The merge and the trashing are one batch in the edit history, so undo
+	// restores the trashed copies as well
+	changes := mergedMetadata(keeper, losers)
+	for _, loser := range losers {
+		changes = append(changes, MetadataChange{PhotoID: loser.ID, Field: "trashed", Value: keeper.ID})
+	}
+	if _, err := m.applyMetadataChanges(tx, moves, "Merge duplicates", changes); err != nil {
+		return nil, fmt.Errorf("failed to merge duplicates: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM duplicate_group_members WHERE group_id = ?", groupID); err != nil {
+		return nil, fmt.Errorf("failed to remove duplicate group: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM duplicate_groups WHERE id = ?", groupID); err != nil {
+		return nil, fmt.Errorf("failed to remove duplicate group: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit duplicate resolution: %w", err)
+	}
+	committed = true
+	m.writeBack(changes)
+	fmt.Printf("[BACKEND] Resolved duplicate group %d: kept photo %d, trashed %d\n", groupID, keeper.ID, len(losers))
+	return m.GetPhoto(keeper.ID)
+}
+
+// pickKeeper returns the photo with ID keeperID, or the best copy if it is
+// not in photos.
+func pickKeeper(photos []models.Photo, keeperID int64) *models.Photo {
+	var best *models.Photo
+	for i := range photos {
+		p := &photos[i]
+		if p.ID == keeperID {
+			return p
+		}
+		if best == nil || betterCopy(p, best) {
+			best = p
+		}
+	}
+	return best
+}
+
+// betterCopy reports whether a is preferable to b: more pixels first, then
+// more metadata. Ties keep the older photo.
+func betterCopy(a, b *models.Photo) bool {
+	if pa, pb := a.Width*a.Height, b.Width*b.Height; pa != pb {
+		return pa > pb
+	}
+	if ra, rb := metadataRichness(a), metadataRichness(b); ra != rb {
+		return ra > rb
+	}
+	return a.ID < b.ID
+}
+
+// metadataRichness counts the metadata fields that are set on p.
+func metadataRichness(p *models.Photo) int {
+	n := len(p.Keywords) + len(p.People)
+	for _, set := range []bool{
+		p.CameraMake != "", p.CameraModel != "", p.LensModel != "",
+		p.FNumber != nil, p.ExposureTime != nil, p.ISO != nil, p.FocalLength != nil,
+		p.Latitude != nil && p.Longitude != nil, p.Altitude != nil, p.UTCOffset != nil,
+		p.Title != "", p.Description != "", p.Rating != 0,
+	} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// mergedMetadata returns the changes that give keeper the keywords of all
+// losers, the GPS position of the first loser that has one, if keeper has
+// none, and the earliest date taken.
+func mergedMetadata(keeper *models.Photo, losers []*models.Photo) []MetadataChange {
+	var changes []MetadataChange
+	keywords := slices.Clone(keeper.Keywords)
+	for _, loser := range losers {
+		for _, keyword := range loser.Keywords {
+			if !slices.Contains(keywords, keyword) {
+				keywords = append(keywords, keyword)
+			}
+		}
+	}
+	if len(keywords) > len(keeper.Keywords) {
+		slices.Sort(keywords)
+		changes = append(changes, MetadataChange{PhotoID: keeper.ID, Field: "keywords", Value: keywords})
+	}
+	if keeper.Latitude == nil || keeper.Longitude == nil {
+		for _, loser := range losers {
+			if loser.Latitude != nil && loser.Longitude != nil {
+				changes = append(changes,
+					MetadataChange{PhotoID: keeper.ID, Field: "latitude", Value: *loser.Latitude},
+					MetadataChange{PhotoID: keeper.ID, Field: "longitude", Value: *loser.Longitude})
+				break
+			}
+		}
+	}
+
+	earliest := keeper
+	for _, loser := range losers {
+		if !loser.DateTakenUTC.IsZero() && (earliest.DateTakenUTC.IsZero() || loser.DateTakenUTC.Before(earliest.DateTakenUTC)) {
+			earliest = loser
+		}
+	}
+	if earliest != keeper {
+		changes = append(changes, MetadataChange{PhotoID: keeper.ID, Field: "date_taken", Value: earliest.DateTaken})
+	}
+	return changes
+}
+
+// recordDuplicateHit remembers that job was skipped as an exact duplicate.
+// sessionID is zero for single-file imports.
+func (m *Manager) recordDuplicateHit(sessionID int64, job *importJob) error {
+	var session, photo sql.NullInt64
+	if sessionID != 0 {
+		session = sql.NullInt64{Int64: sessionID, Valid: true}
+	}
+	var dup *DuplicateError
+	if errors.As(job.err, &dup) && dup.ExistingID != 0 {
+		photo = sql.NullInt64{Int64: dup.ExistingID, Valid: true}
+	}
+	_, err := m.DB.Exec("INSERT INTO duplicate_hits (source_path, hash, photo_id, session_id, detected_at) VALUES (?, ?, ?, ?, ?)",
+		job.sourcePath, job.hash, photo, session, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record duplicate: %w", err)
+	}
+	return nil
+}
+
+// ListDuplicateHits returns the files skipped as exact duplicates, newest
+// first. A sessionID of zero lists the hits of all imports. Hits on a file
+// that was still being imported when they were detected are resolved to the
+// photo it became.
+func (m *Manager) ListDuplicateHits(sessionID int64) ([]models.DuplicateHit, error) {
+	query := `SELECT h.id, h.source_path, h.hash,
+		COALESCE(h.photo_id, (SELECT id FROM photos WHERE hash = h.hash OR alternate_hash = h.hash ORDER BY id LIMIT 1)),
+		h.session_id, h.detected_at FROM duplicate_hits h`
+	var args []interface{}
+	if sessionID != 0 {
+		query += " WHERE h.session_id = ?"
+		args = append(args, sessionID)
+	}
+	rows, err := m.DB.Query(query+" ORDER BY h.id DESC", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list duplicate hits: %w", err)
+	}
+	defer rows.Close()
+
+	var hits []models.DuplicateHit
+	for rows.Next() {
+		var h models.DuplicateHit
+		var photo, session sql.NullInt64
+		var detected sql.NullTime
+		if err := rows.Scan(&h.ID, &h.SourcePath, &h.Hash, &photo, &session, &detected); err != nil {
+			return nil, fmt.Errorf("failed to list duplicate hits: %w", err)
+		}
+		if photo.Valid {
+			h.PhotoID = &photo.Int64
+		}
+		if session.Valid {
+			h.SessionID = &session.Int64
+		}
+		h.DetectedAt = detected.Time
+		hits = append(hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list duplicate hits: %w", err)
+	}
+	return hits, nil
+}
@@ -0,0 +1,257 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestNearDuplicateGroups(t *testing.T) {
+	manager := newTestManager(t)
+	srcDir := t.TempDir()
+
+	original, err := importPhoto(manager, "../../test_data/source_digital_camera/RIMG0018.JPG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.ImportPhoto("../../test_data/source_digital_camera/RIMG0020.JPG"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A smaller, recompressed copy is imported, then grouped with the original
+	img, _ := imaging.Open("../../test_data/source_digital_camera/RIMG0018.JPG")
+	copyPath := filepath.Join(srcDir, "IMG-20240101-WA0001.jpg")
+	if err := imaging.Save(imaging.Resize(img, 800, 0, imaging.Lanczos), copyPath, imaging.JPEGQuality(60)); err != nil {
+		t.Fatal(err)
+	}
+	result, err := manager.ImportPhoto(copyPath)
+	if err != nil {
+		t.Fatalf("Expected the copy to be imported, got %v", err)
+	}
+	resized := result.Photo
+	if len(result.NearDuplicates) != 1 || result.NearDuplicates[0] != original.ID {
+		t.Errorf("Expected the original as near-duplicate, got %v", result.NearDuplicates)
+	}
+
+	groups, err := manager.ListDuplicateGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || len(groups[0].Photos) != 2 || groups[0].Photos[0].ID != original.ID || groups[0].Photos[1].ID != resized.ID {
+		t.Fatalf("Expected the original and its copy in one group, got %+v", groups)
+	}
+
+	// A second copy joins the group; a fresh index loaded from the database
+	// finds it as well
+	manager.similar = similarIndex{}
+	os.WriteFile(filepath.Join(srcDir, "copy2.jpg"), append(mustRead(t, copyPath), "2"...), 0644)
+	if _, err := manager.ImportPhoto(filepath.Join(srcDir, "copy2.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	groups, _ = manager.ListDuplicateGroups()
+	if len(groups) != 1 || len(groups[0].Photos) != 3 {
+		t.Fatalf("Expected one group of 3, got %+v", groups)
+	}
+
+	if err := manager.SetDuplicateKeeper(groups[0].ID, original.ID); err != nil {
+		t.Fatal(err)
+	}
+	groups, _ = manager.ListDuplicateGroups()
+	if groups[0].KeeperID == nil || *groups[0].KeeperID != original.ID {
+		t.Errorf("Expected the original as keeper, got %v", groups[0].KeeperID)
+	}
+	if err := manager.SetDuplicateKeeper(groups[0].ID, original.ID+1); err == nil {
+		t.Error("Expected an error for a photo outside the group")
+	}
+}
+
+func TestResolveDuplicateGroup(t *testing.T) {
+	manager := newTestManager(t)
+	srcDir := t.TempDir()
+
+	original, err := importPhoto(manager, "../../test_data/source_digital_camera/RIMG0018.JPG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _ := imaging.Open("../../test_data/source_digital_camera/RIMG0018.JPG")
+	copyPath := filepath.Join(srcDir, "shared.jpg")
+	imaging.Save(imaging.Resize(img, 800, 0, imaging.Lanczos), copyPath, imaging.JPEGQuality(60))
+	shared, err := importPhoto(manager, copyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The smaller copy has the GPS position, an earlier date and a keyword
+	earlier := original.DateTaken.Add(-time.Hour)
+	if _, err := manager.ApplyMetadataChanges("Tag copy", []MetadataChange{
+		{PhotoID: shared.ID, Field: "latitude", Value: 48.85},
+		{PhotoID: shared.ID, Field: "longitude", Value: 2.35},
+		{PhotoID: shared.ID, Field: "date_taken", Value: earlier},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	manager.DB.Exec("INSERT INTO photo_keywords (photo_id, keyword) VALUES (?, 'paris')", shared.ID)
+	shared, _ = manager.GetPhoto(shared.ID)
+
+	groups, _ := manager.ListDuplicateGroups()
+	if len(groups) != 1 {
+		t.Fatalf("Expected one group, got %d", len(groups))
+	}
+
+	// A failure while trashing leaves the group untouched
+	blocker := filepath.Join(manager.LibraryPath, trashDir)
+	os.WriteFile(blocker, nil, 0644)
+	if _, err := manager.ResolveDuplicateGroup(groups[0].ID); err == nil {
+		t.Fatal("Expected resolving to fail without a trash folder")
+	}
+	if p, _ := manager.GetPhoto(original.ID); p.Latitude != nil || !p.DateTaken.Equal(original.DateTaken) || len(p.Keywords) != 0 {
+		t.Errorf("Expected the keeper to be unchanged, got %+v", p)
+	}
+	if _, err := manager.GetPhoto(shared.ID); err != nil {
+		t.Errorf("Expected the copy to stay: %v", err)
+	}
+	os.Remove(blocker)
+
+	keeper, err := manager.ResolveDuplicateGroup(groups[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keeper.ID != original.ID {
+		t.Errorf("Expected the full-size original to be kept, got photo %d", keeper.ID)
+	}
+	if keeper.Latitude == nil || *keeper.Latitude != 48.85 || keeper.Longitude == nil || *keeper.Longitude != 2.35 {
+		t.Errorf("Expected the GPS position to be merged, got %v, %v", keeper.Latitude, keeper.Longitude)
+	}
+	if !keeper.DateTaken.Equal(earlier) {
+		t.Errorf("Expected the earlier date %v, got %v", earlier, keeper.DateTaken)
+	}
+	if len(keeper.Keywords) != 1 || keeper.Keywords[0] != "paris" {
+		t.Errorf("Expected the keyword to be merged, got %v", keeper.Keywords)
+	}
+	if _, err := os.Stat(filepath.Join(manager.LibraryPath, keeper.Filename)); err != nil {
+		t.Errorf("Expected the keeper's file to exist: %v", err)
+	}
+
+	// The copy is gone from the library but kept in the trash
+	if _, err := manager.GetPhoto(shared.ID); err == nil {
+		t.Error("Expected the copy to be removed from the library")
+	}
+	if _, err := os.Stat(filepath.Join(manager.LibraryPath, shared.Filename)); !os.IsNotExist(err) {
+		t.Errorf("Expected the copy's file to be moved, got %v", err)
+	}
+	var trashPath string
+	if err := manager.DB.QueryRow("SELECT trash_path FROM trash WHERE photo_id = ?", shared.ID).Scan(&trashPath); err != nil {
+		t.Fatalf("Expected the copy in the trash: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(manager.LibraryPath, trashPath)); err != nil {
+		t.Errorf("Expected the copy's file in the trash: %v", err)
+	}
+	if groups, _ := manager.ListDuplicateGroups(); len(groups) != 0 {
+		t.Errorf("Expected the group to be resolved, got %+v", groups)
+	}
+	if _, err := manager.ResolveDuplicateGroup(groups[0].ID); err == nil {
+		t.Error("Expected an error for a resolved group")
+	}
+
+	// Importing the copy again does not bring it back
+	result, err := manager.ImportPhoto(copyPath)
+	var dup *DuplicateError
+	if !errors.As(err, &dup) || !dup.Trashed || dup.ExistingID != keeper.ID || result.DuplicateOf != keeper.ID {
+		t.Errorf("Expected a duplicate of the keeper, got %+v, %v", result, err)
+	}
+
+	// Undo brings the copy back from the trash and unmerges the keeper
+	if batch, err := manager.Undo(); err != nil || batch == nil || batch.Description != "Merge duplicates" {
+		t.Fatalf("Undo failed: %+v, %v", batch, err)
+	}
+	restored, err := manager.GetPhoto(shared.ID)
+	if err != nil {
+		t.Fatalf("Expected the copy back in the library: %v", err)
+	}
+	if restored.Filename != shared.Filename || len(restored.Keywords) != 1 || restored.Latitude == nil {
+		t.Errorf("Expected the copy as it was, got %+v", restored)
+	}
+	if _, err := os.Stat(filepath.Join(manager.LibraryPath, shared.Filename)); err != nil {
+		t.Errorf("Expected the copy's file back: %v", err)
+	}
+	if p, _ := manager.GetPhoto(original.ID); p.Latitude != nil || !p.DateTaken.Equal(original.DateTaken) || len(p.Keywords) != 0 {
+		t.Errorf("Expected the keeper's own metadata back, got %+v", p)
+	}
+	if groups, _ := manager.ListDuplicateGroups(); len(groups) != 1 || len(groups[0].Photos) != 2 {
+		t.Errorf("Expected the duplicate group back, got %+v", groups)
+	}
+	var trashed int
+	manager.DB.QueryRow("SELECT COUNT(*) FROM trash").Scan(&trashed)
+	if trashed != 0 {
+		t.Errorf("Expected the trash to be empty, found %d", trashed)
+	}
+
+	// Redo merges and trashes again
+	if _, err := manager.Redo(); err != nil {
+		t.Fatalf("Redo failed: %v", err)
+	}
+	if _, err := manager.GetPhoto(shared.ID); err == nil {
+		t.Error("Expected redo to trash the copy again")
+	}
+	if p, _ := manager.GetPhoto(original.ID); len(p.Keywords) != 1 || p.Latitude == nil {
+		t.Errorf("Expected redo to merge again, got %+v", p)
+	}
+}
+
+func TestDuplicateHits(t *testing.T) {
+	manager := newTestManager(t)
+	srcDir := t.TempDir()
+	copyTestPhoto(t, "RIMG0018.JPG", srcDir)
+	copyTestPhoto(t, "RIMG0020.JPG", srcDir)
+	os.WriteFile(filepath.Join(srcDir, "again.jpg"), mustRead(t, filepath.Join(srcDir, "RIMG0018.JPG")), 0644)
+
+	// Within one import, the hit may be on a file that is still in flight
+	progress, err := manager.ImportFolder(context.Background(), srcDir, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Duplicates != 1 {
+		t.Fatalf("Expected one duplicate, got %+v", progress)
+	}
+	// A single-file import of a library photo
+	if _, err := manager.ImportPhoto(filepath.Join(srcDir, "RIMG0020.JPG")); err == nil {
+		t.Fatal("Expected a duplicate error")
+	}
+
+	hits, err := manager.ListDuplicateHits(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 2 {
+		t.Fatalf("Expected 2 hits, got %+v", hits)
+	}
+	single, inFolder := hits[0], hits[1]
+	if single.SessionID != nil || single.SourcePath != filepath.Join(srcDir, "RIMG0020.JPG") || single.PhotoID == nil {
+		t.Errorf("Unexpected single-file hit %+v", single)
+	}
+	if inFolder.SessionID == nil || *inFolder.SessionID != progress.SessionID || inFolder.PhotoID == nil {
+		t.Errorf("Unexpected folder hit %+v", inFolder)
+	}
+	if p, err := manager.GetPhoto(*inFolder.PhotoID); err != nil || p.Hash != inFolder.Hash {
+		t.Errorf("Expected the hit to point to the photo with its hash, got %v, %v", p, err)
+	}
+
+	if hits, _ := manager.ListDuplicateHits(progress.SessionID); len(hits) != 1 {
+		t.Errorf("Expected 1 hit in the session, got %d", len(hits))
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
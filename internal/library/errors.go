@@ -0,0 +1,41 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by imports. They are wrapped with the details of the
+// failure; use errors.Is to classify them, and errors.As with a
+// *DuplicateError to find the photo a duplicate matched.
+var (
+	ErrDuplicate         = errors.New("duplicate photo detected")
+	ErrUnsupportedFormat = errors.New("unsupported file format")
+	ErrMetadata          = errors.New("failed to read metadata")
+	ErrCopy              = errors.New("failed to copy file")
+)
+
+// DuplicateError reports a file whose content is already in the library.
+type DuplicateError struct {
+	// ExistingID is the library photo with the same content. It is zero if
+	// that file was still being imported when the duplicate was detected.
+	// For content in the trash it is the photo that replaced it, if any.
+	ExistingID int64
+	Hash       string
+	Trashed    bool // the content was moved to the trash, e.g. as a duplicate
+}
+
+func (e *DuplicateError) Error() string {
+	if e.Trashed {
+		return fmt.Sprintf("%v (hash: %s, in trash)", ErrDuplicate, e.Hash)
+	}
+	if e.ExistingID == 0 {
+		return fmt.Sprintf("%v (hash: %s, import in progress)", ErrDuplicate, e.Hash)
+	}
+	return fmt.Sprintf("%v (hash: %s, photo %d)", ErrDuplicate, e.Hash, e.ExistingID)
+}
+
+// Is makes errors.Is(err, ErrDuplicate) match.
+func (e *DuplicateError) Is(target error) bool {
+	return target == ErrDuplicate
+}
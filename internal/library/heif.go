@@ -0,0 +1,88 @@
+package library
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"path/filepath"
+	"strings"
+
+	"photoo/internal/exif"
+
+	"github.com/disintegration/imaging"
+)
+
+// HEIFDecoder decodes the coded images of HEIF files. Neither the standard
+// library nor imaging can decode HEVC or AV1 and photoo bundles no decoder
+// that does without CGO, so HEIC thumbnails depend on one being set on the
+// ThumbnailHandler.
+type HEIFDecoder interface {
+	// DecodeHEIF decodes a single coded image, such as an "hvc1" item or a
+	// tile of a grid. Grids are put together by the caller.
+	DecodeHEIF(img *exif.HEIFImage) (image.Image, error)
+}
+
+// isHEIFFile reports whether path has a HEIF extension.
+func isHEIFFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".heic")
+}
+
+// openHEIFPreview decodes the thumbnail (or primary image) of a HEIF file
+// with decoder. If that fails, the JPEG thumbnail some cameras store in the
+// EXIF block is used instead.
+func openHEIFPreview(path string, decoder HEIFDecoder) (image.Image, error) {
+	preview, err := exif.HEIFPreview(path)
+	if err != nil {
+		return nil, err
+	}
+	if preview == nil {
+		return nil, fmt.Errorf("not a HEIF file")
+	}
+
+	src, err := decodeHEIFImage(preview, decoder)
+	if err != nil {
+		if thumbnail, _ := exif.HEIFExifThumbnail(path); thumbnail != nil {
+			if src, err := imaging.Decode(bytes.NewReader(thumbnail)); err == nil {
+				return src, nil
+			}
+		}
+		return nil, err
+	}
+
+	switch preview.Rotation {
+	case 1:
+		src = imaging.Rotate90(src)
+	case 2:
+		src = imaging.Rotate180(src)
+	case 3:
+		src = imaging.Rotate270(src)
+	}
+	return src, nil
+}
+
+// decodeHEIFImage decodes img, assembling grids from their tiles. JPEG
+// items are decoded without a HEIFDecoder.
+func decodeHEIFImage(img *exif.HEIFImage, decoder HEIFDecoder) (image.Image, error) {
+	switch {
+	case img.Type == "grid":
+		canvas := image.NewRGBA(image.Rect(0, 0, img.Width, img.Height))
+		for i, t := range img.Tiles {
+			tile, err := decodeHEIFImage(t, decoder)
+			if err != nil {
+				return nil, err
+			}
+			// Tiles share one size; the canvas crops those overhanging the
+			// right and bottom edges
+			size := tile.Bounds().Size()
+			at := image.Pt(i%img.Columns*size.X, i/img.Columns*size.Y)
+			draw.Draw(canvas, image.Rectangle{at, at.Add(size)}, tile, tile.Bounds().Min, draw.Src)
+		}
+		return canvas, nil
+	case img.Type == "jpeg":
+		return imaging.Decode(bytes.NewReader(img.Data))
+	case decoder == nil:
+		return nil, fmt.Errorf("no decoder for %q images", img.Type)
+	}
+	return decoder.DecodeHEIF(img)
+}
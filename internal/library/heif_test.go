@@ -0,0 +1,98 @@
+package library
+
+import (
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"photoo/internal/exif"
+
+	"github.com/disintegration/imaging"
+)
+
+// solidDecoder "decodes" every coded image into a grey square of its size.
+type solidDecoder struct {
+	calls []*exif.HEIFImage
+}
+
+func (d *solidDecoder) DecodeHEIF(img *exif.HEIFImage) (image.Image, error) {
+	d.calls = append(d.calls, img)
+	return imaging.New(img.Width, img.Height, color.Gray{0x80}), nil
+}
+
+func TestHEICThumbnail(t *testing.T) {
+	libDir := t.TempDir()
+	data, err := os.ReadFile("../../test_data/source_icloud/IMG_8299.HEIC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(libDir, "IMG_8299.HEIC"), data, 0644)
+	handler := NewThumbnailHandler(libDir)
+
+	// Without a decoder: a placeholder explaining why, not an error
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/thumbnail/IMG_8299.HEIC", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Thumbnail-Source") != "placeholder" || rec.Header().Get("X-Thumbnail-Error") == "" {
+		t.Errorf("Expected a placeholder with an error header, got %d %v", rec.Code, rec.Header())
+	}
+	if _, err := os.Stat(thumbnailCachePath(libDir, "IMG_8299.HEIC")); err == nil {
+		t.Error("Expected the placeholder not to be cached")
+	}
+
+	// With one: the HEVC thumbnail is decoded
+	decoder := &solidDecoder{}
+	handler.HEIFDecoder = decoder
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/thumbnail/IMG_8299.HEIC", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Thumbnail-Error") != "" || rec.Header().Get("X-Thumbnail-Cache") != "MISS" {
+		t.Errorf("Expected a decoded thumbnail, got %d %v", rec.Code, rec.Header())
+	}
+	if len(decoder.calls) != 1 || decoder.calls[0].Type != "hvc1" || len(decoder.calls[0].Config) == 0 {
+		t.Errorf("Expected the HEVC thumbnail item to be decoded, got %d calls", len(decoder.calls))
+	}
+
+	// The rotation of the item is applied
+	src, err := openHEIFPreview(filepath.Join(libDir, "IMG_8299.HEIC"), decoder)
+	if err != nil || src.Bounds().Dx() != 240 || src.Bounds().Dy() != 320 {
+		t.Errorf("Expected a rotated 240x320 preview, got %v, %v", src.Bounds(), err)
+	}
+}
+
+func TestDecodeHEIFGrid(t *testing.T) {
+	tile := func(v uint8) *exif.HEIFImage {
+		return &exif.HEIFImage{Type: "hvc1", Width: 16, Height: 16, Data: []byte{v}}
+	}
+	grid := &exif.HEIFImage{Type: "grid", Width: 30, Height: 20, Rows: 2, Columns: 2,
+		Tiles: []*exif.HEIFImage{tile(10), tile(20), tile(30), tile(40)}}
+
+	src, err := decodeHEIFImage(grid, tileDecoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Bounds() != image.Rect(0, 0, 30, 20) {
+		t.Errorf("Expected the grid cropped to 30x20, got %v", src.Bounds())
+	}
+	for _, c := range []struct {
+		x, y int
+		want uint8
+	}{{0, 0, 10}, {20, 0, 20}, {0, 18, 30}, {29, 19, 40}} {
+		if r, _, _, _ := src.At(c.x, c.y).RGBA(); uint8(r>>8) != c.want {
+			t.Errorf("Expected tile %d at %d,%d, got %d", c.want, c.x, c.y, r>>8)
+		}
+	}
+
+	if _, err := decodeHEIFImage(tile(1), nil); err == nil {
+		t.Error("Expected an error without a decoder")
+	}
+}
+
+// tileDecoder fills each tile with the grey level stored as its data.
+type tileDecoder struct{}
+
+func (tileDecoder) DecodeHEIF(img *exif.HEIFImage) (image.Image, error) {
+	return imaging.New(img.Width, img.Height, color.Gray{img.Data[0]}), nil
+}
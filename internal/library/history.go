@@ -0,0 +1,504 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"photoo/internal/exif"
+	"photoo/internal/models"
+)
+
+// editableFields are the photo columns that may be changed through
+// UpdateMetadata. Column names cannot be bound as SQL parameters, so every
+// field is checked against this list before it is used in a query.
+var editableFields = map[string]bool{
+	"date_taken":   true,
+	"camera_model": true,
+	"latitude":     true,
+	"longitude":    true,
+}
+
+// recordedFields are history-tracked fields that library operations change
+// rather than edits: the keywords merged into a photo and the trashing of a
+// duplicate, whose value is the ID of the photo that replaced it. Together
+// with the file names refile records, they are replayed by undo and redo.
+var recordedFields = map[string]bool{
+	"keywords": true,
+	"trashed":  true,
+}
+
+// MetadataChange is a single field edit.
+type MetadataChange struct {
+	PhotoID int64
+	Field   string
+	Value   interface{}
+}
+
+// UpdateMetadata changes one field of a photo as its own undoable batch.
+func (m *Manager) UpdateMetadata(photoID int64, field string, newValue interface{}) error {
+	_, err := m.ApplyMetadataChanges(fmt.Sprintf("Change %s", field), []MetadataChange{
+		{PhotoID: photoID, Field: field, Value: newValue},
+	})
+	return err
+}
+
+// ApplyMetadataChanges applies all changes in one transaction and records
+// them in metadata_history as a single batch, so they are undone together.
+// Starting a new batch discards everything that could have been redone.
+func (m *Manager) ApplyMetadataChanges(description string, changes []MetadataChange) (int64, error) {
+	for _, c := range changes {
+		if !editableFields[c.Field] {
+			return 0, fmt.Errorf("field %q cannot be edited", c.Field)
+		}
+	}
+	return m.commitMetadataChanges(description, changes)
+}
+
+// commitMetadataChanges is ApplyMetadataChanges for any history-tracked
+// field, including the file names refile records.
+func (m *Manager) commitMetadataChanges(description string, changes []MetadataChange) (int64, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+	moves := &fileMoves{m: m}
+	committed := false
+	defer func() { moves.finish(committed) }()
+
+	batchID, err := m.applyMetadataChanges(tx, moves, description, changes)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit metadata changes: %w", err)
+	}
+	committed = true
+	m.writeBack(changes)
+	return batchID, nil
+}
+
+// applyMetadataChanges is commitMetadataChanges within tx. The caller
+// commits, and then writes the changes back to the files with writeBack.
+func (m *Manager) applyMetadataChanges(tx *sql.Tx, moves *fileMoves, description string, changes []MetadataChange) (int64, error) {
+	for _, c := range changes {
+		if !editableFields[c.Field] && !recordedFields[c.Field] && !isFileField(c.Field) {
+			return 0, fmt.Errorf("field %q cannot be edited", c.Field)
+		}
+	}
+
+	batchID, err := newEditBatch(tx, description)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, c := range changes {
+		// 1. Get current value
+		oldValue, err := currentValue(tx, c.PhotoID, c.Field)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get old value: %w", err)
+		}
+
+		// 2. Log in metadata_history
+		if err := logHistory(tx, batchID, c.PhotoID, c.Field, oldValue, c.Value); err != nil {
+			return 0, err
+		}
+
+		// 3. Update DB
+		if err := m.setField(tx, moves, c.PhotoID, c.Field, c.Value); err != nil {
+			return 0, err
+		}
+
+		// 4. Keep the date-based layout: a new date means a new folder and name
+		if date, ok := c.Value.(time.Time); ok && c.Field == "date_taken" {
+			if err := m.refile(tx, moves, batchID, c.PhotoID, date); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return batchID, nil
+}
+
+// newEditBatch starts a batch of history entries. Starting a new batch
+// discards everything that could have been redone.
+func newEditBatch(tx *sql.Tx, description string) (int64, error) {
+	if _, err := tx.Exec("UPDATE edit_batches SET state = ? WHERE state = ?", models.BatchDiscarded, models.BatchUndone); err != nil {
+		return 0, fmt.Errorf("failed to discard redo history: %w", err)
+	}
+	res, err := tx.Exec("INSERT INTO edit_batches (description, state) VALUES (?, ?)", description, models.BatchApplied)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create edit batch: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+// Undo reverts the most recent applied batch. It returns nil if there is
+// nothing to undo.
+func (m *Manager) Undo() (*models.EditBatch, error) {
+	return m.stepHistory(models.BatchApplied, models.BatchUndone, "DESC", "old_value")
+}
+
+// Redo re-applies the most recently undone batch. It returns nil if there is
+// nothing to redo.
+func (m *Manager) Redo() (*models.EditBatch, error) {
+	return m.stepHistory(models.BatchUndone, models.BatchApplied, "ASC", "new_value")
+}
+
+// stepHistory moves one batch from state `from` to state `to`, writing the
+// given history column back to the photos. Undo walks the batches newest
+// first; redo oldest first, which is the most recently undone one since
+// starting a new batch discards all undone ones.
+func (m *Manager) stepHistory(from, to, order, valueColumn string) (*models.EditBatch, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+	moves := &fileMoves{m: m}
+	committed := false
+	defer func() { moves.finish(committed) }()
+
+	var batch models.EditBatch
+	err = tx.QueryRow(
+		"SELECT id, description, created_at FROM edit_batches WHERE state = ? ORDER BY id "+order+" LIMIT 1", from,
+	).Scan(&batch.ID, &batch.Description, &batch.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load edit batch: %w", err)
+	}
+
+	// Changes are replayed in reverse for undo, in order for redo
+	rows, err := tx.Query(
+		"SELECT photo_id, field_name, "+valueColumn+" FROM metadata_history WHERE batch_id = ? ORDER BY id "+order, batch.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load batch changes: %w", err)
+	}
+	var changes []MetadataChange
+	for rows.Next() {
+		var c MetadataChange
+		var raw sql.NullString
+		if err := rows.Scan(&c.PhotoID, &c.Field, &raw); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if c.Value, err = parseHistoryValue(c.Field, raw); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	rows.Close()
+
+	for _, c := range changes {
+		if err := m.setField(tx, moves, c.PhotoID, c.Field, c.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE edit_batches SET state = ? WHERE id = ?", to, batch.ID); err != nil {
+		return nil, fmt.Errorf("failed to update edit batch: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit: %w", err)
+	}
+	committed = true
+	m.writeBack(changes)
+	batch.State = to
+	return &batch, nil
+}
+
+// writeBack records the current date and location of the photos touched by
+// changes in their files, so that other tools see the edits. With sidecars
+// enabled, each touched photo's XMP sidecar is rewritten with all of its
+// metadata as well. The stored hash keeps identifying the imported original.
+// The database is authoritative and the edit is already saved, so failures
+// are only logged.
+func (m *Manager) writeBack(changes []MetadataChange) {
+	type fields struct{ date, location bool }
+	touched := make(map[int64]*fields)
+	var order []int64
+	for _, c := range changes {
+		if c.Field == "trashed" {
+			// Trashed photos have no files to write to
+			continue
+		}
+		f, ok := touched[c.PhotoID]
+		if !ok {
+			f = &fields{}
+			touched[c.PhotoID] = f
+			order = append(order, c.PhotoID)
+		}
+		switch c.Field {
+		case "date_taken":
+			f.date = true
+		case "latitude", "longitude":
+			f.location = true
+		}
+	}
+	writeSidecars := m.WritesSidecars()
+
+	for _, id := range order {
+		f := touched[id]
+		if !f.date && !f.location && !writeSidecars {
+			continue
+		}
+		photo, err := m.GetPhoto(id)
+		if err != nil {
+			fmt.Printf("[BACKEND] Failed to load photo %d for metadata write-back: %v\n", id, err)
+			continue
+		}
+		// The raw file of a RAW+JPEG pair gets the same changes, as a sidecar
+		files := []string{photo.LibraryPath}
+		if photo.AlternateFilename != "" {
+			files = append(files, filepath.Join(m.LibraryPath, photo.AlternateFilename))
+		}
+		c := exif.Changes{Latitude: photo.Latitude, Longitude: photo.Longitude}
+		if !photo.DateTaken.IsZero() {
+			c.DateTaken = &photo.DateTaken
+			c.UTCOffset = photo.UTCOffset
+		}
+
+		if f.date || f.location {
+			fileChanges := c
+			if !f.date {
+				fileChanges.DateTaken, fileChanges.UTCOffset = nil, nil
+			}
+			fileChanges.SetLocation = f.location
+			if fileChanges.DateTaken != nil || fileChanges.SetLocation {
+				for _, path := range files {
+					if err := exif.WriteMetadata(path, fileChanges); err != nil {
+						fmt.Printf("[BACKEND] Failed to write metadata to %s: %v\n", path, err)
+					}
+				}
+			}
+		}
+
+		if writeSidecars {
+			c.SetLocation = true
+			c.Rating = &photo.Rating
+			c.Title = &photo.Title
+			c.Description = &photo.Description
+			c.Keywords = append([]string{}, photo.Keywords...)
+			for _, path := range files {
+				if err := exif.WriteSidecar(path, c); err != nil {
+					fmt.Printf("[BACKEND] Failed to write XMP sidecar of %s: %v\n", path, err)
+				}
+			}
+		}
+	}
+}
+
+// RevertMetadata restores the value a field had before the given history
+// entry; for a file name recorded when a date change moved the photo, that
+// moves it back. The revert is itself recorded as a new, undoable batch.
+func (m *Manager) RevertMetadata(historyID int64) error {
+	var c MetadataChange
+	var oldValue sql.NullString
+	err := m.DB.QueryRow("SELECT photo_id, field_name, old_value FROM metadata_history WHERE id = ?", historyID).
+		Scan(&c.PhotoID, &c.Field, &oldValue)
+	if err != nil {
+		return fmt.Errorf("failed to load history entry %d: %w", historyID, err)
+	}
+	if c.Value, err = parseHistoryValue(c.Field, oldValue); err != nil {
+		return err
+	}
+
+	_, err = m.commitMetadataChanges(fmt.Sprintf("Revert %s", c.Field), []MetadataChange{c})
+	return err
+}
+
+// GetPhotoHistory returns all recorded changes of a photo, newest first.
+func (m *Manager) GetPhotoHistory(photoID int64) ([]models.MetadataHistory, error) {
+	rows, err := m.DB.Query(`SELECT h.id, h.photo_id, h.field_name, h.old_value, h.new_value, h.changed_at,
+		COALESCE(h.batch_id, 0), COALESCE(b.state, ?)
+		FROM metadata_history h LEFT JOIN edit_batches b ON b.id = h.batch_id
+		WHERE h.photo_id = ? ORDER BY h.id DESC`, models.BatchApplied, photoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load history: %w", err)
+	}
+	defer rows.Close()
+
+	var history []models.MetadataHistory
+	for rows.Next() {
+		var h models.MetadataHistory
+		var oldValue, newValue sql.NullString
+		if err := rows.Scan(&h.ID, &h.PhotoID, &h.FieldName, &oldValue, &newValue, &h.ChangedAt, &h.BatchID, &h.State); err != nil {
+			return nil, err
+		}
+		h.OldValue = oldValue.String
+		h.NewValue = newValue.String
+		history = append(history, h)
+	}
+	return history, rows.Err()
+}
+
+// isFileField reports whether field names a file in the library. Such
+// fields are recorded by refile and cannot be edited directly.
+func isFileField(field string) bool {
+	return field == "filename" || slices.Contains(companionColumns, field)
+}
+
+// logHistory records a change of field in metadata_history as part of
+// batchID.
+func logHistory(tx *sql.Tx, batchID, photoID int64, field string, oldValue, newValue interface{}) error {
+	_, err := tx.Exec(
+		"INSERT INTO metadata_history (photo_id, field_name, old_value, new_value, batch_id) VALUES (?, ?, ?, ?, ?)",
+		photoID, field, formatHistoryValue(oldValue), formatHistoryValue(newValue), batchID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to log metadata history: %w", err)
+	}
+	return nil
+}
+
+// currentValue returns the value of a history-tracked field of a photo.
+func currentValue(tx *sql.Tx, photoID int64, field string) (interface{}, error) {
+	switch field {
+	case "keywords":
+		var keywords sql.NullString
+		err := tx.QueryRow("SELECT GROUP_CONCAT(keyword, char(31)) FROM photo_keywords WHERE photo_id = ?", photoID).Scan(&keywords)
+		return splitList(keywords), err
+	case "trashed":
+		// Only photos in the library are trashed
+		return nil, nil
+	}
+	var value interface{}
+	err := tx.QueryRow(fmt.Sprintf("SELECT %s FROM photos WHERE id = ?", field), photoID).Scan(&value)
+	return value, err
+}
+
+// setField writes one history-tracked field of a photo. Besides the editable
+// fields this includes the recordedFields, "filename" and the
+// companionColumns, which move files.
+func (m *Manager) setField(tx *sql.Tx, moves *fileMoves, photoID int64, field string, value interface{}) error {
+	switch field {
+	case "keywords":
+		keywords, _ := value.([]string)
+		if err := setKeywords(tx, photoID, keywords); err != nil {
+			return fmt.Errorf("failed to update keywords: %w", err)
+		}
+		return nil
+	case "trashed":
+		if value == nil {
+			return m.restoreFromTrash(tx, moves, photoID)
+		}
+		replacedBy, ok := value.(int64)
+		if !ok {
+			return fmt.Errorf("invalid photo ID %v", value)
+		}
+		return m.trashPhoto(tx, moves, photoID, replacedBy)
+	}
+	if isFileField(field) {
+		// Recorded by refile; replayed by undo and redo
+		filename, ok := value.(string)
+		if !ok || filename == "" {
+			return fmt.Errorf("invalid filename %v", value)
+		}
+		if field == "filename" {
+			return m.setFilename(tx, moves, photoID, filename)
+		}
+		var current string
+		if err := tx.QueryRow("SELECT COALESCE("+field+", '') FROM photos WHERE id = ?", photoID).Scan(&current); err != nil {
+			return fmt.Errorf("failed to load photo %d: %w", photoID, err)
+		}
+		return m.setCompanion(tx, moves, photoID, field, current, filename)
+	}
+	if !editableFields[field] {
+		return fmt.Errorf("field %q cannot be edited", field)
+	}
+	updateQuery := fmt.Sprintf("UPDATE photos SET %s = ? WHERE id = ?", field)
+	args := []interface{}{value, photoID}
+	if t, ok := value.(time.Time); ok && field == "date_taken" {
+		// The edit sets the wall-clock time in the zone the photo was taken
+		// in; the UTC instant follows it. A photo without a known zone keeps
+		// none, rather than taking the zone of this machine.
+		var offset sql.NullInt64
+		if err := tx.QueryRow("SELECT utc_offset FROM photos WHERE id = ?", photoID).Scan(&offset); err != nil {
+			return fmt.Errorf("failed to load photo %d: %w", photoID, err)
+		}
+		loc := time.Local
+		if offset.Valid {
+			loc = time.FixedZone("", int(offset.Int64))
+		}
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+		updateQuery = "UPDATE photos SET date_taken = ?, date_taken_utc = ? WHERE id = ?"
+		args = []interface{}{t, t.UTC(), photoID}
+	}
+	if _, err := tx.Exec(updateQuery, args...); err != nil {
+		return fmt.Errorf("failed to update database: %w", err)
+	}
+	return nil
+}
+
+// formatHistoryValue converts a column value into its metadata_history text
+// form. Times are stored as RFC 3339 so they can be parsed back exactly.
+func formatHistoryValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case *float64:
+		if val == nil {
+			return nil
+		}
+		return strconv.FormatFloat(*val, 'f', -1, 64)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case []byte:
+		return string(val)
+	case []string:
+		list, _ := json.Marshal(val)
+		return string(list)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// parseHistoryValue converts a metadata_history text value back into the
+// type of its column.
+func parseHistoryValue(field string, raw sql.NullString) (interface{}, error) {
+	if !raw.Valid {
+		return nil, nil
+	}
+	switch field {
+	case "date_taken":
+		// Entries written before edit batches used time.Time.String()
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999 -0700 MST"} {
+			if t, err := time.Parse(layout, raw.String); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid date in history: %q", raw.String)
+	case "keywords":
+		var keywords []string
+		if err := json.Unmarshal([]byte(raw.String), &keywords); err != nil {
+			return nil, fmt.Errorf("invalid keywords in history: %q", raw.String)
+		}
+		return keywords, nil
+	case "trashed":
+		id, err := strconv.ParseInt(raw.String, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid photo ID in history: %q", raw.String)
+		}
+		return id, nil
+	case "latitude", "longitude":
+		if raw.String == "" {
+			return nil, nil
+		}
+		f, err := strconv.ParseFloat(raw.String, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in history: %q", field, raw.String)
+		}
+		return f, nil
+	default:
+		return raw.String, nil
+	}
+}
@@ -0,0 +1,425 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"photoo/internal/exif"
+	"photoo/internal/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func insertTestPhoto(t *testing.T, m *Manager, name string, dateTaken time.Time) int64 {
+	t.Helper()
+	libraryPath := filepath.Join(m.LibraryPath, name)
+	os.MkdirAll(filepath.Dir(libraryPath), 0755)
+	if err := os.WriteFile(libraryPath, []byte("photo-"+name), 0644); err != nil {
+		t.Fatal(err)
+	}
+	res, err := m.DB.Exec(
+		"INSERT INTO photos (original_path, library_path, filename, hash, date_taken, camera_model) VALUES (?, ?, ?, ?, ?, ?)",
+		"orig/"+name, libraryPath, name, "hash-"+name, dateTaken, "Camera",
+	)
+	if err != nil {
+		t.Fatalf("Failed to insert photo %s: %v", name, err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+func photoDate(t *testing.T, m *Manager, id int64) time.Time {
+	t.Helper()
+	var d time.Time
+	if err := m.DB.QueryRow("SELECT date_taken FROM photos WHERE id = ?", id).Scan(&d); err != nil {
+		t.Fatalf("Failed to read date of photo %d: %v", id, err)
+	}
+	return d
+}
+
+func TestUndoRedoBatch(t *testing.T) {
+	manager := newTestManager(t)
+
+	original := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	corrected := time.Date(2021, 6, 15, 12, 30, 0, 0, time.UTC)
+	a := insertTestPhoto(t, manager, "a.jpg", original)
+	b := insertTestPhoto(t, manager, "b.jpg", original)
+
+	// Nothing to undo yet
+	if batch, err := manager.Undo(); err != nil || batch != nil {
+		t.Fatalf("Expected nothing to undo, got %+v, %v", batch, err)
+	}
+
+	_, err := manager.ApplyMetadataChanges("Fix dates", []MetadataChange{
+		{PhotoID: a, Field: "date_taken", Value: corrected},
+		{PhotoID: b, Field: "date_taken", Value: corrected},
+	})
+	if err != nil {
+		t.Fatalf("ApplyMetadataChanges failed: %v", err)
+	}
+	if !photoDate(t, manager, a).Equal(corrected) || !photoDate(t, manager, b).Equal(corrected) {
+		t.Fatal("Dates were not updated")
+	}
+
+	// One undo reverts both photos
+	batch, err := manager.Undo()
+	if err != nil || batch == nil || batch.Description != "Fix dates" {
+		t.Fatalf("Undo failed: %+v, %v", batch, err)
+	}
+	if !photoDate(t, manager, a).Equal(original) || !photoDate(t, manager, b).Equal(original) {
+		t.Error("Undo did not restore both dates")
+	}
+
+	// The date change and the resulting move are undone together
+	history, err := manager.GetPhotoHistory(a)
+	if err != nil || len(history) != 2 || history[0].State != models.BatchUndone || history[1].State != models.BatchUndone {
+		t.Errorf("Unexpected history after undo: %+v, %v", history, err)
+	}
+
+	if batch, err := manager.Redo(); err != nil || batch == nil {
+		t.Fatalf("Redo failed: %+v, %v", batch, err)
+	}
+	if !photoDate(t, manager, a).Equal(corrected) || !photoDate(t, manager, b).Equal(corrected) {
+		t.Error("Redo did not re-apply both dates")
+	}
+
+	// A new edit after an undo discards the redo stack
+	manager.Undo()
+	if err := manager.UpdateMetadata(a, "camera_model", "Other"); err != nil {
+		t.Fatalf("UpdateMetadata failed: %v", err)
+	}
+	if batch, _ := manager.Redo(); batch != nil {
+		t.Errorf("Expected nothing to redo after a new edit, got %+v", batch)
+	}
+}
+
+func TestDateChangeRefilesPhoto(t *testing.T) {
+	manager := newTestManager(t)
+
+	original := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := insertTestPhoto(t, manager, "2019/01/01/2019-01-01_00-00-00.jpg", original)
+	b := insertTestPhoto(t, manager, "2019/01/01/2019-01-01_00-00-00_1.jpg", original)
+	thumb := thumbnailCachePath(manager.LibraryPath, "2019/01/01/2019-01-01_00-00-00.jpg")
+	os.MkdirAll(filepath.Dir(thumb), 0755)
+	os.WriteFile(thumb, []byte("thumb"), 0644)
+
+	location := func(id int64) (string, string) {
+		var filename, libraryPath string
+		manager.DB.QueryRow("SELECT filename, library_path FROM photos WHERE id = ?", id).Scan(&filename, &libraryPath)
+		return filepath.ToSlash(filename), libraryPath
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	// Both photos move to the new date; the second one gets a counter
+	corrected := time.Date(2021, 6, 15, 12, 30, 0, 0, time.UTC)
+	if err := manager.UpdateMetadata(a, "date_taken", corrected); err != nil {
+		t.Fatalf("UpdateMetadata failed: %v", err)
+	}
+	if err := manager.UpdateMetadata(b, "date_taken", corrected); err != nil {
+		t.Fatalf("UpdateMetadata failed: %v", err)
+	}
+	nameA, pathA := location(a)
+	nameB, pathB := location(b)
+	if nameA != "2021/06/15/2021-06-15_12-30-00.jpg" || nameB != "2021/06/15/2021-06-15_12-30-00_1.jpg" {
+		t.Fatalf("Unexpected names after date change: %s, %s", nameA, nameB)
+	}
+	if !exists(pathA) || !exists(pathB) {
+		t.Error("Expected files at their new locations")
+	}
+	if exists(filepath.Join(manager.LibraryPath, "2019")) {
+		t.Error("Expected the emptied folders to be removed")
+	}
+	if exists(thumb) || !exists(thumbnailCachePath(manager.LibraryPath, nameA)) {
+		t.Error("Expected the cached thumbnail to move with the photo")
+	}
+
+	// Setting the date again within the same second keeps the name
+	if err := manager.UpdateMetadata(b, "date_taken", corrected); err != nil {
+		t.Fatalf("UpdateMetadata failed: %v", err)
+	}
+	if name, _ := location(b); name != nameB {
+		t.Errorf("Expected %s to stay in place, got %s", nameB, name)
+	}
+
+	// Undo restores the old location
+	manager.Undo()
+	manager.Undo()
+	if _, err := manager.Undo(); err != nil {
+		t.Fatalf("Undo failed: %v", err)
+	}
+	name, path := location(a)
+	if name != "2019/01/01/2019-01-01_00-00-00.jpg" || !exists(path) || exists(pathA) {
+		t.Errorf("Expected photo back at its old location, got %s", name)
+	}
+	if !exists(thumb) {
+		t.Error("Expected the cached thumbnail to move back")
+	}
+
+	if _, err := manager.Redo(); err != nil {
+		t.Fatalf("Redo failed: %v", err)
+	}
+	if name, _ := location(a); name != nameA {
+		t.Errorf("Expected redo to move the photo again, got %s", name)
+	}
+}
+
+func TestDateChangeMovesCompanions(t *testing.T) {
+	manager := newTestManager(t)
+
+	original := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	id := insertTestPhoto(t, manager, "2019/01/01/2019-01-01_00-00-00.jpg", original)
+	// A video imported on its own keeps the name it was filed under
+	for _, name := range []string{"2019/01/01/2019-01-01_00-00-00_1.mov", "2019/01/01/2019-01-01_00-00-00.cr2"} {
+		os.WriteFile(filepath.Join(manager.LibraryPath, name), []byte(name), 0644)
+	}
+	manager.DB.Exec("UPDATE photos SET motion_filename = ?, alternate_filename = ? WHERE id = ?",
+		"2019/01/01/2019-01-01_00-00-00_1.mov", "2019/01/01/2019-01-01_00-00-00.cr2", id)
+
+	files := func() []string {
+		var filename, motion, alternate string
+		manager.DB.QueryRow("SELECT filename, motion_filename, alternate_filename FROM photos WHERE id = ?", id).Scan(&filename, &motion, &alternate)
+		for _, f := range []string{filename, motion, alternate} {
+			if _, err := os.Stat(filepath.Join(manager.LibraryPath, f)); err != nil {
+				t.Errorf("Expected %s in the library: %v", f, err)
+			}
+		}
+		return []string{filepath.ToSlash(filename), filepath.ToSlash(motion), filepath.ToSlash(alternate)}
+	}
+
+	corrected := time.Date(2021, 6, 15, 12, 30, 0, 0, time.UTC)
+	if err := manager.UpdateMetadata(id, "date_taken", corrected); err != nil {
+		t.Fatalf("UpdateMetadata failed: %v", err)
+	}
+	moved := []string{"2021/06/15/2021-06-15_12-30-00.jpg", "2021/06/15/2021-06-15_12-30-00.mov", "2021/06/15/2021-06-15_12-30-00.cr2"}
+	if got := files(); !reflect.DeepEqual(got, moved) {
+		t.Errorf("Expected the pair to move together, got %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(manager.LibraryPath, "2019")); !os.IsNotExist(err) {
+		t.Errorf("Expected the old folders to be removed, got %v", err)
+	}
+
+	if _, err := manager.Undo(); err != nil {
+		t.Fatalf("Undo failed: %v", err)
+	}
+	want := []string{"2019/01/01/2019-01-01_00-00-00.jpg", "2019/01/01/2019-01-01_00-00-00_1.mov", "2019/01/01/2019-01-01_00-00-00.cr2"}
+	if got := files(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected undo to restore every file, got %v", got)
+	}
+	if _, err := manager.Redo(); err != nil {
+		t.Fatalf("Redo failed: %v", err)
+	}
+	if got := files(); !reflect.DeepEqual(got, moved) {
+		t.Errorf("Expected redo to move the pair again, got %v", got)
+	}
+}
+
+func TestRefileRollsBackOnFailure(t *testing.T) {
+	manager := newTestManager(t)
+
+	a := insertTestPhoto(t, manager, "a.jpg", time.Now())
+	missing := insertTestPhoto(t, manager, "missing.jpg", time.Now())
+	os.Remove(filepath.Join(manager.LibraryPath, "missing.jpg"))
+
+	corrected := time.Date(2021, 6, 15, 12, 30, 0, 0, time.UTC)
+	_, err := manager.ApplyMetadataChanges("Fix dates", []MetadataChange{
+		{PhotoID: a, Field: "date_taken", Value: corrected},
+		{PhotoID: missing, Field: "date_taken", Value: corrected},
+	})
+	if err == nil {
+		t.Fatal("Expected moving a missing file to fail")
+	}
+	// The first photo's move is reverted along with the database changes
+	if _, err := os.Stat(filepath.Join(manager.LibraryPath, "a.jpg")); err != nil {
+		t.Errorf("Expected a.jpg to be moved back: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(manager.LibraryPath, "2021")); err == nil {
+		t.Error("Expected no folder left behind for the failed move")
+	}
+	var filename string
+	manager.DB.QueryRow("SELECT filename FROM photos WHERE id = ?", a).Scan(&filename)
+	if filename != "a.jpg" {
+		t.Errorf("Expected filename to be unchanged, got %s", filename)
+	}
+}
+
+func TestRevertMetadata(t *testing.T) {
+	manager := newTestManager(t)
+	id := insertTestPhoto(t, manager, "a.jpg", time.Now())
+
+	manager.UpdateMetadata(id, "camera_model", "Second")
+	manager.UpdateMetadata(id, "camera_model", "Third")
+	manager.UpdateMetadata(id, "latitude", 52.52)
+
+	history, _ := manager.GetPhotoHistory(id)
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 history entries, got %d", len(history))
+	}
+
+	// Oldest entry changed "Camera" to "Second"; reverting restores "Camera"
+	if err := manager.RevertMetadata(history[2].ID); err != nil {
+		t.Fatalf("RevertMetadata failed: %v", err)
+	}
+	var model string
+	manager.DB.QueryRow("SELECT camera_model FROM photos WHERE id = ?", id).Scan(&model)
+	if model != "Camera" {
+		t.Errorf("Expected 'Camera' after revert, got %q", model)
+	}
+
+	// Reverting the latitude restores NULL
+	if err := manager.RevertMetadata(history[0].ID); err != nil {
+		t.Fatalf("RevertMetadata failed: %v", err)
+	}
+	var lat *float64
+	manager.DB.QueryRow("SELECT latitude FROM photos WHERE id = ?", id).Scan(&lat)
+	if lat != nil {
+		t.Errorf("Expected NULL latitude after revert, got %v", *lat)
+	}
+
+	if err := manager.UpdateMetadata(id, "hash", "x"); err == nil {
+		t.Error("Expected error when editing a non-editable field")
+	}
+	if err := manager.UpdateMetadata(id, "filename", "b.jpg"); err == nil {
+		t.Error("Expected error when editing the filename directly")
+	}
+
+	// Reverting the move of a date change moves the file back
+	manager.UpdateMetadata(id, "date_taken", time.Date(2021, 6, 15, 12, 30, 0, 0, time.UTC))
+	history, _ = manager.GetPhotoHistory(id)
+	if history[0].FieldName != "filename" {
+		t.Fatalf("Expected the move in the history, got %+v", history[0])
+	}
+	if err := manager.RevertMetadata(history[0].ID); err != nil {
+		t.Fatalf("RevertMetadata of the filename failed: %v", err)
+	}
+	var filename string
+	manager.DB.QueryRow("SELECT filename FROM photos WHERE id = ?", id).Scan(&filename)
+	if _, err := os.Stat(filepath.Join(manager.LibraryPath, "a.jpg")); filename != "a.jpg" || err != nil {
+		t.Errorf("Expected the photo back at a.jpg, got %s, %v", filename, err)
+	}
+}
+
+func TestMetadataWrittenToFile(t *testing.T) {
+	manager := newTestManager(t)
+
+	data, err := os.ReadFile(filepath.Join("..", "..", "test_data", "source_digital_camera", "RIMG0018.JPG"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcPath := filepath.Join(t.TempDir(), "RIMG0018.JPG")
+	os.WriteFile(srcPath, data, 0644)
+	photo, err := importPhoto(manager, srcPath)
+	if err != nil {
+		t.Fatalf("ImportPhoto failed: %v", err)
+	}
+
+	newDate := time.Date(2012, 1, 2, 3, 4, 5, 0, time.UTC)
+	lat, lon := 41.9028, 12.4964
+	_, err = manager.ApplyMetadataChanges("Fix date and place", []MetadataChange{
+		{PhotoID: photo.ID, Field: "date_taken", Value: newDate},
+		{PhotoID: photo.ID, Field: "latitude", Value: &lat},
+		{PhotoID: photo.ID, Field: "longitude", Value: &lon},
+	})
+	if err != nil {
+		t.Fatalf("ApplyMetadataChanges failed: %v", err)
+	}
+	var libraryPath string
+	manager.DB.QueryRow("SELECT library_path FROM photos WHERE id = ?", photo.ID).Scan(&libraryPath)
+	m, err := exif.ExtractMetadata(libraryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.DateTaken.Format("2006-01-02 15:04:05") != "2012-01-02 03:04:05" || m.Latitude == nil || *m.Latitude < 41.9 {
+		t.Errorf("Expected edits in the file, got %v at %v", m.DateTaken, m.Latitude)
+	}
+	// The photo's zone is unknown, so none is made up
+	if edited, _ := manager.GetPhoto(photo.ID); edited.UTCOffset != nil {
+		t.Errorf("Expected no offset to be stored, got %d", *edited.UTCOffset)
+	}
+
+	// Undo writes the original values back
+	if _, err := manager.Undo(); err != nil {
+		t.Fatalf("Undo failed: %v", err)
+	}
+	m, _ = exif.ExtractMetadata(photo.LibraryPath)
+	if m.DateTaken.Format("2006-01-02 15:04:05") != "2011-08-10 10:30:36" || m.Latitude != nil {
+		t.Errorf("Expected original values after undo, got %v at %v", m.DateTaken, m.Latitude)
+	}
+}
+
+func TestDateEditKeepsCaptureZone(t *testing.T) {
+	manager := newTestManager(t)
+	srcPath := filepath.Join(t.TempDir(), "RIMG0018.JPG")
+	os.WriteFile(srcPath, mustRead(t, "../../test_data/source_digital_camera/RIMG0018.JPG"), 0644)
+	photo, err := importPhoto(manager, srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Taken in Tokyo, edited on a machine in another zone
+	tokyo := time.FixedZone("", 9*3600)
+	manager.DB.Exec("UPDATE photos SET date_taken = ?, date_taken_utc = ?, utc_offset = ? WHERE id = ?",
+		photo.DateTaken.In(tokyo), photo.DateTaken.UTC(), 9*3600, photo.ID)
+
+	edited := time.Date(2021, 6, 15, 12, 30, 0, 0, time.FixedZone("", -5*3600))
+	if err := manager.UpdateMetadata(photo.ID, "date_taken", edited); err != nil {
+		t.Fatal(err)
+	}
+	photo, _ = manager.GetPhoto(photo.ID)
+	if photo.UTCOffset == nil || *photo.UTCOffset != 9*3600 {
+		t.Errorf("Expected the Tokyo offset to be kept, got %v", photo.UTCOffset)
+	}
+	if want := time.Date(2021, 6, 15, 3, 30, 0, 0, time.UTC); !photo.DateTakenUTC.Equal(want) {
+		t.Errorf("Expected 12:30 in Tokyo (%v), got %v", want, photo.DateTakenUTC)
+	}
+	m, err := exif.ExtractMetadata(photo.LibraryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.DateTaken.Format("2006-01-02 15:04:05") != "2021-06-15 12:30:00" || m.UTCOffset == nil || *m.UTCOffset != 9*3600 {
+		t.Errorf("Expected 12:30 +09:00 in the file, got %v (offset %v)", m.DateTaken, m.UTCOffset)
+	}
+}
+
+func TestSidecarWrittenOnEdit(t *testing.T) {
+	manager := newTestManager(t)
+	id := insertTestPhoto(t, manager, "a.heic", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC))
+	manager.DB.Exec("UPDATE photos SET rating = 4, title = 'Lighthouse' WHERE id = ?", id)
+	manager.DB.Exec("INSERT INTO photo_keywords (photo_id, keyword) VALUES (?, 'coast')", id)
+
+	// Off by default: a camera model edit touches no files
+	if err := manager.UpdateMetadata(id, "camera_model", "Other"); err != nil {
+		t.Fatalf("UpdateMetadata failed: %v", err)
+	}
+	libraryPath := filepath.Join(manager.LibraryPath, "a.heic")
+	if _, err := os.Stat(exif.SidecarPath(libraryPath)); !os.IsNotExist(err) {
+		t.Fatalf("Expected no sidecar while disabled, got %v", err)
+	}
+
+	if err := manager.SetWriteSidecars(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.UpdateMetadata(id, "camera_model", "Third"); err != nil {
+		t.Fatalf("UpdateMetadata failed: %v", err)
+	}
+	xmp, err := os.ReadFile(exif.SidecarPath(libraryPath))
+	if err != nil {
+		t.Fatalf("Expected a sidecar: %v", err)
+	}
+	for _, want := range []string{`xmp:Rating="4"`, "Lighthouse", "<rdf:li>coast</rdf:li>", `exif:DateTimeOriginal="2019-01-01T00:00:00"`} {
+		if !strings.Contains(string(xmp), want) {
+			t.Errorf("Sidecar is missing %s:\n%s", want, xmp)
+		}
+	}
+
+	// The choice is saved with the library
+	reopened, err := NewManager(manager.LibraryPath, manager.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.WritesSidecars() {
+		t.Error("Expected writing sidecars to stay enabled")
+	}
+}
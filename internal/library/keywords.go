@@ -0,0 +1,40 @@
+package library
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// setKeywords replaces the keywords of a photo. Blank and repeated keywords
+// are dropped.
+func setKeywords(tx *sql.Tx, photoID int64, keywords []string) error {
+	return setList(tx, "DELETE FROM photo_keywords WHERE photo_id = ?",
+		"INSERT OR IGNORE INTO photo_keywords (photo_id, keyword) VALUES (?, ?)", photoID, keywords)
+}
+
+// setPeople replaces the people tagged in a photo.
+func setPeople(tx *sql.Tx, photoID int64, people []string) error {
+	return setList(tx, "DELETE FROM photo_people WHERE photo_id = ?",
+		"INSERT OR IGNORE INTO photo_people (photo_id, name) VALUES (?, ?)", photoID, people)
+}
+
+func setList(tx *sql.Tx, deleteQuery, insertQuery string, photoID int64, items []string) error {
+	if _, err := tx.Exec(deleteQuery, photoID); err != nil {
+		return err
+	}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if _, err := tx.Exec(insertQuery, photoID, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nullString stores empty strings as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
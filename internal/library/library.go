@@ -4,11 +4,14 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"photoo/internal/exif"
@@ -20,120 +23,393 @@ import (
 type Manager struct {
 	LibraryPath string
 	DB          *sql.DB
+
+	mu            sync.Mutex          // guards reserved, inflight, writeSidecars, naming and importMode
+	reserved      map[string]struct{} // library paths claimed by running imports
+	inflight      map[string]struct{} // hashes currently being imported
+	writeSidecars bool                // emit an XMP sidecar whenever metadata changes
+	naming        *namingTemplate     // where new photos are filed
+	importMode    string              // how files are brought into the library, see ImportCopy
+
+	similar      similarIndex  // perceptual hashes for near-duplicate search
+	watchChanged chan struct{} // signals Watch that the watch folders changed
 }
 
 func NewManager(libraryPath string, db *sql.DB) (*Manager, error) {
 	if err := os.MkdirAll(libraryPath, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create library directory: %w", err)
 	}
-	return &Manager{LibraryPath: libraryPath, DB: db}, nil
+	m := &Manager{
+		LibraryPath: libraryPath,
+		DB:          db,
+		reserved:    make(map[string]struct{}),
+		inflight:    make(map[string]struct{}),
+
+		watchChanged: make(chan struct{}, 1),
+	}
+	if err := m.loadNamingTemplate(); err != nil {
+		return nil, err
+	}
+	if err := m.loadImportMode(); err != nil {
+		return nil, err
+	}
+	if err := m.loadWriteSidecars(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SetWriteSidecars turns writing an XMP sidecar next to each edited photo on
+// or off, and saves the choice with the library. Sidecars let other tools
+// pick up edits of formats photoo cannot patch, and of fields EXIF has no
+// tags for.
+func (m *Manager) SetWriteSidecars(enabled bool) error {
+	if err := m.saveSetting(settingWriteSidecars, strconv.FormatBool(enabled)); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writeSidecars = enabled
+	return nil
 }
 
-func (m *Manager) ImportPhoto(sourcePath string) (*models.Photo, error) {
-	// 1. Calculate Hash
-	hash, err := calculateHash(sourcePath)
+// loadWriteSidecars reads the saved sidecar choice.
+func (m *Manager) loadWriteSidecars() error {
+	value, err := m.setting(settingWriteSidecars, "false")
 	if err != nil {
-		return nil, fmt.Errorf("failed to calculate hash: %w", err)
+		return err
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		fmt.Printf("[BACKEND] Ignoring invalid sidecar setting %q\n", value)
+	}
+	m.writeSidecars = enabled
+	return nil
+}
+
+// WritesSidecars reports whether XMP sidecars are written on edits.
+func (m *Manager) WritesSidecars() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.writeSidecars
+}
+
+// ImportResult describes what ImportPhoto did with a file.
+type ImportResult struct {
+	SourcePath string
+	Status     string // models.ItemImported, models.ItemDuplicate or models.ItemError
+	// Photo is the library item the file became, or for a Live Photo video or
+	// raw file, the item it was attached to. Nil unless Status is imported.
+	Photo    *models.Photo
+	Attached bool // the file became part of an existing item
+	// DuplicateOf is the photo with the same content, if the file was a
+	// duplicate of a photo already in the library.
+	DuplicateOf int64
+	// NearDuplicates are the photos grouped with the new one because they
+	// look the same.
+	NearDuplicates []int64
+}
+
+// ImportPhoto imports a single file into the library by running it through
+// every import stage in sequence. The result is returned along with any
+// error; the error matches one of the Err values of this package.
+func (m *Manager) ImportPhoto(sourcePath string) (*ImportResult, error) {
+	job := &importJob{sourcePath: sourcePath}
+	defer m.releaseJob(job)
+
+	if !IsSupportedFile(sourcePath) {
+		job.err = fmt.Errorf("%w: %s", ErrUnsupportedFormat, filepath.Base(sourcePath))
+	}
+	for _, stage := range m.importStages(1) {
+		if job.err != nil {
+			break
+		}
+		job.err = stage.run(job)
+	}
+
+	if errors.Is(job.err, ErrDuplicate) {
+		if err := m.recordDuplicateHit(0, job); err != nil {
+			fmt.Printf("[BACKEND] %v\n", err)
+		}
+	}
+	return job.result(), job.err
+}
+
+// hashStage calculates the content hash and rejects files that are already in
+// the library or its trash, or currently being imported by another worker. Archive entries
+// are checked before they are streamed out of the archive, so that
+// duplicates are never written.
+func (m *Manager) hashStage(job *importJob) error {
+	if job.claimed {
+		return nil
+	}
+	if job.hash == "" {
+		info, err := os.Stat(job.sourcePath)
+		if err != nil {
+			return fmt.Errorf("failed to calculate hash: %w", err)
+		}
+		job.size = info.Size()
+
+		hash, err := calculateHash(job.sourcePath)
+		if err != nil {
+			return fmt.Errorf("failed to calculate hash: %w", err)
+		}
+		job.hash = hash
 	}
+	hash := job.hash
 
-	// 2. Check for Duplicates in DB
 	var existingID int64
-	err = m.DB.QueryRow("SELECT id FROM photos WHERE hash = ?", hash).Scan(&existingID)
+	err := m.DB.QueryRow("SELECT id FROM photos WHERE hash = ? OR alternate_hash = ?", hash, hash).Scan(&existingID)
+	if err == nil {
+		return &DuplicateError{ExistingID: existingID, Hash: hash}
+	} else if err != sql.ErrNoRows {
+		return fmt.Errorf("failed to query database: %w", err)
+	}
+	var replacedBy sql.NullInt64
+	err = m.DB.QueryRow("SELECT replaced_by FROM trash WHERE hash = ? ORDER BY id DESC LIMIT 1", hash).Scan(&replacedBy)
 	if err == nil {
-		return nil, fmt.Errorf("duplicate photo detected (hash: %s)", hash)
+		return &DuplicateError{ExistingID: replacedBy.Int64, Hash: hash, Trashed: true}
 	} else if err != sql.ErrNoRows {
-		return nil, fmt.Errorf("failed to query database: %w", err)
+		return fmt.Errorf("failed to query database: %w", err)
 	}
 
-	// 3. Extract Metadata (checks sidecars)
-	metadata, err := exif.ExtractMetadata(sourcePath)
+	if !m.claimHash(hash) {
+		return &DuplicateError{Hash: hash}
+	}
+	job.claimed = true
+	return nil
+}
+
+// metadataStage extracts the capture metadata (checks sidecars).
+func (m *Manager) metadataStage(job *importJob) error {
+	path := job.sourcePath
+	var metadata *exif.Metadata
+	var err error
+	if job.sidecars != nil {
+		path = job.tempPath
+		metadata, err = exif.ExtractMetadataWithSidecars(path, *job.sidecars)
+	} else {
+		metadata, err = exif.ExtractMetadata(path)
+	}
 	if err != nil {
 		metadata = &exif.Metadata{}
-		info, _ := os.Stat(sourcePath)
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMetadata, err)
+		}
 		metadata.DateTaken = info.ModTime()
 	}
+	job.metadata = metadata
 
-	// 4. Determine Filename (YYYY-MM-DD_HH-mm-ss)
-	ext := filepath.Ext(sourcePath)
-	baseFilename := metadata.DateTaken.Format("2006-01-02_15-04-05")
+	if !metadata.Video {
+		// A file that decodes to no pixels has nothing to compare
+		if img, err := openImage(path, nil); err == nil && !img.Bounds().Empty() {
+			hash := perceptualHash(img)
+			job.phash = &hash
+		}
+	}
+	return nil
+}
 
-	// Determine subfolder: YYYY/MM/DD
-	subDir := metadata.DateTaken.Format("2006/01/02")
+// copyStage copies, links or clones the file, depending on the import mode,
+// next to the final location the naming template gives it. The copy is
+// written to a temporary file, synced and checked against the hash of the
+// source; the insert stage renames it into place.
+func (m *Manager) copyStage(job *importJob) error {
+	ext := filepath.Ext(job.sourcePath)
+	subDir, baseFilename := m.namingTemplate().location(namingFields{
+		date:         job.metadata.DateTaken,
+		cameraMake:   job.metadata.CameraMake,
+		cameraModel:  job.metadata.CameraModel,
+		originalPath: job.sourcePath,
+	})
 	targetDir := filepath.Join(m.LibraryPath, subDir)
 	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create subfolder %s: %w", subDir, err)
+		return fmt.Errorf("%w: cannot create subfolder %s: %w", ErrCopy, subDir, err)
 	}
 
-	finalBase, err := m.findUniqueFilename(targetDir, baseFilename, ext)
+	finalFilename, err := m.reserveFilename(targetDir, baseFilename, ext)
 	if err != nil {
-		return nil, fmt.Errorf("failed to determine unique filename: %w", err)
+		return fmt.Errorf("failed to determine unique filename: %w", err)
 	}
-	finalFilename := finalBase + ext
+	job.libraryPath = filepath.Join(targetDir, finalFilename)
+	job.filename = filepath.Join(subDir, finalFilename)
 
-	// 5. Copy File to Library
-	libraryPath := filepath.Join(targetDir, finalFilename)
-	if err := copyFile(sourcePath, libraryPath); err != nil {
-		return nil, fmt.Errorf("failed to copy file: %w", err)
+	if job.tempPath != "" {
+		// Copied out of an archive already
+		return nil
+	}
+	mode := m.ImportMode()
+	tempPath, err := transferToTemp(job.sourcePath, targetDir, mode)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCopy, err)
 	}
+	job.tempPath = tempPath
 
-	// 6. Save to Database
+	hash, err := calculateHash(tempPath)
+	if err != nil {
+		return fmt.Errorf("%w: cannot verify copy: %w", ErrCopy, err)
+	}
+	if hash != job.hash {
+		return fmt.Errorf("%w: copy of %s does not match the source", ErrCopy, filepath.Base(job.sourcePath))
+	}
+	job.removeSource = mode == ImportMove
+	return nil
+}
+
+// insertStage saves the imported photo to the database.
+func (m *Manager) insertStage(job *importJob) error {
 	photo := &models.Photo{
-		OriginalPath: sourcePath,
-		LibraryPath:  libraryPath,
-		Filename:     filepath.Join(subDir, finalFilename),
-		Hash:         hash,
-		DateTaken:    metadata.DateTaken,
-		CameraModel:  metadata.CameraModel,
+		OriginalPath: job.sourcePath,
+		LibraryPath:  job.libraryPath,
+		Filename:     job.filename,
+		Hash:         job.hash,
+		DateTaken:    job.metadata.DateTaken,
+		DateTakenUTC: job.metadata.DateTaken.UTC(),
+		UTCOffset:    job.metadata.UTCOffset,
+		CameraMake:   job.metadata.CameraMake,
+		CameraModel:  job.metadata.CameraModel,
+		LensModel:    job.metadata.LensModel,
+		FNumber:      job.metadata.FNumber,
+		ExposureTime: job.metadata.ExposureTime,
+		ISO:          job.metadata.ISO,
+		FocalLength:  job.metadata.FocalLength,
+		Orientation:  job.metadata.Orientation,
+		Width:        job.metadata.Width,
+		Height:       job.metadata.Height,
+		Altitude:     job.metadata.Altitude,
+		Title:        job.metadata.Title,
+		Description:  job.metadata.Description,
+		Keywords:     job.metadata.Keywords,
+		Favorite:     job.metadata.Favorite,
+		People:       job.metadata.People,
+		MediaType:    models.MediaPhoto,
+		Duration:     job.metadata.Duration,
 		ImportDate:   time.Now(),
+
+		ContentIdentifier: job.metadata.ContentIdentifier,
+	}
+	if job.metadata.Video {
+		photo.MediaType = models.MediaVideo
+	}
+	if job.metadata.MotionPhoto {
+		photo.MotionType = models.MotionEmbedded
+	}
+	if job.metadata.Rating != nil {
+		photo.Rating = *job.metadata.Rating
 	}
 
-	if metadata.Latitude != nil {
-		photo.Latitude = metadata.Latitude
+	if job.metadata.Latitude != nil {
+		photo.Latitude = job.metadata.Latitude
 	}
-	if metadata.Longitude != nil {
-		photo.Longitude = metadata.Longitude
+	if job.metadata.Longitude != nil {
+		photo.Longitude = job.metadata.Longitude
 	}
 
-	res, err := m.DB.Exec(
-		"INSERT INTO photos (original_path, library_path, filename, hash, date_taken, camera_model, latitude, longitude, import_date) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		photo.OriginalPath, photo.LibraryPath, photo.Filename, photo.Hash, photo.DateTaken, photo.CameraModel, photo.Latitude, photo.Longitude, photo.ImportDate,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to save photo to database: %w", err)
+	// The index is consulted before the transaction takes the connection
+	var similar []bkMatch
+	if job.phash != nil {
+		var err error
+		if similar, err = m.nearDuplicates(*job.phash); err != nil {
+			return err
+		}
 	}
 
-	id, _ := res.LastInsertId()
-	photo.ID = id
+	// The row is only committed once the file is in place, so that either
+	// both exist or neither does.
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to save photo to database: %w", err)
+	}
+	defer tx.Rollback()
 
-	return photo, nil
-}
+	partner, err := findLivePhotoPartner(tx, photo)
+	if err != nil {
+		return fmt.Errorf("failed to save photo to database: %w", err)
+	}
+	if partner != nil && photo.MediaType == models.MediaVideo {
+		return m.attachLiveVideo(tx, job, partner.ID)
+	}
+	if partner != nil {
+		photo.MotionType = models.MotionLive
+		photo.MotionFilename = partner.Filename
+	}
 
-func (m *Manager) UpdateMetadata(photoID int64, field string, newValue interface{}) error {
-	// 1. Get current value
-	var oldValue string
-	query := fmt.Sprintf("SELECT %s FROM photos WHERE id = ?", field)
-	err := m.DB.QueryRow(query, photoID).Scan(&oldValue)
+	pair, err := findRawPartner(tx, photo)
 	if err != nil {
-		return fmt.Errorf("failed to get old value: %w", err)
+		return fmt.Errorf("failed to save photo to database: %w", err)
+	}
+	if pair != nil && isRawFile(photo.Filename) {
+		return m.attachRawFile(tx, job, pair.ID)
+	}
+	var alternateHash string
+	var phash *int64
+	if job.phash != nil {
+		// SQLite integers are signed
+		v := int64(*job.phash)
+		phash = &v
+	}
+	if pair != nil {
+		photo.AlternateFilename = pair.Filename
+		alternateHash = pair.Hash
 	}
 
-	// 2. Log in metadata_history
-	_, err = m.DB.Exec(
-		"INSERT INTO metadata_history (photo_id, field_name, old_value, new_value) VALUES (?, ?, ?, ?)",
-		photoID, field, oldValue, fmt.Sprintf("%v", newValue),
+	res, err := tx.Exec(
+		`INSERT INTO photos (original_path, library_path, filename, hash, date_taken, date_taken_utc, utc_offset, camera_make, camera_model, lens_model,
+			f_number, exposure_time, iso, focal_length, orientation, width, height, latitude, longitude, altitude, import_date, rating, title, description, favorite,
+			media_type, duration, content_identifier, motion_type, motion_filename, alternate_filename, alternate_hash, phash)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		photo.OriginalPath, photo.LibraryPath, photo.Filename, photo.Hash, photo.DateTaken, photo.DateTakenUTC, photo.UTCOffset, photo.CameraMake, photo.CameraModel, photo.LensModel,
+		photo.FNumber, photo.ExposureTime, photo.ISO, photo.FocalLength, photo.Orientation, photo.Width, photo.Height,
+		photo.Latitude, photo.Longitude, photo.Altitude, photo.ImportDate, photo.Rating, nullString(photo.Title), nullString(photo.Description),
+		photo.Favorite, photo.MediaType, photo.Duration, nullString(photo.ContentIdentifier), nullString(photo.MotionType), nullString(photo.MotionFilename),
+		nullString(photo.AlternateFilename), nullString(alternateHash), phash,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to log metadata history: %w", err)
+		return fmt.Errorf("failed to save photo to database: %w", err)
 	}
-
-	// 3. Update DB
-	updateQuery := fmt.Sprintf("UPDATE photos SET %s = ? WHERE id = ?", field)
-	_, err = m.DB.Exec(updateQuery, newValue, photoID)
+	id, _ := res.LastInsertId()
+	if err := setKeywords(tx, id, photo.Keywords); err != nil {
+		return fmt.Errorf("failed to save photo to database: %w", err)
+	}
+	if err := setPeople(tx, id, photo.People); err != nil {
+		return fmt.Errorf("failed to save photo to database: %w", err)
+	}
+	if partner != nil {
+		if err := absorbItem(tx, partner.ID, id); err != nil {
+			return fmt.Errorf("failed to link Live Photo video: %w", err)
+		}
+	}
+	if pair != nil {
+		if err := absorbItem(tx, pair.ID, id); err != nil {
+			return fmt.Errorf("failed to link raw file: %w", err)
+		}
+	}
+	nearDuplicates, err := groupNearDuplicates(tx, id, similar)
 	if err != nil {
-		return fmt.Errorf("failed to update database: %w", err)
+		return fmt.Errorf("failed to group near-duplicates: %w", err)
 	}
 
-	// TODO: Phase 3 - Write back to file EXIF
+	if err := os.Rename(job.tempPath, job.libraryPath); err != nil {
+		return fmt.Errorf("%w: cannot move file into library: %w", ErrCopy, err)
+	}
+	job.tempPath = ""
+	syncDir(filepath.Dir(job.libraryPath))
+
+	if err := tx.Commit(); err != nil {
+		os.Remove(job.libraryPath)
+		return fmt.Errorf("failed to save photo to database: %w", err)
+	}
+
+	photo.ID = id
+	job.photo = photo
+	job.nearDuplicates = nearDuplicates
+	if job.phash != nil {
+		m.indexPerceptualHash(id, *job.phash)
+	}
+	if pair != nil {
+		m.unindexPhoto(pair.ID)
+	}
 
 	return nil
 }
@@ -143,7 +419,8 @@ func (m *Manager) findUniqueFilename(dir, base, ext string) (string, error) {
 	counter := 1
 	for {
 		path := filepath.Join(dir, filename)
-		if _, err := os.Stat(path); os.IsNotExist(err) {
+		_, taken := m.reserved[path]
+		if _, err := os.Stat(path); !taken && os.IsNotExist(err) {
 			return filepath.Base(strings.TrimSuffix(filename, ext)), nil
 		}
 		filename = fmt.Sprintf("%s_%d%s", base, counter, ext)
@@ -154,6 +431,61 @@ func (m *Manager) findUniqueFilename(dir, base, ext string) (string, error) {
 	}
 }
 
+// reserveFilename picks a unique filename in dir and claims it until the
+// import that reserved it is released, so concurrent imports never pick the
+// same name. Must not be called with m.mu held.
+func (m *Manager) reserveFilename(dir, base, ext string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	finalBase, err := m.findUniqueFilename(dir, base, ext)
+	if err != nil {
+		return "", err
+	}
+	filename := finalBase + ext
+	m.reserved[filepath.Join(dir, filename)] = struct{}{}
+	return filename, nil
+}
+
+// claimHash marks hash as being imported. It returns false if another import
+// of the same content is already in progress.
+func (m *Manager) claimHash(hash string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.inflight[hash]; ok {
+		return false
+	}
+	m.inflight[hash] = struct{}{}
+	return true
+}
+
+// releaseJob drops the claims held by job and removes its temporary copy if
+// the file never made it into the library. The source of a move import is
+// deleted once the file did.
+func (m *Manager) releaseJob(job *importJob) {
+	if job.tempPath != "" {
+		os.Remove(job.tempPath)
+		job.tempPath = ""
+	}
+	if job.removeSource && job.err == nil {
+		if err := os.Remove(job.sourcePath); err != nil {
+			fmt.Printf("[BACKEND] Failed to remove moved file %s: %v\n", job.sourcePath, err)
+		}
+		job.removeSource = false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job.claimed {
+		delete(m.inflight, job.hash)
+		job.claimed = false
+	}
+	if job.libraryPath != "" {
+		delete(m.reserved, job.libraryPath)
+	}
+}
+
 func calculateHash(path string) (string, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -169,19 +501,42 @@ func calculateHash(path string) (string, error) {
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func copyFile(src, dst string) error {
+// copyToTemp copies src into a new temporary file in dir and syncs it to
+// disk. The temporary file is removed again if anything fails.
+func copyToTemp(src, dir string) (string, error) {
 	sourceFile, err := os.Open(src)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer sourceFile.Close()
 
-	destFile, err := os.Create(dst)
+	destFile, err := os.CreateTemp(dir, ".import-*.tmp")
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer destFile.Close()
+	tempPath := destFile.Name()
 
 	_, err = io.Copy(destFile, sourceFile)
-	return err
+	if err == nil {
+		err = destFile.Sync()
+	}
+	if closeErr := destFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return "", err
+	}
+	return tempPath, nil
+}
+
+// syncDir flushes a directory entry change (e.g. a rename) to disk. Errors are
+// ignored since not every platform supports syncing directories.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
 }
@@ -1,10 +1,14 @@
 package library
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"photoo/internal/db"
+	"photoo/internal/models"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestImportPhoto(t *testing.T) {
@@ -40,7 +44,7 @@ func TestImportPhoto(t *testing.T) {
 	}
 
 	// 4. Test Import
-	photo, err := manager.ImportPhoto(srcPath)
+	photo, err := importPhoto(manager, srcPath)
 	if err != nil {
 		t.Fatalf("ImportPhoto failed: %v", err)
 	}
@@ -55,9 +59,13 @@ func TestImportPhoto(t *testing.T) {
 	}
 
 	// 5. Test Duplicate Detection
-	_, err = manager.ImportPhoto(srcPath)
-	if err == nil {
-		t.Errorf("Expected error when importing duplicate, got nil")
+	result, err := manager.ImportPhoto(srcPath)
+	var dup *DuplicateError
+	if !errors.Is(err, ErrDuplicate) || !errors.As(err, &dup) || dup.ExistingID != photo.ID || dup.Hash != photo.Hash {
+		t.Errorf("Expected a duplicate of photo %d, got %v", photo.ID, err)
+	}
+	if result.Status != models.ItemDuplicate || result.DuplicateOf != photo.ID || result.Photo != nil {
+		t.Errorf("Unexpected duplicate result %+v", result)
 	}
 
 	// 6. Test UpdateMetadata
@@ -75,3 +83,167 @@ func TestImportPhoto(t *testing.T) {
 		t.Errorf("Expected camera_model 'New Camera', got '%s'", updatedModel)
 	}
 }
+
+func TestImportPhotoRollback(t *testing.T) {
+	manager := newTestManager(t)
+
+	srcPath := filepath.Join(t.TempDir(), "test.jpg")
+	if err := os.WriteFile(srcPath, []byte("fake-photo-content"), 0644); err != nil {
+		t.Fatalf("Failed to write dummy photo: %v", err)
+	}
+	taken := time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local)
+	os.Chtimes(srcPath, taken, taken)
+
+	// A row that claims the target filename without a file on disk makes the
+	// INSERT fail on the UNIQUE constraint after the copy was written.
+	_, err := manager.DB.Exec(
+		"INSERT INTO photos (original_path, library_path, filename, hash) VALUES (?, ?, ?, ?)",
+		"elsewhere", "elsewhere", filepath.Join("2020/01/02", "2020-01-02_03-04-05.jpg"), "other-hash",
+	)
+	if err != nil {
+		t.Fatalf("Failed to insert conflicting row: %v", err)
+	}
+
+	if _, err := manager.ImportPhoto(srcPath); err == nil {
+		t.Fatal("Expected ImportPhoto to fail on the filename conflict")
+	}
+
+	filepath.Walk(manager.LibraryPath, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			t.Errorf("Unexpected file left in library: %s", path)
+		}
+		return nil
+	})
+
+	var count int
+	manager.DB.QueryRow("SELECT COUNT(*) FROM photos WHERE hash != 'other-hash'").Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected no photo row after failed import, got %d", count)
+	}
+}
+
+func TestImportUsesCaptureZone(t *testing.T) {
+	manager := newTestManager(t)
+
+	// Taken 2023-06-01 23:30 UTC in Tokyo, where it was already June 2nd
+	srcDir := t.TempDir()
+	srcPath := filepath.Join(srcDir, "tokyo.jpg")
+	os.WriteFile(srcPath, []byte("tokyo-photo"), 0644)
+	os.WriteFile(srcPath+".supplemental-metadata.json", []byte(`{
+		"photoTakenTime": {"timestamp": "1685662200"},
+		"geoData": {"latitude": 35.6812, "longitude": 139.7671}
+	}`), 0644)
+
+	photo, err := importPhoto(manager, srcPath)
+	if err != nil {
+		t.Fatalf("ImportPhoto failed: %v", err)
+	}
+	if photo.Filename != filepath.Join("2023", "06", "02", "2023-06-02_08-30-00.jpg") {
+		t.Errorf("Expected name from Tokyo wall-clock time, got %s", photo.Filename)
+	}
+
+	photos, err := manager.SearchPhotos(PhotoFilter{})
+	if err != nil || len(photos) != 1 {
+		t.Fatalf("SearchPhotos failed: %v", err)
+	}
+	stored := photos[0]
+	if !stored.DateTakenUTC.Equal(time.Unix(1685662200, 0)) || stored.UTCOffset == nil || *stored.UTCOffset != 9*3600 {
+		t.Errorf("Unexpected capture time: %v (UTC %v, offset %v)", stored.DateTaken, stored.DateTakenUTC, stored.UTCOffset)
+	}
+	if stored.DateTaken.Format("2006-01-02 15:04") != "2023-06-02 08:30" {
+		t.Errorf("Expected local wall-clock date_taken, got %v", stored.DateTaken)
+	}
+}
+
+func TestImportReadsXMPSidecar(t *testing.T) {
+	manager := newTestManager(t)
+
+	srcPath := filepath.Join(t.TempDir(), "beach.jpg")
+	os.WriteFile(srcPath, []byte("beach-photo"), 0644)
+	os.WriteFile(srcPath+".xmp", []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:exif="http://ns.adobe.com/exif/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmp:Rating="3" exif:DateTimeOriginal="2020-08-01T17:45:00+02:00">
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Sunset</rdf:li></rdf:Alt></dc:title>
+   <dc:subject><rdf:Bag><rdf:li>sea</rdf:li><rdf:li>beach</rdf:li></rdf:Bag></dc:subject>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`), 0644)
+
+	photo, err := importPhoto(manager, srcPath)
+	if err != nil {
+		t.Fatalf("ImportPhoto failed: %v", err)
+	}
+	stored, err := manager.GetPhoto(photo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Rating != 3 || stored.Title != "Sunset" || !reflect.DeepEqual(stored.Keywords, []string{"beach", "sea"}) {
+		t.Errorf("Expected XMP rating, title and keywords, got %d %q %v", stored.Rating, stored.Title, stored.Keywords)
+	}
+	if stored.DateTaken.Format("2006-01-02 15:04 -07:00") != "2020-08-01 17:45 +02:00" {
+		t.Errorf("Expected the XMP date, got %v", stored.DateTaken)
+	}
+}
+
+func TestImportReadsTakeoutDetails(t *testing.T) {
+	manager := newTestManager(t)
+
+	srcDir := t.TempDir()
+	srcPath := filepath.Join(srcDir, "PXL_20220301_101500000(1).jpg")
+	os.WriteFile(srcPath, []byte("takeout-photo"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "PXL_20220301_101500000.jpg.supplemental-metad(1).json"), []byte(`{
+		"title": "PXL_20220301_101500000.jpg",
+		"description": "First day of spring",
+		"photoTakenTime": {"timestamp": "1646129700"},
+		"people": [{"name": "Grace"}, {"name": "Ada"}],
+		"favorited": true
+	}`), 0644)
+
+	photo, err := importPhoto(manager, srcPath)
+	if err != nil {
+		t.Fatalf("ImportPhoto failed: %v", err)
+	}
+	for query, expected := range map[string]int{"favorite:yes": 1, "favorite:no": 0, `person:ada person:GRACE`: 1, "person:Bob": 0} {
+		filter, _ := ParseQuery(query)
+		photos, err := manager.SearchPhotos(filter)
+		if err != nil || len(photos) != expected {
+			t.Errorf("Query %q: expected %d photos, got %d (%v)", query, expected, len(photos), err)
+		}
+	}
+	stored, _ := manager.GetPhoto(photo.ID)
+	if stored == nil || stored.Description != "First day of spring" || stored.Title != "" || !stored.Favorite ||
+		!reflect.DeepEqual(stored.People, []string{"Ada", "Grace"}) || stored.DateTakenUTC.Unix() != 1646129700 {
+		t.Errorf("Unexpected Takeout details: %+v", stored)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	manager := newTestManager(t)
+	srcDir := t.TempDir()
+
+	text := filepath.Join(srcDir, "notes.txt")
+	os.WriteFile(text, []byte("not a photo"), 0644)
+	result, err := manager.ImportPhoto(text)
+	if !errors.Is(err, ErrUnsupportedFormat) || result.Status != models.ItemError {
+		t.Errorf("Expected an unsupported format error, got %v, %+v", err, result)
+	}
+
+	if _, err := manager.ImportPhoto(filepath.Join(srcDir, "missing.jpg")); err == nil || errors.Is(err, ErrDuplicate) {
+		t.Errorf("Expected a plain error for a missing file, got %v", err)
+	}
+
+	// The library folder cannot be created below a file
+	blocked := newTestManager(t)
+	blocked.LibraryPath = text
+	copyTestPhoto(t, "RIMG0018.JPG", srcDir)
+	if _, err := blocked.ImportPhoto(filepath.Join(srcDir, "RIMG0018.JPG")); !errors.Is(err, ErrCopy) {
+		t.Errorf("Expected a copy error, got %v", err)
+	}
+
+	result, err = manager.ImportPhoto(filepath.Join(srcDir, "RIMG0018.JPG"))
+	if err != nil || result.Status != models.ItemImported || result.Photo == nil || result.Attached {
+		t.Errorf("Expected a new photo, got %+v, %v", result, err)
+	}
+}
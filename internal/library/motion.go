@@ -0,0 +1,110 @@
+package library
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"photoo/internal/models"
+)
+
+// An Apple Live Photo is a still and a short video sharing a content
+// identifier. Whichever half is imported second is linked to the first: a
+// video becomes the motion of its still instead of a library item of its
+// own, and a still takes over the motion of a video imported before it. The
+// video file stays in the library under its own name.
+
+// livePartner is the other half of a Live Photo already in the library.
+type livePartner struct {
+	ID       int64
+	Filename string
+}
+
+// findLivePhotoPartner returns the still of a video, or the video of a
+// still, imported with the same content identifier. It returns nil if there
+// is none, or if the still already has its motion.
+func findLivePhotoPartner(tx *sql.Tx, photo *models.Photo) (*livePartner, error) {
+	if photo.ContentIdentifier == "" {
+		return nil, nil
+	}
+	query := "SELECT id, filename FROM photos WHERE content_identifier = ? AND media_type = ? AND motion_filename IS NULL ORDER BY id LIMIT 1"
+	partnerType := models.MediaPhoto
+	if photo.MediaType == models.MediaPhoto {
+		query = "SELECT id, filename FROM photos WHERE content_identifier = ? AND media_type = ? ORDER BY id LIMIT 1"
+		partnerType = models.MediaVideo
+	}
+
+	var p livePartner
+	err := tx.QueryRow(query, photo.ContentIdentifier, partnerType).Scan(&p.ID, &p.Filename)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// attachLiveVideo moves the video of job into the library as the motion of
+// stillID and commits tx. The job reports the still as its photo.
+func (m *Manager) attachLiveVideo(tx *sql.Tx, job *importJob, stillID int64) error {
+	_, err := tx.Exec("UPDATE photos SET motion_type = ?, motion_filename = ? WHERE id = ?", models.MotionLive, job.filename, stillID)
+	if err != nil {
+		return fmt.Errorf("failed to link Live Photo video: %w", err)
+	}
+
+	if err := os.Rename(job.tempPath, job.libraryPath); err != nil {
+		return fmt.Errorf("%w: cannot move file into library: %w", ErrCopy, err)
+	}
+	job.tempPath = ""
+	syncDir(filepath.Dir(job.libraryPath))
+
+	if err := tx.Commit(); err != nil {
+		os.Remove(job.libraryPath)
+		return fmt.Errorf("failed to save photo to database: %w", err)
+	}
+
+	still, err := m.GetPhoto(stillID)
+	if err != nil {
+		return err
+	}
+	job.photo = still
+	job.attached = true
+	return nil
+}
+
+// absorbItem removes the library item of a file that became part of itemID,
+// such as the motion of a still or the raw file of a JPEG. Its file is kept;
+// import sessions, duplicate hits and its edit history point to itemID
+// instead, so the batches it was part of stay complete.
+func absorbItem(tx *sql.Tx, absorbedID, itemID int64) error {
+	if _, err := tx.Exec("UPDATE metadata_history SET photo_id = ? WHERE photo_id = ?", itemID, absorbedID); err != nil {
+		return err
+	}
+	return replaceItem(tx, absorbedID, itemID)
+}
+
+// replaceItem removes the library item removedID, pointing its import
+// sessions and duplicate hits to itemID. Its edit history is left alone.
+func replaceItem(tx *sql.Tx, removedID, itemID int64) error {
+	for _, query := range []string{
+		"UPDATE import_session_items SET photo_id = ? WHERE photo_id = ?",
+		"UPDATE duplicate_hits SET photo_id = ? WHERE photo_id = ?",
+	} {
+		if _, err := tx.Exec(query, itemID, removedID); err != nil {
+			return err
+		}
+	}
+	for _, query := range []string{
+		"DELETE FROM photo_keywords WHERE photo_id = ?",
+		"DELETE FROM photo_people WHERE photo_id = ?",
+		"DELETE FROM duplicate_group_members WHERE photo_id = ?",
+		"UPDATE duplicate_groups SET keeper_id = NULL WHERE keeper_id = ?",
+		"DELETE FROM photos WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, removedID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
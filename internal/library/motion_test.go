@@ -0,0 +1,116 @@
+package library
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"photoo/internal/models"
+)
+
+// importStillWithContentID imports a copy of a test photo as if it were the
+// still of a Live Photo with the given identifier.
+func importStillWithContentID(t *testing.T, m *Manager, name, contentID string) *models.Photo {
+	t.Helper()
+	data, _ := os.ReadFile("../../test_data/source_digital_camera/RIMG0018.JPG")
+	path := filepath.Join(t.TempDir(), name)
+	// Trailing bytes give every copy its own hash
+	data = append(data, name...)
+	os.WriteFile(path, data, 0644)
+
+	job := &importJob{sourcePath: path}
+	defer m.releaseJob(job)
+	for _, stage := range m.importStages(1) {
+		if err := stage.run(job); err != nil {
+			t.Fatalf("Importing %s failed in %s: %v", name, stage.name, err)
+		}
+		if stage.name == "metadata" {
+			job.metadata.ContentIdentifier = contentID
+		}
+	}
+	return job.photo
+}
+
+func TestLivePhotoPairing(t *testing.T) {
+	manager := newTestManager(t)
+	srcDir := t.TempDir()
+
+	// Video after its still: the video becomes the still's motion
+	still := importStillWithContentID(t, manager, "IMG_0001.JPG", "LIVE-1")
+	writeTestVideo(t, filepath.Join(srcDir, "IMG_0001.MOV"), "LIVE-1")
+	result, err := manager.ImportPhoto(filepath.Join(srcDir, "IMG_0001.MOV"))
+	if err != nil {
+		t.Fatalf("ImportPhoto failed: %v", err)
+	}
+	photo := result.Photo
+	if !result.Attached || photo.ID != still.ID || photo.MotionType != models.MotionLive || photo.MotionFilename == "" {
+		t.Fatalf("Expected the video to be linked to the still, got %+v", photo)
+	}
+	if _, err := os.Stat(filepath.Join(manager.LibraryPath, photo.MotionFilename)); err != nil {
+		t.Errorf("Expected the video in the library: %v", err)
+	}
+
+	// Still after its video: the video item is taken over by the still
+	writeTestVideo(t, filepath.Join(srcDir, "IMG_0002.MOV"), "LIVE-2")
+	video, err := importPhoto(manager, filepath.Join(srcDir, "IMG_0002.MOV"))
+	if err != nil || video.MediaType != models.MediaVideo {
+		t.Fatalf("Expected a standalone video, got %+v, %v", video, err)
+	}
+	still = importStillWithContentID(t, manager, "IMG_0002.JPG", "LIVE-2")
+	if still.MotionType != models.MotionLive || still.MotionFilename != video.Filename {
+		t.Errorf("Expected the still to take over the video, got %+v", still)
+	}
+	if _, err := manager.GetPhoto(video.ID); err == nil {
+		t.Error("Expected the video item to be gone")
+	}
+
+	// Videos with another identifier stay on their own
+	writeTestVideo(t, filepath.Join(srcDir, "IMG_0003.MOV"), "LIVE-3")
+	if video, err := importPhoto(manager, filepath.Join(srcDir, "IMG_0003.MOV")); err != nil || video.MediaType != models.MediaVideo {
+		t.Errorf("Expected a standalone video, got %+v, %v", video, err)
+	}
+	photos, _ := manager.SearchPhotos(PhotoFilter{})
+	if len(photos) != 3 {
+		t.Errorf("Expected 2 stills and 1 video, got %d items", len(photos))
+	}
+
+	// The motion is served for playback
+	handler := NewThumbnailHandler(manager.LibraryPath)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/motion/"+still.MotionFilename, nil))
+	expected, _ := os.ReadFile(filepath.Join(srcDir, "IMG_0002.MOV"))
+	if body, _ := io.ReadAll(rr.Body); rr.Code != http.StatusOK || string(body) != string(expected) {
+		t.Errorf("Unexpected motion response %d with %d bytes", rr.Code, len(body))
+	}
+}
+
+func TestServeEmbeddedMotion(t *testing.T) {
+	manager := newTestManager(t)
+	handler := NewThumbnailHandler(manager.LibraryPath)
+
+	video := filepath.Join(t.TempDir(), "clip.mp4")
+	writeTestVideo(t, video, "")
+	clip, _ := os.ReadFile(video)
+	still, _ := os.ReadFile("../../test_data/source_digital_camera/RIMG0018.JPG")
+	path := filepath.Join(t.TempDir(), "PXL_0001.MP.jpg")
+	os.WriteFile(path, append(still, clip...), 0644)
+
+	photo, err := importPhoto(manager, path)
+	if err != nil {
+		t.Fatalf("ImportPhoto failed: %v", err)
+	}
+	if photo.MotionType != models.MotionEmbedded || photo.MediaType != models.MediaPhoto {
+		t.Fatalf("Expected an embedded motion photo, got %+v", photo)
+	}
+
+	req := httptest.NewRequest("GET", "/motion/"+photo.Filename, nil)
+	req.Header.Set("Range", "bytes=4-11")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusPartialContent || rr.Body.String() != string(clip[4:12]) || rr.Header().Get("Content-Type") != "video/mp4" {
+		t.Errorf("Unexpected response %d %q %v", rr.Code, rr.Body.String(), rr.Header())
+	}
+}
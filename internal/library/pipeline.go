@@ -0,0 +1,332 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"photoo/internal/exif"
+	"photoo/internal/models"
+)
+
+// supportedExtensions lists the (lowercase) file extensions of still images
+// picked up by a folder import.
+var supportedExtensions = map[string]bool{
+	".jpg":  true,
+	".png":  true,
+	".heic": true,
+}
+
+// videoExtensions lists the (lowercase) file extensions of videos picked up
+// by a folder import.
+var videoExtensions = map[string]bool{
+	".mp4": true,
+	".mov": true,
+	".m4v": true,
+}
+
+// rawExtensions lists the (lowercase) file extensions of camera raw files
+// picked up by a folder import.
+var rawExtensions = map[string]bool{
+	".cr2": true,
+	".nef": true,
+	".arw": true,
+	".dng": true,
+}
+
+// IsSupportedFile reports whether path has an extension the library imports.
+func IsSupportedFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return supportedExtensions[ext] || videoExtensions[ext] || rawExtensions[ext]
+}
+
+// isVideoFile reports whether path has a video extension.
+func isVideoFile(path string) bool {
+	return videoExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// isRawFile reports whether path has a camera raw extension.
+func isRawFile(path string) bool {
+	return rawExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// ImportOptions configures a folder import.
+type ImportOptions struct {
+	// Workers is the number of goroutines per parallel stage.
+	// Zero or less means runtime.NumCPU().
+	Workers int
+	// OnStart is called once the folder has been scanned.
+	OnStart func(total int)
+	// OnProgress is called after every processed file.
+	OnProgress func(ImportProgress)
+}
+
+// ImportProgress is a snapshot of a running folder import.
+type ImportProgress struct {
+	SessionID      int64
+	Staged         int // archive entries streamed out of the archives
+	Current        int
+	Total          int
+	Imported       int
+	Duplicates     int
+	Errors         int
+	LastPath       string
+	Elapsed        time.Duration
+	FilesPerSecond float64
+	BytesPerSecond float64
+	ETA            time.Duration
+}
+
+// importJob carries one candidate file through the import stages.
+type importJob struct {
+	sourcePath     string
+	size           int64
+	hash           string
+	sidecars       *exif.Sidecars // set for archive entries, whose sidecars are not on disk
+	claimed        bool           // hash is registered in Manager.inflight
+	metadata       *exif.Metadata
+	phash          *uint64 // perceptual hash, nil for videos and undecodable images
+	libraryPath    string  // reserved final location
+	tempPath       string  // synced copy waiting to be renamed to libraryPath; archive entries start with one
+	removeSource   bool    // delete sourcePath once imported (move mode)
+	filename       string
+	photo          *models.Photo
+	attached       bool    // photo is an existing item the file became part of
+	nearDuplicates []int64 // photos grouped with the new item
+	err            error
+}
+
+// status classifies the outcome of a processed job.
+func (job *importJob) status() string {
+	switch {
+	case job.err == nil:
+		return models.ItemImported
+	case errors.Is(job.err, ErrDuplicate):
+		return models.ItemDuplicate
+	default:
+		return models.ItemError
+	}
+}
+
+func (job *importJob) result() *ImportResult {
+	result := &ImportResult{
+		SourcePath:     job.sourcePath,
+		Status:         job.status(),
+		NearDuplicates: job.nearDuplicates,
+	}
+	if job.err == nil {
+		result.Photo = job.photo
+		result.Attached = job.attached
+	}
+	var dup *DuplicateError
+	if errors.As(job.err, &dup) {
+		result.DuplicateOf = dup.ExistingID
+	}
+	return result
+}
+
+// importStage is one step of the import pipeline.
+type importStage struct {
+	name    string
+	workers int
+	// interruptible stages are skipped once the import is cancelled.
+	interruptible bool
+	run           func(*importJob) error
+}
+
+func (m *Manager) importStages(workers int) []importStage {
+	return []importStage{
+		{name: "hash", workers: workers, interruptible: true, run: m.hashStage},
+		{name: "metadata", workers: workers, interruptible: true, run: m.metadataStage},
+		{name: "copy", workers: workers, interruptible: true, run: m.copyStage},
+		// SQLite allows a single writer, so inserts are serialized.
+		{name: "insert", workers: 1, run: m.insertStage},
+	}
+}
+
+// ImportFolder recursively imports every supported file below folderPath.
+// The candidates are recorded as a new import session first, so that an
+// interrupted import can be continued with ResumeImport. Files are hashed,
+// inspected and copied by a pool of workers; cancelling ctx stops feeding new
+// files and lets the ones in flight finish. The returned progress reflects the
+// final state; the error is ctx.Err() on cancellation.
+func (m *Manager) ImportFolder(ctx context.Context, folderPath string, opts ImportOptions) (ImportProgress, error) {
+	var candidates []string
+	err := filepath.WalkDir(folderPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !d.IsDir() && IsSupportedFile(path) {
+			candidates = append(candidates, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return ImportProgress{}, err
+	}
+
+	sessionID, err := m.createImportSession(folderPath, candidates)
+	if err != nil {
+		return ImportProgress{}, err
+	}
+
+	progress := ImportProgress{SessionID: sessionID, Total: len(candidates)}
+	return m.runImport(ctx, jobsFor(candidates), progress, opts)
+}
+
+func jobsFor(paths []string) []*importJob {
+	jobs := make([]*importJob, len(paths))
+	for i, path := range paths {
+		jobs[i] = &importJob{sourcePath: path}
+	}
+	return jobs
+}
+
+// runImport feeds jobs through the import stages, recording every outcome in
+// the session of progress and reporting it through opts. Counts in progress
+// are continued, which lets a resumed session pick up where it stopped.
+func (m *Manager) runImport(ctx context.Context, queue []*importJob, progress ImportProgress, opts ImportOptions) (ImportProgress, error) {
+	return m.runPipeline(ctx, func(send func(*importJob) bool) error {
+		for _, job := range queue {
+			if !send(job) {
+				break
+			}
+		}
+		return nil
+	}, progress, opts)
+}
+
+// runPipeline is runImport for jobs produced while the import runs: feed
+// hands jobs to send, which returns false once the import is cancelled. If
+// feed fails, the session is left cancelled so that it can be resumed, and
+// its error is returned.
+func (m *Manager) runPipeline(ctx context.Context, feed func(send func(*importJob) bool) error, progress ImportProgress, opts ImportOptions) (ImportProgress, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	if opts.OnStart != nil {
+		opts.OnStart(progress.Total)
+	}
+
+	jobs := make(chan *importJob)
+	var feedErr error
+	go func() {
+		defer close(jobs)
+		feedErr = feed(func(job *importJob) bool {
+			select {
+			case jobs <- job:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+
+	var out <-chan *importJob = jobs
+	for _, stage := range m.importStages(workers) {
+		out = runStage(ctx, stage, out)
+	}
+
+	start := time.Now()
+	var processed int
+	var bytes int64
+	for job := range out {
+		m.releaseJob(job)
+		if ctx.Err() != nil && job.err == ctx.Err() {
+			// Skipped because of cancellation, stays pending in the session.
+			continue
+		}
+
+		if err := m.recordSessionItem(progress.SessionID, job); err != nil {
+			fmt.Printf("[BACKEND] Failed to record import outcome for %s: %v\n", job.sourcePath, err)
+		}
+		if errors.Is(job.err, ErrDuplicate) {
+			if err := m.recordDuplicateHit(progress.SessionID, job); err != nil {
+				fmt.Printf("[BACKEND] %v\n", err)
+			}
+		}
+
+		progress.Current++
+		if job.sidecars != nil {
+			progress.Staged++
+		}
+		processed++
+		bytes += job.size
+		switch job.status() {
+		case models.ItemImported:
+			progress.Imported++
+		case models.ItemDuplicate:
+			progress.Duplicates++
+		default:
+			progress.Errors++
+			fmt.Printf("[BACKEND] Import error for %s: %v\n", job.sourcePath, job.err)
+		}
+
+		progress.LastPath = filepath.Base(job.sourcePath)
+		progress.Elapsed = time.Since(start)
+		if secs := progress.Elapsed.Seconds(); secs > 0 {
+			progress.FilesPerSecond = float64(processed) / secs
+			progress.BytesPerSecond = float64(bytes) / secs
+			remaining := progress.Total - progress.Current
+			progress.ETA = time.Duration(float64(remaining) / progress.FilesPerSecond * float64(time.Second))
+		}
+		if opts.OnProgress != nil {
+			opts.OnProgress(progress)
+		}
+	}
+
+	// The feeder is done once the stages have drained
+	status := models.SessionCompleted
+	if ctx.Err() != nil || feedErr != nil {
+		status = models.SessionCancelled
+	}
+	if err := m.setSessionStatus(progress.SessionID, status); err != nil {
+		fmt.Printf("[BACKEND] %v\n", err)
+	}
+
+	if feedErr != nil && ctx.Err() == nil {
+		return progress, feedErr
+	}
+	return progress, ctx.Err()
+}
+
+// runStage starts stage.workers goroutines that apply stage to every job from
+// in. Jobs that already failed are passed through untouched.
+func runStage(ctx context.Context, stage importStage, in <-chan *importJob) <-chan *importJob {
+	out := make(chan *importJob)
+
+	var wg sync.WaitGroup
+	for i := 0; i < stage.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range in {
+				if job.err == nil {
+					if stage.interruptible && ctx.Err() != nil {
+						job.err = ctx.Err()
+					} else {
+						job.err = stage.run(job)
+					}
+				}
+				out <- job
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
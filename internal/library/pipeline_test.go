@@ -0,0 +1,110 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"photoo/internal/db"
+	"photoo/internal/models"
+	"testing"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	tempDir := t.TempDir()
+
+	dbConn, err := db.InitDB(filepath.Join(tempDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+
+	manager, err := NewManager(filepath.Join(tempDir, "library"), dbConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager
+}
+
+// importPhoto imports path and returns the photo it became.
+func importPhoto(m *Manager, path string) (*models.Photo, error) {
+	result, err := m.ImportPhoto(path)
+	return result.Photo, err
+}
+
+func TestImportFolder(t *testing.T) {
+	manager := newTestManager(t)
+
+	// Two identical files in the same batch must only be imported once,
+	// even though they are hashed by different workers.
+	srcDir := t.TempDir()
+	nested := filepath.Join(srcDir, "nested")
+	os.MkdirAll(nested, 0755)
+	os.WriteFile(filepath.Join(srcDir, "a.jpg"), []byte("photo-a"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "b.JPG"), []byte("photo-b"), 0644)
+	os.WriteFile(filepath.Join(nested, "copy-of-a.jpg"), []byte("photo-a"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "notes.txt"), []byte("skip me"), 0644)
+
+	var started, progressCalls int
+	progress, err := manager.ImportFolder(context.Background(), srcDir, ImportOptions{
+		Workers:    4,
+		OnStart:    func(total int) { started = total },
+		OnProgress: func(ImportProgress) { progressCalls++ },
+	})
+	if err != nil {
+		t.Fatalf("ImportFolder failed: %v", err)
+	}
+
+	if started != 3 {
+		t.Errorf("Expected 3 candidates, got %d", started)
+	}
+	if progressCalls != 3 {
+		t.Errorf("Expected 3 progress callbacks, got %d", progressCalls)
+	}
+	if progress.Imported != 2 || progress.Duplicates != 1 || progress.Errors != 0 {
+		t.Errorf("Unexpected result: %+v", progress)
+	}
+
+	var count int
+	manager.DB.QueryRow("SELECT COUNT(*) FROM photos").Scan(&count)
+	if count != 2 {
+		t.Errorf("Expected 2 photos in DB, got %d", count)
+	}
+
+	// A second run only finds duplicates
+	progress, err = manager.ImportFolder(context.Background(), srcDir, ImportOptions{Workers: 2})
+	if err != nil {
+		t.Fatalf("ImportFolder failed: %v", err)
+	}
+	if progress.Imported != 0 || progress.Duplicates != 3 {
+		t.Errorf("Expected only duplicates on second run, got %+v", progress)
+	}
+}
+
+func TestImportFolderCancel(t *testing.T) {
+	manager := newTestManager(t)
+
+	srcDir := t.TempDir()
+	os.WriteFile(filepath.Join(srcDir, "a.jpg"), []byte("photo-a"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "b.jpg"), []byte("photo-b"), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	progress, err := manager.ImportFolder(ctx, srcDir, ImportOptions{
+		OnStart: func(int) { cancel() },
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if progress.Imported != 0 {
+		t.Errorf("Expected nothing imported after cancel, got %d", progress.Imported)
+	}
+
+	// Nothing may be left behind in the library
+	filepath.Walk(manager.LibraryPath, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			t.Errorf("Unexpected file in library after cancel: %s", path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,102 @@
+package library
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"photoo/internal/models"
+)
+
+// PhotoColumns is the column list to select from photos for ScanPhotos.
+const PhotoColumns = "id, original_path, library_path, filename, hash, date_taken, date_taken_utc, utc_offset, camera_make, camera_model, lens_model, " +
+	"f_number, exposure_time, iso, focal_length, orientation, width, height, latitude, longitude, altitude, import_date, rating, title, description, " +
+	"(SELECT GROUP_CONCAT(keyword, char(31)) FROM photo_keywords WHERE photo_id = photos.id), favorite, " +
+	"(SELECT GROUP_CONCAT(name, char(31)) FROM photo_people WHERE photo_id = photos.id), media_type, duration, " +
+	"content_identifier, motion_type, motion_filename, alternate_filename"
+
+// listSeparator joins the keywords and people selected by PhotoColumns.
+const listSeparator = "\x1f"
+
+// GetPhoto returns a single photo.
+func (m *Manager) GetPhoto(id int64) (*models.Photo, error) {
+	p, err := scanPhoto(m.DB.QueryRow("SELECT "+PhotoColumns+" FROM photos WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("photo %d not found", id)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load photo %d: %w", id, err)
+	}
+	return &p, nil
+}
+
+// scanPhoto reads one row selected with PhotoColumns. NULL text and date
+// columns are returned as zero values.
+func scanPhoto(row rowScanner) (models.Photo, error) {
+	var p models.Photo
+	var originalPath, cameraMake, cameraModel, lensModel, title, description, keywords, people sql.NullString
+	var contentID, motionType, motionFilename, alternateFilename sql.NullString
+	var dateTaken, dateTakenUTC, importDate sql.NullTime
+	var utcOffset, iso sql.NullInt64
+	err := row.Scan(&p.ID, &originalPath, &p.LibraryPath, &p.Filename, &p.Hash, &dateTaken, &dateTakenUTC, &utcOffset, &cameraMake, &cameraModel, &lensModel,
+		&p.FNumber, &p.ExposureTime, &iso, &p.FocalLength, &p.Orientation, &p.Width, &p.Height, &p.Latitude, &p.Longitude, &p.Altitude, &importDate,
+		&p.Rating, &title, &description, &keywords, &p.Favorite, &people, &p.MediaType, &p.Duration,
+		&contentID, &motionType, &motionFilename, &alternateFilename)
+	p.OriginalPath = originalPath.String
+	p.CameraMake = cameraMake.String
+	p.CameraModel = cameraModel.String
+	p.LensModel = lensModel.String
+	p.Title = title.String
+	p.Description = description.String
+	p.Keywords = splitList(keywords)
+	p.People = splitList(people)
+	p.ContentIdentifier = contentID.String
+	p.MotionType = motionType.String
+	p.MotionFilename = motionFilename.String
+	p.AlternateFilename = alternateFilename.String
+	p.DateTaken = dateTaken.Time
+	p.DateTakenUTC = dateTakenUTC.Time
+	p.ImportDate = importDate.Time
+	if utcOffset.Valid {
+		v := int(utcOffset.Int64)
+		p.UTCOffset = &v
+	}
+	if iso.Valid {
+		v := int(iso.Int64)
+		p.ISO = &v
+	}
+	return p, err
+}
+
+// splitList splits a GROUP_CONCAT list selected by PhotoColumns, sorted.
+func splitList(list sql.NullString) []string {
+	if !list.Valid {
+		return nil
+	}
+	items := strings.Split(list.String, listSeparator)
+	sort.Strings(items)
+	return items
+}
+
+// ScanPhotos reads all rows selected with PhotoColumns and closes rows.
+func ScanPhotos(rows *sql.Rows) ([]models.Photo, error) {
+	defer rows.Close()
+
+	var photos []models.Photo
+	for rows.Next() {
+		p, err := scanPhoto(rows)
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, p)
+	}
+	return photos, rows.Err()
+}
+
+// sqlDateTime formats t for comparison against date_taken. Times are stored as
+// text starting with the wall-clock time, so comparing against the wall-clock
+// prefix orders them correctly regardless of the zone suffix.
+func sqlDateTime(t time.Time) string {
+	return t.Format("2006-01-02 15:04:05")
+}
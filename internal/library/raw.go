@@ -0,0 +1,85 @@
+package library
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"photoo/internal/models"
+)
+
+// Cameras set to RAW+JPEG write both files of a shot under the same base
+// name. The pair is one library item: the JPEG (or HEIC) is its primary file
+// and the raw file its alternate. Whichever file is imported second is
+// linked to the first, the same way the halves of a Live Photo are.
+
+// rawPartner is the other file of a RAW+JPEG pair already in the library.
+type rawPartner struct {
+	ID       int64
+	Filename string
+	Hash     string
+}
+
+// findRawPartner returns the JPEG of a raw file, or the raw file of a JPEG,
+// imported from the same folder under the same base name with the same
+// capture time. It returns nil if there is none.
+func findRawPartner(tx *sql.Tx, photo *models.Photo) (*rawPartner, error) {
+	if photo.MediaType != models.MediaPhoto || photo.OriginalPath == "" {
+		return nil, nil
+	}
+	raw := isRawFile(photo.Filename)
+	rows, err := tx.Query("SELECT id, filename, hash, original_path FROM photos WHERE date_taken_utc = ? AND media_type = ? AND alternate_filename IS NULL ORDER BY id",
+		photo.DateTakenUTC, models.MediaPhoto)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p rawPartner
+		var originalPath sql.NullString
+		if err := rows.Scan(&p.ID, &p.Filename, &p.Hash, &originalPath); err != nil {
+			return nil, err
+		}
+		if isRawFile(p.Filename) != raw && sameShot(originalPath.String, photo.OriginalPath) {
+			return &p, rows.Close()
+		}
+	}
+	return nil, rows.Err()
+}
+
+// sameShot reports whether two source paths differ only in their extension.
+func sameShot(a, b string) bool {
+	stem := func(p string) string { return strings.TrimSuffix(p, filepath.Ext(p)) }
+	return filepath.Dir(a) == filepath.Dir(b) && strings.EqualFold(filepath.Base(stem(a)), filepath.Base(stem(b)))
+}
+
+// attachRawFile moves the raw file of job into the library as the alternate
+// of jpegID and commits tx. The job reports the JPEG as its photo.
+func (m *Manager) attachRawFile(tx *sql.Tx, job *importJob, jpegID int64) error {
+	_, err := tx.Exec("UPDATE photos SET alternate_filename = ?, alternate_hash = ? WHERE id = ?", job.filename, job.hash, jpegID)
+	if err != nil {
+		return fmt.Errorf("failed to link raw file: %w", err)
+	}
+
+	if err := os.Rename(job.tempPath, job.libraryPath); err != nil {
+		return fmt.Errorf("%w: cannot move file into library: %w", ErrCopy, err)
+	}
+	job.tempPath = ""
+	syncDir(filepath.Dir(job.libraryPath))
+
+	if err := tx.Commit(); err != nil {
+		os.Remove(job.libraryPath)
+		return fmt.Errorf("failed to save photo to database: %w", err)
+	}
+
+	photo, err := m.GetPhoto(jpegID)
+	if err != nil {
+		return err
+	}
+	job.photo = photo
+	job.attached = true
+	return nil
+}
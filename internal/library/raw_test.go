@@ -0,0 +1,114 @@
+package library
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestRaw writes a raw file made of the EXIF block of a test JPEG, so
+// it has the JPEG's camera, capture time and thumbnail.
+func writeTestRaw(t *testing.T, path, jpegName string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("../../test_data/source_digital_camera", jpegName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The TIFF block follows "Exif\0\0" in the APP1 segment
+	var tiff []byte
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF && tiff == nil; {
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if data[i+1] == 0xE1 && string(data[i+4:i+10]) == "Exif\x00\x00" {
+			tiff = append([]byte{}, data[i+10:end]...)
+		}
+		i = end
+	}
+	if tiff == nil {
+		t.Fatalf("%s has no EXIF segment", jpegName)
+	}
+	// Trailing bytes give it a hash of its own
+	tiff = append(tiff, filepath.Base(path)...)
+	if err := os.WriteFile(path, tiff, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func copyTestPhoto(t *testing.T, name, dir string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("../../test_data/source_digital_camera", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRawJpegPairing(t *testing.T) {
+	manager := newTestManager(t)
+	srcDir := t.TempDir()
+
+	// Both files in one folder import become one item with the JPEG first
+	copyTestPhoto(t, "RIMG0018.JPG", srcDir)
+	writeTestRaw(t, filepath.Join(srcDir, "RIMG0018.CR2"), "RIMG0018.JPG")
+	progress, err := manager.ImportFolder(context.Background(), srcDir, ImportOptions{Workers: 2})
+	if err != nil || progress.Errors > 0 {
+		t.Fatalf("ImportFolder failed: %v, %+v", err, progress)
+	}
+	photos, _ := manager.SearchPhotos(PhotoFilter{})
+	if len(photos) != 1 {
+		t.Fatalf("Expected 1 item for the pair, got %d", len(photos))
+	}
+	pair := photos[0]
+	if !strings.HasSuffix(pair.Filename, ".JPG") || !strings.HasSuffix(pair.AlternateFilename, ".CR2") {
+		t.Errorf("Expected the JPEG as primary and the raw file as alternate, got %q and %q", pair.Filename, pair.AlternateFilename)
+	}
+	if _, err := os.Stat(filepath.Join(manager.LibraryPath, pair.AlternateFilename)); err != nil {
+		t.Errorf("Expected the raw file in the library: %v", err)
+	}
+	// The raw file is known to the library as well
+	if _, err := manager.ImportPhoto(filepath.Join(srcDir, "RIMG0018.CR2")); err == nil {
+		t.Error("Expected the raw file to be detected as a duplicate")
+	}
+
+	// Raw first: the JPEG takes over the raw file's item
+	rawDir := t.TempDir()
+	writeTestRaw(t, filepath.Join(rawDir, "RIMG0020.NEF"), "RIMG0020.JPG")
+	raw, err := importPhoto(manager, filepath.Join(rawDir, "RIMG0020.NEF"))
+	if err != nil || raw.CameraModel != "Caplio R5" {
+		t.Fatalf("Expected a standalone raw photo with EXIF, got %+v, %v", raw, err)
+	}
+	if err := manager.UpdateMetadata(raw.ID, "camera_model", "Edited"); err != nil {
+		t.Fatal(err)
+	}
+	copyTestPhoto(t, "RIMG0020.JPG", rawDir)
+	jpeg, err := importPhoto(manager, filepath.Join(rawDir, "RIMG0020.JPG"))
+	if err != nil || jpeg.AlternateFilename != raw.Filename {
+		t.Fatalf("Expected the JPEG to take over the raw file, got %+v, %v", jpeg, err)
+	}
+	if _, err := manager.GetPhoto(raw.ID); err == nil {
+		t.Error("Expected the raw item to be gone")
+	}
+	// The raw item's edits stay in the history of the pair
+	if history, _ := manager.GetPhotoHistory(jpeg.ID); len(history) != 1 || history[0].NewValue != "Edited" {
+		t.Errorf("Expected the raw item's edit in the pair's history, got %+v", history)
+	}
+
+	// A raw file without a JPEG stays on its own and shows its preview
+	writeTestRaw(t, filepath.Join(rawDir, "RIMG0024.DNG"), "RIMG0024.JPG")
+	single, err := importPhoto(manager, filepath.Join(rawDir, "RIMG0024.DNG"))
+	if err != nil || single.AlternateFilename != "" {
+		t.Fatalf("Expected a standalone raw photo, got %+v, %v", single, err)
+	}
+	handler := NewThumbnailHandler(manager.LibraryPath)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/thumbnail/"+single.Filename, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("Expected a thumbnail from the embedded preview, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
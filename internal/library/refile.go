@@ -0,0 +1,241 @@
+package library
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"photoo/internal/exif"
+)
+
+// companionColumns are the photos columns naming files kept next to the
+// photo under the same name: its Live Photo video and its raw or JPEG
+// alternate. They are only ever set by the library, never from user input.
+var companionColumns = []string{"motion_filename", "alternate_filename"}
+
+// refile moves a photo whose capture date changed, along with its
+// companions, into the folder and under the name the naming template gives
+// that date. The moves are recorded in metadata_history as changes of the
+// batch, so undoing the batch moves everything back.
+func (m *Manager) refile(tx *sql.Tx, moves *fileMoves, batchID, photoID int64, date time.Time) error {
+	var current string
+	var cameraMake, cameraModel, originalPath sql.NullString
+	err := tx.QueryRow("SELECT filename, camera_make, camera_model, original_path FROM photos WHERE id = ?", photoID).
+		Scan(&current, &cameraMake, &cameraModel, &originalPath)
+	if err != nil {
+		return fmt.Errorf("failed to load photo %d: %w", photoID, err)
+	}
+
+	subDir, base := m.namingTemplate().location(namingFields{
+		date:         date,
+		cameraMake:   cameraMake.String,
+		cameraModel:  cameraModel.String,
+		originalPath: originalPath.String,
+	})
+	if atLocation(current, subDir, base) {
+		return nil
+	}
+	filename, err := m.reserveLocation(moves, subDir, base, filepath.Ext(current))
+	if err != nil {
+		return err
+	}
+	return m.relocate(tx, moves, batchID, photoID, current, filename)
+}
+
+// relocate moves a photo from current to filename, and its companions next
+// to it. The moves are recorded in metadata_history as changes of batchID.
+func (m *Manager) relocate(tx *sql.Tx, moves *fileMoves, batchID, photoID int64, current, filename string) error {
+	if err := logHistory(tx, batchID, photoID, "filename", current, filename); err != nil {
+		return err
+	}
+	if err := m.setFilename(tx, moves, photoID, filename); err != nil {
+		return err
+	}
+
+	for _, column := range companionColumns {
+		var companion sql.NullString
+		if err := tx.QueryRow("SELECT "+column+" FROM photos WHERE id = ?", photoID).Scan(&companion); err != nil {
+			return fmt.Errorf("failed to load photo %d: %w", photoID, err)
+		}
+		target := companionFilename(companion.String, filename)
+		if companion.String == "" || target == companion.String {
+			continue
+		}
+		if err := logHistory(tx, batchID, photoID, column, companion.String, target); err != nil {
+			return err
+		}
+		if err := m.setCompanion(tx, moves, photoID, column, companion.String, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// companionFilename returns where a companion file goes when its photo is at
+// filename: next to it, with its own extension.
+func companionFilename(companion, filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + filepath.Ext(companion)
+}
+
+// setCompanion moves the file stored in column of photoID, one of
+// companionColumns, from current to target (both relative to the library).
+// A companion missing on disk is only renamed in the database.
+func (m *Manager) setCompanion(tx *sql.Tx, moves *fileMoves, photoID int64, column, current, target string) error {
+	if target == current {
+		return nil
+	}
+	targetPath := filepath.Join(m.LibraryPath, target)
+	if err := m.claimPath(targetPath, moves); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE photos SET "+column+" = ? WHERE id = ?", target, photoID); err != nil {
+		return fmt.Errorf("failed to update database: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return fmt.Errorf("failed to create folder for %s: %w", target, err)
+	}
+	currentPath := filepath.Join(m.LibraryPath, current)
+	if err := moves.move(currentPath, targetPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to move %s to %s: %w", current, target, err)
+	}
+	removeEmptyDirs(filepath.Dir(currentPath), m.LibraryPath)
+	return nil
+}
+
+// setFilename moves a photo's file, its XMP sidecar and its cached thumbnail
+// to filename (relative to the library) and updates filename and
+// library_path. The target must not exist.
+func (m *Manager) setFilename(tx *sql.Tx, moves *fileMoves, photoID int64, filename string) error {
+	var current, currentPath string
+	if err := tx.QueryRow("SELECT filename, library_path FROM photos WHERE id = ?", photoID).Scan(&current, &currentPath); err != nil {
+		return fmt.Errorf("failed to load photo %d: %w", photoID, err)
+	}
+	if current == filename {
+		return nil
+	}
+
+	target := filepath.Join(m.LibraryPath, filename)
+	if err := m.claimPath(target, moves); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE photos SET filename = ?, library_path = ? WHERE id = ?", filename, target, photoID); err != nil {
+		return fmt.Errorf("failed to update database: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create folder for %s: %w", filename, err)
+	}
+	if err := moves.move(currentPath, target); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", current, filename, err)
+	}
+	if _, err := os.Stat(exif.SidecarPath(currentPath)); err == nil {
+		if err := moves.move(exif.SidecarPath(currentPath), exif.SidecarPath(target)); err != nil {
+			return fmt.Errorf("failed to move sidecar of %s: %w", current, err)
+		}
+	}
+
+	// The cache regenerates missing thumbnails, so only a stale one under the
+	// new name would be a problem.
+	oldThumb := thumbnailCachePath(m.LibraryPath, current)
+	newThumb := thumbnailCachePath(m.LibraryPath, filename)
+	os.Remove(newThumb)
+	if _, err := os.Stat(oldThumb); err == nil {
+		if err := moves.move(oldThumb, newThumb); err != nil {
+			os.Remove(oldThumb)
+		}
+	}
+
+	removeEmptyDirs(filepath.Dir(currentPath), m.LibraryPath)
+	return nil
+}
+
+// reserveLocation picks a free name for subDir/base in the library and
+// reserves it until moves is finished. It returns the library-relative
+// filename.
+func (m *Manager) reserveLocation(moves *fileMoves, subDir, base, ext string) (string, error) {
+	targetDir := filepath.Join(m.LibraryPath, subDir)
+	name, err := m.reserveFilename(targetDir, base, ext)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine unique filename: %w", err)
+	}
+	moves.reserved = append(moves.reserved, filepath.Join(targetDir, name))
+	return filepath.Join(subDir, name), nil
+}
+
+// claimPath reserves path for a file being moved into the library. It fails
+// if the path is taken by an existing file or an ongoing import.
+func (m *Manager) claimPath(path string, moves *fileMoves) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reservedByUs := false
+	for _, p := range moves.reserved {
+		reservedByUs = reservedByUs || p == path
+	}
+	if _, taken := m.reserved[path]; taken && !reservedByUs {
+		return fmt.Errorf("cannot move photo to %s: the name is in use", path)
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("cannot move photo to %s: a file already exists", path)
+	}
+	if !reservedByUs {
+		m.reserved[path] = struct{}{}
+		moves.reserved = append(moves.reserved, path)
+	}
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents up to (not including) root as
+// long as they are empty.
+func removeEmptyDirs(dir, root string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// fileMoves tracks the files moved during a metadata transaction, so they
+// can be moved back if it is rolled back, and the library paths reserved for
+// them.
+type fileMoves struct {
+	m        *Manager
+	moved    [][2]string
+	reserved []string
+}
+
+func (f *fileMoves) move(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	f.moved = append(f.moved, [2]string{from, to})
+	return nil
+}
+
+// finish releases the reserved paths. Unless the transaction was committed,
+// all moves are reverted first, newest first.
+func (f *fileMoves) finish(committed bool) {
+	if !committed {
+		for i := len(f.moved) - 1; i >= 0; i-- {
+			from, to := f.moved[i][0], f.moved[i][1]
+			os.MkdirAll(filepath.Dir(from), 0755)
+			if err := os.Rename(to, from); err != nil {
+				fmt.Printf("[BACKEND] Failed to move %s back to %s: %v\n", to, from, err)
+				continue
+			}
+			removeEmptyDirs(filepath.Dir(to), f.m.LibraryPath)
+		}
+	}
+	f.moved = nil
+
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+	for _, p := range f.reserved {
+		delete(f.m.reserved, p)
+	}
+	f.reserved = nil
+}
@@ -0,0 +1,134 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"photoo/internal/models"
+)
+
+// ReorganizeMove is a photo that a reorganization moves, or would move in a
+// dry run.
+type ReorganizeMove struct {
+	PhotoID int64  `json:"photo_id"`
+	From    string `json:"from"`            // library-relative filename
+	To      string `json:"to"`              // library-relative filename
+	Error   string `json:"error,omitempty"` // why the photo could not be moved
+}
+
+// ReorganizeProgress is a snapshot of a running reorganization.
+type ReorganizeProgress struct {
+	Current  int
+	Total    int
+	Moved    int
+	Errors   int
+	LastPath string
+}
+
+// ReorganizeLibrary moves every photo to the place template gives it, along
+// with its sidecar, Live Photo video and raw file. Unless dryRun is set,
+// template is saved as the naming template first, so photos imported in the
+// meantime follow it too. A dry run returns the moves without making them.
+// Photos that cannot be moved are reported in their move and skipped;
+// cancelling ctx stops after the current photo. The moves made are one edit
+// batch, so undo moves the photos back; the naming template stays.
+func (m *Manager) ReorganizeLibrary(ctx context.Context, template string, dryRun bool, onProgress func(ReorganizeProgress)) ([]ReorganizeMove, error) {
+	t, err := parseNamingTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		if err := m.SetNamingTemplate(template); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := m.DB.Query("SELECT " + PhotoColumns + " FROM photos ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load photos: %w", err)
+	}
+	photos, err := ScanPhotos(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load photos: %w", err)
+	}
+
+	// A dry run reserves the names it picks, so that two photos are never
+	// planned onto the same name, and releases them all at the end.
+	planned := &fileMoves{m: m}
+	defer planned.finish(false)
+
+	var moves []ReorganizeMove
+	var batchID int64 // created with the first move
+	progress := ReorganizeProgress{Total: len(photos)}
+	for i := range photos {
+		if ctx.Err() != nil {
+			return moves, ctx.Err()
+		}
+		p := &photos[i]
+		subDir, base := t.location(namingFields{
+			date:         p.DateTaken,
+			cameraMake:   p.CameraMake,
+			cameraModel:  p.CameraModel,
+			originalPath: p.OriginalPath,
+		})
+
+		progress.Current++
+		progress.LastPath = p.Filename
+		if !atLocation(p.Filename, subDir, base) {
+			move := ReorganizeMove{PhotoID: p.ID, From: p.Filename}
+			if dryRun {
+				move.To, err = m.reserveLocation(planned, subDir, base, filepath.Ext(p.Filename))
+			} else {
+				move.To, batchID, err = m.movePhoto(p, subDir, base, batchID)
+			}
+			if err != nil {
+				move.Error = err.Error()
+				progress.Errors++
+				fmt.Printf("[BACKEND] Failed to reorganize %s: %v\n", p.Filename, err)
+			} else {
+				progress.Moved++
+			}
+			moves = append(moves, move)
+		}
+		if onProgress != nil {
+			onProgress(progress)
+		}
+	}
+	return moves, nil
+}
+
+// movePhoto moves p to subDir/base and returns its new filename. Its Live
+// Photo video and raw file are renamed to match. The moves are recorded in
+// the edit batch batchID, which is created first if it is zero; the batch is
+// returned.
+func (m *Manager) movePhoto(p *models.Photo, subDir, base string, batchID int64) (string, int64, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return "", batchID, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+	moves := &fileMoves{m: m}
+	committed := false
+	defer func() { moves.finish(committed) }()
+
+	batch := batchID
+	if batch == 0 {
+		if batch, err = newEditBatch(tx, "Reorganize library"); err != nil {
+			return "", batchID, err
+		}
+	}
+	filename, err := m.reserveLocation(moves, subDir, base, filepath.Ext(p.Filename))
+	if err != nil {
+		return "", batchID, err
+	}
+	if err := m.relocate(tx, moves, batch, p.ID, p.Filename, filename); err != nil {
+		return "", batchID, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", batchID, fmt.Errorf("failed to commit move: %w", err)
+	}
+	committed = true
+	return filename, batch, nil
+}
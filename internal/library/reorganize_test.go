@@ -0,0 +1,129 @@
+package library
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReorganizeLibrary(t *testing.T) {
+	manager := newTestManager(t)
+	srcDir := t.TempDir()
+
+	still := importStillWithContentID(t, manager, "IMG_0001.JPG", "LIVE-1")
+	writeTestVideo(t, filepath.Join(srcDir, "IMG_0001.MOV"), "LIVE-1")
+	if _, err := manager.ImportPhoto(filepath.Join(srcDir, "IMG_0001.MOV")); err != nil {
+		t.Fatal(err)
+	}
+	still, _ = manager.GetPhoto(still.ID)
+	other, err := importPhoto(manager, "../../test_data/source_digital_camera/RIMG0020.JPG")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const template = "{camera}/{year}-{month}/{time}{counter}.{ext}"
+	if _, err := manager.ReorganizeLibrary(context.Background(), "{date}", false, nil); err == nil {
+		t.Error("Expected an invalid template to be rejected")
+	}
+
+	// A dry run plans both moves without touching anything
+	plan, err := manager.ReorganizeLibrary(context.Background(), template, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 2 || plan[0].From != still.Filename || !strings.HasPrefix(filepath.ToSlash(plan[0].To), "Caplio R5/") {
+		t.Fatalf("Unexpected plan %+v", plan)
+	}
+	if _, err := os.Stat(filepath.Join(manager.LibraryPath, still.Filename)); err != nil {
+		t.Errorf("Expected the dry run to leave files in place: %v", err)
+	}
+	if manager.NamingTemplate() != DefaultNamingTemplate {
+		t.Errorf("Expected the dry run not to save the template, got %s", manager.NamingTemplate())
+	}
+
+	var last ReorganizeProgress
+	moves, err := manager.ReorganizeLibrary(context.Background(), template, false, func(p ReorganizeProgress) { last = p })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moves) != 2 || moves[0].To != plan[0].To || moves[1].To != plan[1].To || last.Moved != 2 || last.Errors != 0 {
+		t.Fatalf("Expected the planned moves, got %+v, %+v", moves, last)
+	}
+	moved, _ := manager.GetPhoto(still.ID)
+	if moved.Filename != moves[0].To || moved.LibraryPath != filepath.Join(manager.LibraryPath, moves[0].To) {
+		t.Errorf("Expected the photo at %s, got %+v", moves[0].To, moved)
+	}
+	if want := strings.TrimSuffix(moved.Filename, ".JPG") + ".MOV"; moved.MotionFilename != want {
+		t.Errorf("Expected the Live Photo video at %s, got %s", want, moved.MotionFilename)
+	}
+	for _, f := range []string{moved.Filename, moved.MotionFilename} {
+		if _, err := os.Stat(filepath.Join(manager.LibraryPath, f)); err != nil {
+			t.Errorf("Expected %s in the library: %v", f, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(manager.LibraryPath, filepath.Dir(other.Filename))); !os.IsNotExist(err) {
+		t.Errorf("Expected the old folders to be removed, got %v", err)
+	}
+
+	// The template is kept, and new imports follow it
+	reopened, err := NewManager(manager.LibraryPath, manager.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.NamingTemplate() != template {
+		t.Errorf("Expected the template to be saved, got %s", reopened.NamingTemplate())
+	}
+	photo, err := importPhoto(reopened, "../../test_data/source_digital_camera/RIMG0024.JPG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(filepath.ToSlash(photo.Filename), "Caplio R5/") {
+		t.Errorf("Expected the import to follow the template, got %s", photo.Filename)
+	}
+
+	// Everything is in place now
+	if moves, err := reopened.ReorganizeLibrary(context.Background(), template, true, nil); err != nil || len(moves) != 0 {
+		t.Errorf("Expected nothing to move, got %+v, %v", moves, err)
+	}
+
+	// One undo moves both photos and the video back
+	if batch, err := manager.Undo(); err != nil || batch == nil || batch.Description != "Reorganize library" {
+		t.Fatalf("Expected to undo the reorganization, got %+v, %v", batch, err)
+	}
+	restored, _ := manager.GetPhoto(still.ID)
+	if restored.Filename != still.Filename || restored.MotionFilename != still.MotionFilename {
+		t.Errorf("Expected the photo back at %s, got %+v", still.Filename, restored)
+	}
+	for _, f := range []string{restored.Filename, restored.MotionFilename} {
+		if _, err := os.Stat(filepath.Join(manager.LibraryPath, f)); err != nil {
+			t.Errorf("Expected %s back in the library: %v", f, err)
+		}
+	}
+	if p, _ := manager.GetPhoto(other.ID); p.Filename != other.Filename {
+		t.Errorf("Expected %s back, got %s", other.Filename, p.Filename)
+	}
+}
+
+func TestReorganizeKeepsPlacedPhotos(t *testing.T) {
+	manager := newTestManager(t)
+	dir := t.TempDir()
+	// Original names ending in digits look like collision counters
+	for _, name := range []string{"IMG_1234.JPG", "IMG_1234_1.JPG"} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, append(mustRead(t, "../../test_data/source_digital_camera/RIMG0018.JPG"), name...), 0644)
+		if _, err := importPhoto(manager, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const template = "{year}/{original}{counter}.{ext}"
+	moves, err := manager.ReorganizeLibrary(context.Background(), template, false, nil)
+	if err != nil || len(moves) != 2 {
+		t.Fatalf("Expected both photos to move, got %+v, %v", moves, err)
+	}
+	if moves, err := manager.ReorganizeLibrary(context.Background(), template, false, nil); err != nil || len(moves) != 0 {
+		t.Errorf("Expected nothing to move the second time, got %+v, %v", moves, err)
+	}
+}
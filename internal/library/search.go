@@ -0,0 +1,208 @@
+package library
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"photoo/internal/models"
+)
+
+// Sort orders accepted by PhotoFilter.Sort
+const (
+	SortDateDesc   = "date_desc"
+	SortDateAsc    = "date_asc"
+	SortFilename   = "filename"
+	SortImportDate = "import_date"
+)
+
+var sortClauses = map[string]string{
+	SortDateDesc:   "date_taken DESC, id DESC",
+	SortDateAsc:    "date_taken ASC, id ASC",
+	SortFilename:   "filename ASC",
+	SortImportDate: "import_date DESC, id DESC",
+}
+
+// BoundingBox is a latitude/longitude rectangle, edges inclusive.
+type BoundingBox struct {
+	MinLat float64 `json:"min_lat"`
+	MinLon float64 `json:"min_lon"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLon float64 `json:"max_lon"`
+}
+
+// Range bounds a numeric field, both ends inclusive. A nil end is open.
+type Range struct {
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// PhotoFilter selects photos for SearchPhotos. Zero values do not filter.
+type PhotoFilter struct {
+	// DateFrom and DateTo bound the capture date by wall-clock time;
+	// DateFrom is inclusive, DateTo exclusive.
+	DateFrom     *time.Time   `json:"date_from,omitempty"`
+	DateTo       *time.Time   `json:"date_to,omitempty"`
+	CameraMake   string       `json:"camera_make,omitempty"`  // case-insensitive exact match
+	CameraModel  string       `json:"camera_model,omitempty"` // case-insensitive exact match
+	LensModel    string       `json:"lens_model,omitempty"`   // substring, case-insensitive
+	FNumber      *Range       `json:"f_number,omitempty"`
+	ExposureTime *Range       `json:"exposure_time,omitempty"` // seconds
+	ISO          *Range       `json:"iso,omitempty"`
+	FocalLength  *Range       `json:"focal_length,omitempty"` // millimetres
+	Rating       *Range       `json:"rating,omitempty"`
+	Keywords     []string     `json:"keywords,omitempty"` // photos with all of them, case-insensitive
+	People       []string     `json:"people,omitempty"`   // photos with all of them, case-insensitive
+	Favorite     *bool        `json:"favorite,omitempty"`
+	MediaType    string       `json:"media_type,omitempty"` // models.MediaPhoto or models.MediaVideo
+	HasGPS       *bool        `json:"has_gps,omitempty"`
+	BoundingBox  *BoundingBox `json:"bounding_box,omitempty"`
+	Filename     string       `json:"filename,omitempty"` // substring of the library filename
+	SessionID    int64        `json:"session_id,omitempty"`
+	Sort         string       `json:"sort,omitempty"` // defaults to SortDateDesc
+	Offset       int          `json:"offset"`
+	Limit        int          `json:"limit"` // 0 means no limit
+}
+
+// SearchPhotos returns the photos matching filter.
+func (m *Manager) SearchPhotos(filter PhotoFilter) ([]models.Photo, error) {
+	query, args, err := buildSearchQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search photos: %w", err)
+	}
+	return ScanPhotos(rows)
+}
+
+// buildSearchQuery turns filter into a parameterized SELECT. Only constant
+// SQL fragments are concatenated; all user values are bound as arguments.
+func buildSearchQuery(filter PhotoFilter) (string, []interface{}, error) {
+	var where []string
+	var args []interface{}
+
+	if filter.DateFrom != nil {
+		where = append(where, "date_taken >= ?")
+		args = append(args, sqlDateTime(*filter.DateFrom))
+	}
+	if filter.DateTo != nil {
+		where = append(where, "date_taken < ?")
+		args = append(args, sqlDateTime(*filter.DateTo))
+	}
+	if filter.CameraMake != "" {
+		where = append(where, "camera_make = ? COLLATE NOCASE")
+		args = append(args, filter.CameraMake)
+	}
+	if filter.CameraModel != "" {
+		where = append(where, "camera_model = ? COLLATE NOCASE")
+		args = append(args, filter.CameraModel)
+	}
+	if filter.LensModel != "" {
+		where = append(where, `lens_model LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(filter.LensModel)+"%")
+	}
+	for _, r := range []struct {
+		column string
+		rng    *Range
+	}{
+		{"f_number", filter.FNumber},
+		{"exposure_time", filter.ExposureTime},
+		{"iso", filter.ISO},
+		{"focal_length", filter.FocalLength},
+		{"rating", filter.Rating},
+	} {
+		if r.rng == nil {
+			continue
+		}
+		if r.rng.Min != nil && r.rng.Max != nil && *r.rng.Min > *r.rng.Max {
+			return "", nil, fmt.Errorf("invalid %s range: %v exceeds %v", r.column, *r.rng.Min, *r.rng.Max)
+		}
+		if r.rng.Min != nil {
+			where = append(where, r.column+" >= ?")
+			args = append(args, *r.rng.Min)
+		}
+		if r.rng.Max != nil {
+			where = append(where, r.column+" <= ?")
+			args = append(args, *r.rng.Max)
+		}
+	}
+	for _, k := range filter.Keywords {
+		where = append(where, "id IN (SELECT photo_id FROM photo_keywords WHERE keyword = ? COLLATE NOCASE)")
+		args = append(args, k)
+	}
+	for _, name := range filter.People {
+		where = append(where, "id IN (SELECT photo_id FROM photo_people WHERE name = ? COLLATE NOCASE)")
+		args = append(args, name)
+	}
+	if filter.Favorite != nil {
+		where = append(where, "favorite = ?")
+		args = append(args, *filter.Favorite)
+	}
+	if filter.MediaType != "" {
+		where = append(where, "media_type = ?")
+		args = append(args, filter.MediaType)
+	}
+	if filter.HasGPS != nil {
+		if *filter.HasGPS {
+			where = append(where, "latitude IS NOT NULL AND longitude IS NOT NULL")
+		} else {
+			where = append(where, "(latitude IS NULL OR longitude IS NULL)")
+		}
+	}
+	if bb := filter.BoundingBox; bb != nil {
+		if bb.MinLat > bb.MaxLat {
+			return "", nil, fmt.Errorf("invalid bounding box: min latitude %v exceeds max latitude %v", bb.MinLat, bb.MaxLat)
+		}
+		where = append(where, "latitude BETWEEN ? AND ?")
+		args = append(args, bb.MinLat, bb.MaxLat)
+		if bb.MinLon <= bb.MaxLon {
+			where = append(where, "longitude BETWEEN ? AND ?")
+			args = append(args, bb.MinLon, bb.MaxLon)
+		} else {
+			// Box crosses the antimeridian
+			where = append(where, "(longitude >= ? OR longitude <= ?)")
+			args = append(args, bb.MinLon, bb.MaxLon)
+		}
+	}
+	if filter.Filename != "" {
+		where = append(where, `filename LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(filter.Filename)+"%")
+	}
+	if filter.SessionID != 0 {
+		where = append(where, "id IN (SELECT photo_id FROM import_session_items WHERE session_id = ?)")
+		args = append(args, filter.SessionID)
+	}
+
+	sort := filter.Sort
+	if sort == "" {
+		sort = SortDateDesc
+	}
+	orderBy, ok := sortClauses[sort]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown sort order %q", filter.Sort)
+	}
+
+	query := "SELECT " + PhotoColumns + " FROM photos"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + orderBy
+
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	} else if filter.Offset > 0 {
+		query += " LIMIT -1 OFFSET ?"
+		args = append(args, filter.Offset)
+	}
+	return query, args, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s using backslash.
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
@@ -0,0 +1,156 @@
+package library
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSearchPhotos(t *testing.T) {
+	manager := newTestManager(t)
+
+	insert := func(name, camera string, taken time.Time, lat, lon *float64) int64 {
+		res, err := manager.DB.Exec(
+			"INSERT INTO photos (original_path, library_path, filename, hash, date_taken, camera_model, latitude, longitude) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			"orig/"+name, "lib/"+name, name, "hash-"+name, taken, camera, lat, lon,
+		)
+		if err != nil {
+			t.Fatalf("Failed to insert %s: %v", name, err)
+		}
+		id, _ := res.LastInsertId()
+		return id
+	}
+	f := func(v float64) *float64 { return &v }
+
+	berlin := insert("2023/06/10/berlin.jpg", "Pixel 7", time.Date(2023, 6, 10, 14, 0, 0, 0, time.Local), f(52.52), f(13.40))
+	munich := insert("2023/07/01/munich.jpg", "Pixel 7", time.Date(2023, 7, 1, 9, 0, 0, 0, time.Local), f(48.14), f(11.58))
+	insert("2023/09/01/late.jpg", "Pixel 7", time.Date(2023, 9, 1, 0, 0, 0, 0, time.Local), f(52.50), f(13.41))
+	noGPS := insert("2023/06/12/IMG_100.jpg", "iPhone 12", time.Date(2023, 6, 12, 8, 0, 0, 0, time.Local), nil, nil)
+
+	testCases := []struct {
+		query    string
+		expected []int64
+	}{
+		{`camera:"pixel 7" date:2023-06..2023-08 near:52.52,13.40,20km`, []int64{berlin}},
+		{`camera:"Pixel 7" date:2023-06..2023-08`, []int64{munich, berlin}},
+		{`date:2023-06 sort:date_asc`, []int64{berlin, noGPS}},
+		{`gps:no`, []int64{noGPS}},
+		{`IMG_`, []int64{noGPS}},
+		{`bbox:48,11,49,12`, []int64{munich}},
+		{`date:..2023-06-11`, []int64{berlin}},
+	}
+
+	for _, tc := range testCases {
+		filter, err := ParseQuery(tc.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) failed: %v", tc.query, err)
+			continue
+		}
+		photos, err := manager.SearchPhotos(filter)
+		if err != nil {
+			t.Errorf("SearchPhotos(%q) failed: %v", tc.query, err)
+			continue
+		}
+		var ids []int64
+		for _, p := range photos {
+			ids = append(ids, p.ID)
+		}
+		if len(ids) != len(tc.expected) {
+			t.Errorf("Query %q: expected %v, got %v", tc.query, tc.expected, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tc.expected[i] {
+				t.Errorf("Query %q: expected %v, got %v", tc.query, tc.expected, ids)
+				break
+			}
+		}
+	}
+
+	// Exposure details
+	if _, err := manager.DB.Exec("UPDATE photos SET camera_make = 'Google', lens_model = 'Pixel 7 back camera 6.81mm f/1.85', f_number = 1.85, iso = 400, focal_length = 6.81, exposure_time = 0.004 WHERE id = ?", berlin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.DB.Exec("UPDATE photos SET camera_make = 'Google', f_number = 2.2, iso = 50, exposure_time = 0.01 WHERE id = ?", munich); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ratings and keywords
+	manager.DB.Exec("UPDATE photos SET rating = 5 WHERE id = ?", berlin)
+	manager.DB.Exec("UPDATE photos SET rating = 3 WHERE id = ?", munich)
+	manager.DB.Exec("INSERT INTO photo_keywords (photo_id, keyword) VALUES (?, 'Travel'), (?, 'city'), (?, 'travel')", berlin, berlin, munich)
+	for query, expected := range map[string][]int64{
+		`make:google`:           {munich, berlin},
+		`lens:"BACK CAMERA"`:    {berlin},
+		`f:..2`:                 {berlin},
+		`aperture:f/2.2`:        {munich},
+		`iso:100..`:             {berlin},
+		`focal:6..7mm`:          {berlin},
+		`exposure:1/500..1/200`: {berlin},
+		`make:Google iso:..100`: {munich},
+		`rating:4..`:            {berlin},
+		`keyword:TRAVEL`:        {munich, berlin},
+		`tag:travel tag:city`:   {berlin},
+	} {
+		filter, err := ParseQuery(query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) failed: %v", query, err)
+			continue
+		}
+		photos, err := manager.SearchPhotos(filter)
+		if err != nil {
+			t.Errorf("SearchPhotos(%q) failed: %v", query, err)
+			continue
+		}
+		if len(photos) != len(expected) {
+			t.Errorf("Query %q: expected %v, got %d photos", query, expected, len(photos))
+			continue
+		}
+		for i := range photos {
+			if photos[i].ID != expected[i] {
+				t.Errorf("Query %q: expected %v, got photo %d at %d", query, expected, photos[i].ID, i)
+			}
+		}
+	}
+	photos, err := manager.SearchPhotos(PhotoFilter{ISO: &Range{Min: f(400), Max: f(400)}})
+	if err != nil || len(photos) != 1 || photos[0].LensModel == "" || *photos[0].ISO != 400 || *photos[0].FNumber != 1.85 {
+		t.Errorf("Expected exposure details to be returned, got %+v, %v", photos, err)
+	}
+	if len(photos) == 1 && (photos[0].Rating != 5 || len(photos[0].Keywords) != 2) {
+		t.Errorf("Expected rating and keywords to be returned, got %d %v", photos[0].Rating, photos[0].Keywords)
+	}
+
+	// Paging applies after filtering
+	photos, err = manager.SearchPhotos(PhotoFilter{CameraModel: "Pixel 7", Offset: 1, Limit: 1})
+	if err != nil || len(photos) != 1 || photos[0].ID != munich {
+		t.Errorf("Expected second Pixel 7 photo on page 2, got %+v, %v", photos, err)
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, q := range []string{
+		`camera:"Pixel 7`,
+		`date:2023-13`,
+		`date:2023-08..2023-06`,
+		`gps:maybe`,
+		`near:52.5`,
+		`sort:random`,
+		`color:red`,
+		`iso:abc`,
+		`exposure:1/0`,
+		`f:..`,
+	} {
+		if _, err := ParseQuery(q); err == nil {
+			t.Errorf("Expected ParseQuery(%q) to fail", q)
+		}
+	}
+}
+
+func TestSearchEscapesLikeWildcards(t *testing.T) {
+	query, args, err := buildSearchQuery(PhotoFilter{Filename: "100%_done"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[0] != `%100\%\_done%` {
+		t.Errorf("Unexpected LIKE argument %q in %s", args[0], query)
+	}
+}
@@ -0,0 +1,368 @@
+package library
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"photoo/internal/models"
+)
+
+// ParseQuery parses the textual search syntax into a PhotoFilter. A query is
+// a list of key:value terms; values containing spaces are double-quoted.
+//
+//	camera:"Pixel 7"            camera model
+//	make:Canon                  camera manufacturer
+//	lens:50mm                   lens model substring
+//	f:1.4..2.8                  aperture; iso:, focal: (mm) and
+//	                            exposure: (seconds, 1/250 allowed) work alike,
+//	                            either side of ".." may be omitted
+//	rating:4..                  star rating, -1 (rejected) to 5
+//	keyword:beach               keyword; repeat to require several
+//	person:"Jane Doe"           person tagged in the photo; repeatable
+//	favorite:yes / favorite:no  starred or not
+//	type:video / type:photo     videos or still images
+//	date:2023-06..2023-08       capture date; bounds are a year, month or day,
+//	                            either side of ".." may be omitted
+//	gps:yes / gps:no            with or without location
+//	near:52.52,13.40,10km       within a radius (default 10km) of a point
+//	bbox:minLat,minLon,maxLat,maxLon
+//	file:IMG_                   filename substring
+//	session:12                  photos from an import session
+//	sort:date_asc               date_desc, date_asc, filename, import_date
+//
+// Terms without a key are matched against the filename.
+func ParseQuery(query string) (PhotoFilter, error) {
+	var filter PhotoFilter
+
+	terms, err := splitQuery(query)
+	if err != nil {
+		return filter, err
+	}
+
+	var words []string
+	for _, term := range terms {
+		key, value, found := strings.Cut(term, ":")
+		if !found {
+			words = append(words, term)
+			continue
+		}
+		value = unquote(value)
+
+		switch strings.ToLower(key) {
+		case "camera":
+			filter.CameraModel = value
+		case "make":
+			filter.CameraMake = value
+		case "lens":
+			filter.LensModel = value
+		case "f", "aperture":
+			rng, err := parseRange(strings.TrimPrefix(strings.ToLower(value), "f/"))
+			if err != nil {
+				return filter, fmt.Errorf("%s: %w", key, err)
+			}
+			filter.FNumber = rng
+		case "iso":
+			rng, err := parseRange(value)
+			if err != nil {
+				return filter, fmt.Errorf("iso: %w", err)
+			}
+			filter.ISO = rng
+		case "focal":
+			rng, err := parseRange(strings.TrimSuffix(strings.ToLower(value), "mm"))
+			if err != nil {
+				return filter, fmt.Errorf("focal: %w", err)
+			}
+			filter.FocalLength = rng
+		case "exposure", "shutter":
+			rng, err := parseRange(value)
+			if err != nil {
+				return filter, fmt.Errorf("%s: %w", key, err)
+			}
+			filter.ExposureTime = rng
+		case "rating", "stars":
+			rng, err := parseRange(value)
+			if err != nil {
+				return filter, fmt.Errorf("%s: %w", key, err)
+			}
+			filter.Rating = rng
+		case "keyword", "tag":
+			filter.Keywords = append(filter.Keywords, value)
+		case "person", "people":
+			filter.People = append(filter.People, value)
+		case "favorite", "fav":
+			fav, err := parseYesNo(value)
+			if err != nil {
+				return filter, fmt.Errorf("%s: %w", key, err)
+			}
+			filter.Favorite = &fav
+		case "type":
+			switch strings.ToLower(value) {
+			case "video", "videos":
+				filter.MediaType = models.MediaVideo
+			case "photo", "photos":
+				filter.MediaType = models.MediaPhoto
+			default:
+				return filter, fmt.Errorf("type: expected photo or video, got %q", value)
+			}
+		case "date":
+			from, to, err := parseDateRange(value)
+			if err != nil {
+				return filter, err
+			}
+			filter.DateFrom, filter.DateTo = from, to
+		case "gps":
+			has, err := parseYesNo(value)
+			if err != nil {
+				return filter, fmt.Errorf("gps: %w", err)
+			}
+			filter.HasGPS = &has
+		case "near":
+			bb, err := parseNear(value)
+			if err != nil {
+				return filter, err
+			}
+			filter.BoundingBox = bb
+		case "bbox":
+			nums, err := parseFloats(value, 4)
+			if err != nil {
+				return filter, fmt.Errorf("bbox: %w", err)
+			}
+			filter.BoundingBox = &BoundingBox{MinLat: nums[0], MinLon: nums[1], MaxLat: nums[2], MaxLon: nums[3]}
+		case "file", "filename":
+			filter.Filename = value
+		case "session":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return filter, fmt.Errorf("session: invalid id %q", value)
+			}
+			filter.SessionID = id
+		case "sort":
+			if _, ok := sortClauses[value]; !ok {
+				return filter, fmt.Errorf("sort: unknown order %q", value)
+			}
+			filter.Sort = value
+		default:
+			return filter, fmt.Errorf("unknown search key %q", key)
+		}
+	}
+
+	if len(words) > 0 && filter.Filename == "" {
+		filter.Filename = strings.Join(words, " ")
+	}
+	return filter, nil
+}
+
+// splitQuery splits on whitespace outside of double quotes.
+func splitQuery(query string) ([]string, error) {
+	var terms []string
+	var current strings.Builder
+	inQuotes := false
+
+	for _, r := range query {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			current.WriteRune(r)
+		case !inQuotes && (r == ' ' || r == '\t' || r == '\n'):
+			if current.Len() > 0 {
+				terms = append(terms, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quote in query")
+	}
+	if current.Len() > 0 {
+		terms = append(terms, current.String())
+	}
+	return terms, nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// parseDateRange parses "A..B", "A..", "..B" or "A" where each bound is a
+// year, month or day. The range covers the whole of both bound periods.
+func parseDateRange(value string) (*time.Time, *time.Time, error) {
+	fromStr, toStr, isRange := strings.Cut(value, "..")
+	if !isRange {
+		toStr = fromStr
+	}
+
+	var from, to *time.Time
+	if fromStr != "" {
+		start, _, err := parseDatePeriod(fromStr)
+		if err != nil {
+			return nil, nil, err
+		}
+		from = &start
+	}
+	if toStr != "" {
+		_, end, err := parseDatePeriod(toStr)
+		if err != nil {
+			return nil, nil, err
+		}
+		to = &end
+	}
+	if from == nil && to == nil {
+		return nil, nil, fmt.Errorf("date: empty range")
+	}
+	if from != nil && to != nil && !to.After(*from) {
+		return nil, nil, fmt.Errorf("date: %s is after %s", fromStr, toStr)
+	}
+	return from, to, nil
+}
+
+// parseDatePeriod returns the start and the (exclusive) end of a year, month
+// or day.
+func parseDatePeriod(s string) (time.Time, time.Time, error) {
+	layouts := []struct {
+		layout string
+		years  int
+		months int
+		days   int
+	}{
+		{"2006-01-02", 0, 0, 1},
+		{"2006-01", 0, 1, 0},
+		{"2006", 1, 0, 0},
+	}
+	for _, l := range layouts {
+		if t, err := time.ParseInLocation(l.layout, s, time.Local); err == nil {
+			return t, t.AddDate(l.years, l.months, l.days), nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("date: invalid date %q, expected YYYY, YYYY-MM or YYYY-MM-DD", s)
+}
+
+func parseYesNo(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes", "true", "1":
+		return true, nil
+	case "no", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected yes or no, got %q", s)
+}
+
+// parseNear turns "lat,lon[,radius]" into a bounding box around the point.
+// The radius defaults to 10km and may carry a "km" or "m" suffix.
+func parseNear(value string) (*BoundingBox, error) {
+	parts := strings.Split(value, ",")
+	radiusKm := 10.0
+	if len(parts) == 3 {
+		r := strings.ToLower(strings.TrimSpace(parts[2]))
+		scale := 1.0
+		if strings.HasSuffix(r, "km") {
+			r = strings.TrimSuffix(r, "km")
+		} else if strings.HasSuffix(r, "m") {
+			r = strings.TrimSuffix(r, "m")
+			scale = 0.001
+		}
+		v, err := strconv.ParseFloat(r, 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("near: invalid radius %q", parts[2])
+		}
+		radiusKm = v * scale
+		parts = parts[:2]
+	}
+	nums, err := parseFloats(strings.Join(parts, ","), 2)
+	if err != nil {
+		return nil, fmt.Errorf("near: %w", err)
+	}
+	lat, lon := nums[0], nums[1]
+
+	// One degree of latitude is ~111km; longitude degrees shrink towards the poles.
+	dLat := radiusKm / 111.0
+	dLon := 180.0
+	if c := math.Cos(lat * math.Pi / 180); c > 0.01 {
+		dLon = math.Min(radiusKm/(111.0*c), 180)
+	}
+
+	minLon, maxLon := lon-dLon, lon+dLon
+	if minLon < -180 {
+		minLon += 360
+	}
+	if maxLon > 180 {
+		maxLon -= 360
+	}
+	if dLon >= 180 {
+		minLon, maxLon = -180, 180
+	}
+	return &BoundingBox{
+		MinLat: math.Max(lat-dLat, -90),
+		MaxLat: math.Min(lat+dLat, 90),
+		MinLon: minLon,
+		MaxLon: maxLon,
+	}, nil
+}
+
+// parseRange parses "A..B", "A..", "..B" or "A" into an inclusive range.
+// Bounds may be written as fractions such as 1/250.
+func parseRange(value string) (*Range, error) {
+	minStr, maxStr, isRange := strings.Cut(value, "..")
+	if !isRange {
+		maxStr = minStr
+	}
+
+	var rng Range
+	for _, b := range []struct {
+		s   string
+		dst **float64
+	}{{minStr, &rng.Min}, {maxStr, &rng.Max}} {
+		if b.s == "" {
+			continue
+		}
+		v, err := parseNumber(b.s)
+		if err != nil {
+			return nil, err
+		}
+		*b.dst = &v
+	}
+	if rng.Min == nil && rng.Max == nil {
+		return nil, fmt.Errorf("empty range")
+	}
+	return &rng, nil
+}
+
+// parseNumber parses a decimal number or a fraction like 1/250.
+func parseNumber(s string) (float64, error) {
+	num, denom, isFraction := strings.Cut(s, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	if !isFraction {
+		return n, nil
+	}
+	d, err := strconv.ParseFloat(denom, 64)
+	if err != nil || d == 0 {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return n / d, nil
+}
+
+func parseFloats(value string, n int) ([]float64, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("expected %d comma-separated numbers, got %q", n, value)
+	}
+	nums := make([]float64, len(parts))
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", p)
+		}
+		nums[i] = v
+	}
+	return nums, nil
+}
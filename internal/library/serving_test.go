@@ -1,6 +1,8 @@
 package library
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	_ "image/jpeg"
 	"net/http"
@@ -8,7 +10,9 @@ import (
 	"os"
 	"path/filepath"
 	"photoo/internal/db"
+	"photoo/internal/models"
 	"testing"
+	"time"
 )
 
 func TestThumbnailHTTPHandler(t *testing.T) {
@@ -41,7 +45,7 @@ func TestThumbnailConcurrency(t *testing.T) {
 	projectRoot := filepath.Dir(filepath.Dir(wd))
 	testPhoto := filepath.Join(projectRoot, "test_data", "source_digital_camera", "RIMG0018.JPG")
 
-	photo, err := manager.ImportPhoto(testPhoto)
+	photo, err := importPhoto(manager, testPhoto)
 	if err != nil {
 		t.Fatalf("Failed to import photo: %v", err)
 	}
@@ -73,3 +77,66 @@ func TestThumbnailConcurrency(t *testing.T) {
 		}
 	}
 }
+
+// writeTestVideo writes a minimal MP4: 3 seconds long, recorded on
+// 2022-06-01 10:00:00 UTC, without any media data. A non-empty contentID is
+// stored the way iPhones tag the video of a Live Photo.
+func writeTestVideo(t *testing.T, path, contentID string) {
+	t.Helper()
+	box := func(typ string, payload ...[]byte) []byte {
+		b := binary.BigEndian.AppendUint32(nil, uint32(8+len(bytes.Join(payload, nil))))
+		return append(append(b, typ...), bytes.Join(payload, nil)...)
+	}
+	created := uint32(time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC).Sub(time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)).Seconds())
+	var mvhd []byte
+	for _, v := range []uint32{0, created, created, 600, 1800} {
+		mvhd = binary.BigEndian.AppendUint32(mvhd, v)
+	}
+	mvhd = append(mvhd, make([]byte, 80)...)
+	moov := box("mvhd", mvhd)
+	if contentID != "" {
+		key := "com.apple.quicktime.content.identifier"
+		moov = append(moov, box("meta",
+			box("hdlr", make([]byte, 8), []byte("mdta"), make([]byte, 13)),
+			box("keys", []byte{0, 0, 0, 0, 0, 0, 0, 1}, box("mdta", []byte(key))),
+			box("ilst", box("\x00\x00\x00\x01", box("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte(contentID)))))...)
+	}
+	data := append(box("ftyp", []byte("qt  \x00\x00\x02\x00qt  ")), box("moov", moov)...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportVideo(t *testing.T) {
+	manager := newTestManager(t)
+	srcPath := filepath.Join(t.TempDir(), "IMG_0042.MOV")
+	writeTestVideo(t, srcPath, "")
+
+	photo, err := importPhoto(manager, srcPath)
+	if err != nil {
+		t.Fatalf("ImportPhoto failed: %v", err)
+	}
+	stored, _ := manager.GetPhoto(photo.ID)
+	if stored == nil || stored.MediaType != models.MediaVideo || stored.Duration == nil || *stored.Duration != 3 ||
+		stored.DateTakenUTC.Unix() != time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC).Unix() {
+		t.Fatalf("Unexpected video %+v", stored)
+	}
+	for query, expected := range map[string]int{"type:video": 1, "type:photo": 0} {
+		filter, _ := ParseQuery(query)
+		photos, err := manager.SearchPhotos(filter)
+		if err != nil || len(photos) != expected {
+			t.Errorf("Query %q: expected %d photos, got %d (%v)", query, expected, len(photos), err)
+		}
+	}
+
+	// Without a cover image a placeholder is served rather than an error
+	handler := NewThumbnailHandler(manager.LibraryPath)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/thumbnail/"+stored.Filename, nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("X-Thumbnail-Source") != "placeholder" {
+		t.Errorf("Expected a placeholder thumbnail, got %d %v", rr.Code, rr.Header())
+	}
+	if _, err := os.Stat(thumbnailCachePath(manager.LibraryPath, stored.Filename)); err == nil {
+		t.Error("Expected the placeholder not to be cached")
+	}
+}
@@ -0,0 +1,228 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"photoo/internal/models"
+)
+
+const sessionSelect = `SELECT s.id, s.source_path, s.status, s.started_at, s.finished_at,
+	COUNT(i.id),
+	COALESCE(SUM(i.status = 'pending'), 0),
+	COALESCE(SUM(i.status = 'imported'), 0),
+	COALESCE(SUM(i.status = 'duplicate'), 0),
+	COALESCE(SUM(i.status = 'error'), 0)
+	FROM import_sessions s LEFT JOIN import_session_items i ON i.session_id = s.id`
+
+// ResumeImport continues an import session that was cancelled or interrupted.
+// Only items that were never processed are imported; their outcomes are
+// added to the session's existing counts.
+func (m *Manager) ResumeImport(ctx context.Context, sessionID int64, opts ImportOptions) (ImportProgress, error) {
+	session, err := m.GetImportSession(sessionID)
+	if err != nil {
+		return ImportProgress{}, err
+	}
+	if session.Status == models.SessionCompleted {
+		return ImportProgress{}, fmt.Errorf("import session %d is already completed", sessionID)
+	}
+
+	rows, err := m.DB.Query("SELECT source_path FROM import_session_items WHERE session_id = ? AND status = ? ORDER BY id", sessionID, models.ItemPending)
+	if err != nil {
+		return ImportProgress{}, fmt.Errorf("failed to load pending items: %w", err)
+	}
+	var pending []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return ImportProgress{}, err
+		}
+		pending = append(pending, path)
+	}
+	rows.Close()
+
+	if err := m.setSessionStatus(sessionID, models.SessionRunning); err != nil {
+		return ImportProgress{}, err
+	}
+
+	progress := ImportProgress{
+		SessionID:  sessionID,
+		Total:      session.Total,
+		Current:    session.Total - session.Pending,
+		Imported:   session.Imported,
+		Duplicates: session.Duplicates,
+		Errors:     session.Errors,
+	}
+	if parts := archiveParts(session.SourcePath); parts != nil {
+		return m.importArchives(ctx, parts, pending, progress, opts)
+	}
+	return m.runImport(ctx, jobsFor(pending), progress, opts)
+}
+
+// ListImportSessions returns all import sessions, newest first.
+func (m *Manager) ListImportSessions() ([]models.ImportSession, error) {
+	rows, err := m.DB.Query(sessionSelect + " GROUP BY s.id ORDER BY s.id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list import sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []models.ImportSession
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+	return sessions, rows.Err()
+}
+
+// GetImportSession returns a single import session with its item counts.
+func (m *Manager) GetImportSession(sessionID int64) (*models.ImportSession, error) {
+	row := m.DB.QueryRow(sessionSelect+" WHERE s.id = ? GROUP BY s.id", sessionID)
+	s, err := scanSession(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("import session %d not found", sessionID)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load import session: %w", err)
+	}
+	return s, nil
+}
+
+// GetImportSessionItems returns the items of a session. An empty status
+// returns all items.
+func (m *Manager) GetImportSessionItems(sessionID int64, status string) ([]models.ImportSessionItem, error) {
+	query := "SELECT id, session_id, source_path, status, photo_id, hash, message, processed_at FROM import_session_items WHERE session_id = ?"
+	args := []interface{}{sessionID}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id"
+
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load import session items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []models.ImportSessionItem
+	for rows.Next() {
+		var item models.ImportSessionItem
+		var photoID sql.NullInt64
+		var hash, message sql.NullString
+		var processedAt sql.NullTime
+		if err := rows.Scan(&item.ID, &item.SessionID, &item.SourcePath, &item.Status, &photoID, &hash, &message, &processedAt); err != nil {
+			return nil, err
+		}
+		if photoID.Valid {
+			item.PhotoID = &photoID.Int64
+		}
+		if processedAt.Valid {
+			item.ProcessedAt = &processedAt.Time
+		}
+		item.Hash = hash.String
+		item.Message = message.String
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// RecoverImportSessions marks sessions still flagged as running as
+// interrupted. It is meant to be called at startup, when no import can be
+// active, so that sessions cut short by a crash can be resumed. Archive
+// entries left in the staging folder are removed.
+func (m *Manager) RecoverImportSessions() error {
+	os.RemoveAll(filepath.Join(m.LibraryPath, stagingDir))
+	_, err := m.DB.Exec("UPDATE import_sessions SET status = ? WHERE status = ?", models.SessionInterrupted, models.SessionRunning)
+	if err != nil {
+		return fmt.Errorf("failed to recover import sessions: %w", err)
+	}
+	return nil
+}
+
+// createImportSession records a new session with one pending item per file.
+func (m *Manager) createImportSession(sourcePath string, candidates []string) (int64, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create import session: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO import_sessions (source_path, status) VALUES (?, ?)", sourcePath, models.SessionRunning)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create import session: %w", err)
+	}
+	sessionID, _ := res.LastInsertId()
+
+	stmt, err := tx.Prepare("INSERT INTO import_session_items (session_id, source_path, status) VALUES (?, ?, ?)")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create import session: %w", err)
+	}
+	defer stmt.Close()
+	for _, path := range candidates {
+		if _, err := stmt.Exec(sessionID, path, models.ItemPending); err != nil {
+			return 0, fmt.Errorf("failed to record import candidate %s: %w", path, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to create import session: %w", err)
+	}
+	return sessionID, nil
+}
+
+// recordSessionItem stores the outcome of a processed job.
+func (m *Manager) recordSessionItem(sessionID int64, job *importJob) error {
+	status := job.status()
+	var message sql.NullString
+	var photoID sql.NullInt64
+	switch status {
+	case models.ItemImported:
+		photoID = sql.NullInt64{Int64: job.photo.ID, Valid: true}
+	case models.ItemError:
+		message = sql.NullString{String: job.err.Error(), Valid: true}
+	}
+
+	_, err := m.DB.Exec(
+		"UPDATE import_session_items SET status = ?, photo_id = ?, hash = ?, message = ?, processed_at = ? WHERE session_id = ? AND source_path = ?",
+		status, photoID, job.hash, message, time.Now(), sessionID, job.sourcePath,
+	)
+	return err
+}
+
+func (m *Manager) setSessionStatus(sessionID int64, status string) error {
+	var finishedAt interface{}
+	if status != models.SessionRunning {
+		finishedAt = time.Now()
+	}
+	_, err := m.DB.Exec("UPDATE import_sessions SET status = ?, finished_at = ? WHERE id = ?", status, finishedAt, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to update import session %d: %w", sessionID, err)
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSession(row rowScanner) (*models.ImportSession, error) {
+	var s models.ImportSession
+	var finishedAt sql.NullTime
+	err := row.Scan(&s.ID, &s.SourcePath, &s.Status, &s.StartedAt, &finishedAt,
+		&s.Total, &s.Pending, &s.Imported, &s.Duplicates, &s.Errors)
+	if err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		s.FinishedAt = &finishedAt.Time
+	}
+	return &s, nil
+}
@@ -0,0 +1,112 @@
+package library
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"photoo/internal/models"
+	"testing"
+)
+
+func TestImportSessionRecordsOutcomes(t *testing.T) {
+	manager := newTestManager(t)
+
+	srcDir := t.TempDir()
+	os.WriteFile(filepath.Join(srcDir, "a.jpg"), []byte("photo-a"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "b.jpg"), []byte("photo-a"), 0644)
+
+	progress, err := manager.ImportFolder(context.Background(), srcDir, ImportOptions{Workers: 1})
+	if err != nil {
+		t.Fatalf("ImportFolder failed: %v", err)
+	}
+	if progress.SessionID == 0 {
+		t.Fatal("Expected a session ID")
+	}
+
+	session, err := manager.GetImportSession(progress.SessionID)
+	if err != nil {
+		t.Fatalf("GetImportSession failed: %v", err)
+	}
+	if session.Status != models.SessionCompleted {
+		t.Errorf("Expected status %q, got %q", models.SessionCompleted, session.Status)
+	}
+	if session.SourcePath != srcDir || session.Total != 2 || session.Imported != 1 || session.Duplicates != 1 || session.Pending != 0 {
+		t.Errorf("Unexpected session: %+v", session)
+	}
+	if session.FinishedAt == nil {
+		t.Error("Expected finished_at to be set")
+	}
+
+	items, err := manager.GetImportSessionItems(progress.SessionID, models.ItemImported)
+	if err != nil {
+		t.Fatalf("GetImportSessionItems failed: %v", err)
+	}
+	if len(items) != 1 || items[0].PhotoID == nil || items[0].Hash == "" {
+		t.Errorf("Unexpected imported items: %+v", items)
+	}
+
+	sessions, err := manager.ListImportSessions()
+	if err != nil {
+		t.Fatalf("ListImportSessions failed: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != progress.SessionID {
+		t.Errorf("Unexpected sessions: %+v", sessions)
+	}
+}
+
+func TestResumeInterruptedImport(t *testing.T) {
+	manager := newTestManager(t)
+
+	srcDir := t.TempDir()
+	var candidates []string
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		path := filepath.Join(srcDir, name)
+		os.WriteFile(path, []byte("photo-"+name), 0644)
+		candidates = append(candidates, path)
+	}
+
+	// Simulate a crash: the session was recorded and one file processed,
+	// but the import never finished.
+	sessionID, err := manager.createImportSession(srcDir, candidates)
+	if err != nil {
+		t.Fatalf("createImportSession failed: %v", err)
+	}
+	job := &importJob{sourcePath: candidates[0]}
+	for _, stage := range manager.importStages(1) {
+		if err := stage.run(job); err != nil {
+			t.Fatalf("stage %s failed: %v", stage.name, err)
+		}
+	}
+	manager.releaseJob(job)
+	if err := manager.recordSessionItem(sessionID, job); err != nil {
+		t.Fatalf("recordSessionItem failed: %v", err)
+	}
+
+	if err := manager.RecoverImportSessions(); err != nil {
+		t.Fatalf("RecoverImportSessions failed: %v", err)
+	}
+	session, _ := manager.GetImportSession(sessionID)
+	if session.Status != models.SessionInterrupted || session.Pending != 2 {
+		t.Fatalf("Unexpected session after recovery: %+v", session)
+	}
+
+	var started int
+	progress, err := manager.ResumeImport(context.Background(), sessionID, ImportOptions{
+		OnStart: func(total int) { started = total },
+	})
+	if err != nil {
+		t.Fatalf("ResumeImport failed: %v", err)
+	}
+	if started != 3 || progress.Current != 3 || progress.Imported != 3 {
+		t.Errorf("Unexpected resume progress: started=%d %+v", started, progress)
+	}
+
+	session, _ = manager.GetImportSession(sessionID)
+	if session.Status != models.SessionCompleted || session.Pending != 0 || session.Imported != 3 {
+		t.Errorf("Unexpected session after resume: %+v", session)
+	}
+
+	if _, err := manager.ResumeImport(context.Background(), sessionID, ImportOptions{}); err == nil {
+		t.Error("Expected error when resuming a completed session")
+	}
+}
@@ -0,0 +1,35 @@
+package library
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Keys of the settings table
+const (
+	settingNamingTemplate = "naming_template"
+	settingImportMode     = "import_mode"
+	settingWatchFolders   = "watch_folders"
+	settingWriteSidecars  = "write_sidecars"
+)
+
+// setting returns the stored value of key, or def if it was never set.
+func (m *Manager) setting(key, def string) (string, error) {
+	var value string
+	err := m.DB.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return def, nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to load setting %s: %w", key, err)
+	}
+	return value, nil
+}
+
+// saveSetting stores value under key.
+func (m *Manager) saveSetting(key, value string) error {
+	_, err := m.DB.Exec("INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value", key, value)
+	if err != nil {
+		return fmt.Errorf("failed to save setting %s: %w", key, err)
+	}
+	return nil
+}
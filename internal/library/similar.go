@@ -0,0 +1,125 @@
+package library
+
+import (
+	"image"
+	"math/bits"
+
+	"github.com/disintegration/imaging"
+)
+
+// Near-duplicates, such as a photo resaved at a lower quality or resized by
+// a messenger, have different bytes but look the same. They are found by a
+// perceptual hash: a difference hash (dHash) records for a 9x8 greyscale
+// version of the image whether each pixel is brighter than its right
+// neighbour. Similar images differ in few of those 64 bits.
+
+// nearDuplicateDistance is the largest Hamming distance between the
+// perceptual hashes of two photos considered near-duplicates.
+const nearDuplicateDistance = 6
+
+// perceptualHash returns the dHash of img.
+func perceptualHash(img image.Image) uint64 {
+	small := imaging.Grayscale(imaging.Resize(img, 9, 8, imaging.Box))
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		row := small.Pix[y*small.Stride:]
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if row[x*4] > row[(x+1)*4] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// hammingDistance returns the number of bits in which a and b differ.
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// bkTree indexes perceptual hashes for Hamming distance searches. Each
+// child of a node sits under the distance between its hash and the node's,
+// so a search only descends into children whose distance can be within
+// range (triangle inequality).
+type bkTree struct {
+	root *bkNode
+}
+
+type bkNode struct {
+	hash     uint64
+	ids      []int64 // photos with this hash; empty once they are all removed
+	children map[int]*bkNode
+}
+
+// bkMatch is a photo found by a search.
+type bkMatch struct {
+	ID       int64
+	Distance int
+}
+
+// add indexes photo id under hash.
+func (t *bkTree) add(hash uint64, id int64) {
+	if t.root == nil {
+		t.root = &bkNode{hash: hash, ids: []int64{id}}
+		return
+	}
+	node := t.root
+	for {
+		d := hammingDistance(hash, node.hash)
+		if d == 0 {
+			node.ids = append(node.ids, id)
+			return
+		}
+		child, ok := node.children[d]
+		if !ok {
+			if node.children == nil {
+				node.children = map[int]*bkNode{}
+			}
+			node.children[d] = &bkNode{hash: hash, ids: []int64{id}}
+			return
+		}
+		node = child
+	}
+}
+
+// remove drops photo id from the index. The node stays, as its children are
+// placed relative to it.
+func (t *bkTree) remove(hash uint64, id int64) {
+	for node := t.root; node != nil; node = node.children[hammingDistance(hash, node.hash)] {
+		if node.hash == hash {
+			for i, existing := range node.ids {
+				if existing == id {
+					node.ids = append(node.ids[:i], node.ids[i+1:]...)
+					return
+				}
+			}
+			return
+		}
+	}
+}
+
+// search returns the photos whose hash is within maxDistance of hash.
+func (t *bkTree) search(hash uint64, maxDistance int) []bkMatch {
+	if t.root == nil {
+		return nil
+	}
+	var matches []bkMatch
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := hammingDistance(hash, node.hash)
+		if d <= maxDistance {
+			for _, id := range node.ids {
+				matches = append(matches, bkMatch{ID: id, Distance: d})
+			}
+		}
+		for cd, child := range node.children {
+			if cd >= d-maxDistance && cd <= d+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+	return matches
+}
@@ -0,0 +1,74 @@
+package library
+
+import (
+	"bytes"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestPerceptualHash(t *testing.T) {
+	original, err := imaging.Open("../../test_data/source_digital_camera/RIMG0018.JPG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := imaging.Open("../../test_data/source_digital_camera/RIMG0020.JPG")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A messenger-style copy: resized and heavily recompressed
+	var buf bytes.Buffer
+	imaging.Encode(&buf, imaging.Resize(original, 640, 0, imaging.Lanczos), imaging.JPEG, imaging.JPEGQuality(50))
+	resaved, err := imaging.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := hammingDistance(perceptualHash(original), perceptualHash(resaved)); d > nearDuplicateDistance {
+		t.Errorf("Expected the resaved copy to be a near-duplicate, distance %d", d)
+	}
+	if d := hammingDistance(perceptualHash(original), perceptualHash(other)); d <= nearDuplicateDistance {
+		t.Errorf("Expected different photos to be far apart, distance %d", d)
+	}
+}
+
+func TestBKTreeSearch(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var tree bkTree
+	hashes := map[int64]uint64{}
+	for id := int64(1); id <= 2000; id++ {
+		h := r.Uint64()
+		if id%10 == 0 {
+			// Some close to an earlier hash, some equal
+			h = hashes[id-5] ^ uint64(1)<<(id%64) ^ uint64(1)<<(id%7)
+		}
+		tree.add(h, id)
+		hashes[id] = h
+	}
+	tree.remove(hashes[15], 15)
+	delete(hashes, 15)
+
+	for _, query := range []uint64{hashes[5], hashes[100] ^ 0b111, r.Uint64()} {
+		var want []int64
+		for id, h := range hashes {
+			if hammingDistance(query, h) <= 4 {
+				want = append(want, id)
+			}
+		}
+		var got []int64
+		for _, m := range tree.search(query, 4) {
+			if m.Distance != hammingDistance(query, hashes[m.ID]) {
+				t.Errorf("Wrong distance %d for photo %d", m.Distance, m.ID)
+			}
+			got = append(got, m.ID)
+		}
+		slices.Sort(want)
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("Query %x: expected %v, got %v", query, want, got)
+		}
+	}
+}
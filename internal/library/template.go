@@ -0,0 +1,189 @@
+package library
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Photos are stored at a path built from a naming template such as
+// "{year}/{month}-{monthname}/{date}_{time}_{camera}{counter}.{ext}".
+// Slashes separate folders, and placeholders are replaced by the capture
+// date, camera and original name of the photo. Every template ends with
+// ".{ext}", optionally preceded by "{counter}", which is where "_1", "_2",
+// ... go when the name is already taken.
+
+// DefaultNamingTemplate is the layout of libraries that never chose one.
+const DefaultNamingTemplate = "{year}/{month}/{day}/{date}_{time}{counter}.{ext}"
+
+// namingFields are the values a naming template is expanded with.
+type namingFields struct {
+	date         time.Time // wall clock of capture
+	cameraMake   string
+	cameraModel  string
+	originalPath string
+}
+
+// namingPlaceholders maps placeholder names to their values.
+var namingPlaceholders = map[string]func(namingFields) string{
+	"year":      func(f namingFields) string { return f.date.Format("2006") },
+	"month":     func(f namingFields) string { return f.date.Format("01") },
+	"monthname": func(f namingFields) string { return f.date.Format("January") },
+	"day":       func(f namingFields) string { return f.date.Format("02") },
+	"date":      func(f namingFields) string { return f.date.Format("2006-01-02") },
+	"time":      func(f namingFields) string { return f.date.Format("15-04-05") },
+	"hour":      func(f namingFields) string { return f.date.Format("15") },
+	"minute":    func(f namingFields) string { return f.date.Format("04") },
+	"second":    func(f namingFields) string { return f.date.Format("05") },
+	"camera":    func(f namingFields) string { return f.cameraModel },
+	"make":      func(f namingFields) string { return f.cameraMake },
+	"original": func(f namingFields) string {
+		base := filepath.Base(f.originalPath)
+		return strings.TrimSuffix(base, filepath.Ext(base))
+	},
+}
+
+var placeholderPattern = regexp.MustCompile(`\{(\w*)\}`)
+
+// unsafeNameChars are not allowed in file names on at least one platform.
+const unsafeNameChars = `\:*?"<>|`
+
+// namingTemplate is a validated naming template, without its
+// "{counter}.{ext}" suffix.
+type namingTemplate struct {
+	source string
+	stem   string
+}
+
+// parseNamingTemplate validates a naming template.
+func parseNamingTemplate(source string) (*namingTemplate, error) {
+	source = strings.TrimSpace(source)
+	if source == "" {
+		return nil, fmt.Errorf("naming template is empty")
+	}
+	stem, ok := strings.CutSuffix(source, ".{ext}")
+	if !ok {
+		return nil, fmt.Errorf("naming template must end with .{ext}")
+	}
+	stem = strings.TrimSuffix(stem, "{counter}")
+
+	if strings.HasPrefix(stem, "/") {
+		return nil, fmt.Errorf("naming template must be relative to the library")
+	}
+	for _, dir := range strings.Split(stem, "/") {
+		if dir == "" || dir == "." || dir == ".." {
+			return nil, fmt.Errorf("naming template has an empty or relative folder: %q", source)
+		}
+	}
+	for _, match := range placeholderPattern.FindAllStringSubmatch(stem, -1) {
+		switch name := match[1]; {
+		case name == "counter" || name == "ext":
+			return nil, fmt.Errorf("{%s} may only appear at the end, as {counter}.{ext}", name)
+		case namingPlaceholders[name] == nil:
+			return nil, fmt.Errorf("unknown placeholder {%s}", name)
+		}
+	}
+	literal := placeholderPattern.ReplaceAllString(stem, "")
+	if strings.ContainsAny(literal, "{}") {
+		return nil, fmt.Errorf("naming template has an unmatched brace")
+	}
+	if strings.ContainsAny(literal, unsafeNameChars) {
+		return nil, fmt.Errorf("naming template may not contain any of %s", unsafeNameChars)
+	}
+	return &namingTemplate{source: source, stem: stem}, nil
+}
+
+// location returns the folder (relative to the library, with forward
+// slashes) and the base filename the template gives a photo.
+func (t *namingTemplate) location(f namingFields) (subDir, base string) {
+	path := placeholderPattern.ReplaceAllStringFunc(t.stem, func(p string) string {
+		return sanitizeName(namingPlaceholders[p[1:len(p)-1]](f))
+	})
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return ".", path
+}
+
+// sanitizeName makes a placeholder value safe to use in a path: separators
+// and characters that are invalid on some platforms become dashes, and
+// empty values become "unknown".
+func sanitizeName(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r == '/' || r < ' ' || strings.ContainsRune(unsafeNameChars, r) {
+			return '-'
+		}
+		return r
+	}, value)
+	value = strings.Trim(value, " .")
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+// atLocation reports whether filename (relative to the library) is at
+// subDir/base, allowing for the "_N" counter added on collisions.
+func atLocation(filename, subDir, base string) bool {
+	if filepath.ToSlash(filepath.Dir(filename)) != subDir {
+		return false
+	}
+	currentBase := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	if currentBase == base {
+		return true
+	}
+	counter, ok := strings.CutPrefix(currentBase, base+"_")
+	return ok && counter != "" && strings.Trim(counter, "0123456789") == ""
+}
+
+// NamingTemplate returns the template new photos are filed with.
+func (m *Manager) NamingTemplate() string {
+	return m.namingTemplate().source
+}
+
+func (m *Manager) namingTemplate() *namingTemplate {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.naming
+}
+
+// ValidateNamingTemplate reports what is wrong with template, if anything.
+func ValidateNamingTemplate(template string) error {
+	_, err := parseNamingTemplate(template)
+	return err
+}
+
+// SetNamingTemplate validates template and saves it as the layout for
+// photos imported from now on. Photos already in the library keep their
+// place until ReorganizeLibrary moves them.
+func (m *Manager) SetNamingTemplate(template string) error {
+	t, err := parseNamingTemplate(template)
+	if err != nil {
+		return err
+	}
+	if err := m.saveSetting(settingNamingTemplate, t.source); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.naming = t
+	return nil
+}
+
+// loadNamingTemplate reads the saved template. An invalid one, which can
+// only come from editing the database by hand, falls back to the default.
+func (m *Manager) loadNamingTemplate() error {
+	source, err := m.setting(settingNamingTemplate, DefaultNamingTemplate)
+	if err != nil {
+		return err
+	}
+	t, err := parseNamingTemplate(source)
+	if err != nil {
+		fmt.Printf("[BACKEND] Ignoring saved naming template: %v\n", err)
+		t, _ = parseNamingTemplate(DefaultNamingTemplate)
+	}
+	m.naming = t
+	return nil
+}
@@ -0,0 +1,52 @@
+package library
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseNamingTemplate(t *testing.T) {
+	for _, bad := range []string{
+		"",
+		"{year}/{date}",                  // no extension
+		"/{year}/{date}.{ext}",           // absolute
+		"{year}//{date}.{ext}",           // empty folder
+		"../{date}.{ext}",                // outside the library
+		"{year}/{counter}{date}.{ext}",   // counter not at the end
+		"{year}/{date}_{lens}.{ext}",     // unknown placeholder
+		"{year}/{date.{ext}",             // unmatched brace
+		"{year}/{date}:{time}.{ext}",     // invalid on Windows
+		`{year}\{date}.{ext}`,            // backslash separator
+		"{year}/{month}/{counter}.{ext}", // empty file name
+	} {
+		if _, err := parseNamingTemplate(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+
+	tmpl, err := parseNamingTemplate("{year}/{month}-{monthname}/{date}_{time}_{camera}{counter}.{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := namingFields{
+		date:         time.Date(2024, 3, 9, 14, 5, 7, 0, time.UTC),
+		cameraModel:  "DSC/RX100: II",
+		originalPath: "/photos/IMG_0001.JPG",
+	}
+	subDir, base := tmpl.location(f)
+	if subDir != "2024/03-March" || base != "2024-03-09_14-05-07_DSC-RX100- II" {
+		t.Errorf("Unexpected location %s / %s", subDir, base)
+	}
+
+	f.cameraModel = ""
+	tmpl, _ = parseNamingTemplate("{make}_{original}.{ext}")
+	if subDir, base := tmpl.location(f); subDir != "." || base != "unknown_IMG_0001" {
+		t.Errorf("Unexpected location %s / %s", subDir, base)
+	}
+
+	// The default reproduces the historical layout
+	tmpl, _ = parseNamingTemplate(DefaultNamingTemplate)
+	if subDir, base := tmpl.location(f); subDir != "2024/03/09" || base != "2024-03-09_14-05-07" {
+		t.Errorf("Unexpected default location %s / %s", subDir, base)
+	}
+}
@@ -1,8 +1,11 @@
 package library
 
 import (
+	"bytes"
 	"fmt"
 	"image"
+	"image/color"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -10,6 +13,8 @@ import (
 	"sync"
 	"time"
 
+	"photoo/internal/exif"
+
 	"github.com/disintegration/imaging"
 )
 
@@ -20,6 +25,9 @@ type ThumbnailHandler struct {
 	mu          sync.Mutex
 	semaphore   chan struct{}
 	locks       sync.Map // Map of filename -> *sync.Mutex
+	// HEIFDecoder decodes HEIC images; without one they get a placeholder
+	// unless their EXIF holds a JPEG thumbnail.
+	HEIFDecoder HEIFDecoder
 }
 
 func NewThumbnailHandler(libraryPath string) *ThumbnailHandler {
@@ -50,6 +58,11 @@ func (h *ThumbnailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if trimmed := strings.TrimLeft(path, "/"); strings.HasPrefix(trimmed, "motion/") {
+		h.serveMotion(w, r, strings.TrimPrefix(trimmed, "motion/"))
+		return
+	}
+
 	// Support both /thumbnail/ and thumbnail/
 	if !strings.HasPrefix(path, "/thumbnail/") && !strings.HasPrefix(path, "thumbnail/") {
 		return
@@ -68,11 +81,7 @@ func (h *ThumbnailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// replace slashes with underscores for flat cache
-	safeName := strings.ReplaceAll(filename, "/", "_")
-	safeName = strings.ReplaceAll(safeName, "\\", "_")
-	cacheFilename := safeName + ".thumb.jpg"
-	cacheFullPath := filepath.Join(h.cachePath, cacheFilename)
+	cacheFullPath := thumbnailCachePath(h.libraryPath, filename)
 
 	// 1. Check Cache First
 	if _, err := os.Stat(cacheFullPath); err == nil {
@@ -114,15 +123,15 @@ func (h *ThumbnailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	/* ext := strings.ToLower(filepath.Ext(filename)) */
-	var src image.Image
-	var err error
+	if isVideoFile(filename) {
+		h.serveVideoThumbnail(w, fullPath, cacheFullPath)
+		return
+	}
 
-	/* HEIC support disabled on ARM64 if it fails to build */
-	src, err = imaging.Open(fullPath)
+	src, err := openImage(fullPath, h.HEIFDecoder)
 	if err != nil {
 		fmt.Printf("[BACKEND] Error: Imaging open failed for %s: %v\n", fullPath, err)
-		http.Error(w, fmt.Sprintf("failed to open image: %v", err), http.StatusInternalServerError)
+		h.servePlaceholder(w, err)
 		return
 	}
 
@@ -144,3 +153,156 @@ func (h *ThumbnailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("failed to encode thumbnail: %v", err), http.StatusInternalServerError)
 	}
 }
+
+// thumbnailCachePath returns where the thumbnail of a library file is cached.
+// The cache is flat: slashes in the library-relative filename become
+// underscores.
+func thumbnailCachePath(libraryPath, filename string) string {
+	safeName := strings.ReplaceAll(filename, "/", "_")
+	safeName = strings.ReplaceAll(safeName, "\\", "_")
+	return filepath.Join(libraryPath, ".thumbnails", safeName+".thumb.jpg")
+}
+
+// openImage decodes the still image at path, or the preview embedded in it
+// for raw and HEIF files.
+func openImage(path string, heif HEIFDecoder) (image.Image, error) {
+	switch {
+	case isRawFile(path):
+		return openRawPreview(path)
+	case isHEIFFile(path):
+		return openHEIFPreview(path, heif)
+	}
+	return imaging.Open(path)
+}
+
+// openRawPreview decodes the JPEG preview embedded in a raw file; the sensor
+// data itself is never decoded.
+func openRawPreview(path string) (image.Image, error) {
+	preview, err := exif.RawPreview(path)
+	if err != nil {
+		return nil, err
+	}
+	if preview == nil {
+		return nil, fmt.Errorf("no embedded preview")
+	}
+	return imaging.Decode(bytes.NewReader(preview))
+}
+
+// serveVideoThumbnail serves the cover image embedded in a video. Videos
+// without one get a placeholder, which is not cached so that a real poster
+// frame can take its place later.
+func (h *ThumbnailHandler) serveVideoThumbnail(w http.ResponseWriter, fullPath, cacheFullPath string) {
+	var thumbnail image.Image
+	source := "placeholder"
+	if cover, err := exif.VideoCover(fullPath); err == nil && cover != nil {
+		if src, err := imaging.Decode(bytes.NewReader(cover)); err == nil {
+			thumbnail = imaging.Fill(src, 300, 300, imaging.Center, imaging.Lanczos)
+			source = "cover"
+			os.MkdirAll(h.cachePath, 0755)
+			if err := imaging.Save(thumbnail, cacheFullPath); err != nil {
+				fmt.Printf("[BACKEND] Failed to save thumbnail to cache: %v\n", err)
+			}
+		}
+	}
+	if thumbnail == nil {
+		thumbnail = videoPlaceholder(300)
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("X-Thumbnail-Cache", "MISS")
+	w.Header().Set("X-Thumbnail-Source", source)
+	if err := imaging.Encode(w, thumbnail, imaging.JPEG); err != nil {
+		fmt.Printf("[BACKEND] Error: Encode failed: %v\n", err)
+		http.Error(w, fmt.Sprintf("failed to encode thumbnail: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// servePlaceholder serves a placeholder for an image that cannot be decoded,
+// with the reason in the X-Thumbnail-Error header. It is not cached, so the
+// real thumbnail appears once the image can be decoded.
+func (h *ThumbnailHandler) servePlaceholder(w http.ResponseWriter, reason error) {
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("X-Thumbnail-Cache", "MISS")
+	w.Header().Set("X-Thumbnail-Source", "placeholder")
+	w.Header().Set("X-Thumbnail-Error", strings.Join(strings.Fields(reason.Error()), " "))
+	if err := imaging.Encode(w, imagePlaceholder(300), imaging.JPEG); err != nil {
+		fmt.Printf("[BACKEND] Error: Encode failed: %v\n", err)
+	}
+}
+
+// imagePlaceholder draws a mountain on a grey square.
+func imagePlaceholder(size int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	background := color.RGBA{0x50, 0x50, 0x50, 0xff}
+	symbol := color.RGBA{0xa0, 0xa0, 0xa0, 0xff}
+	// Triangle pointing up, centred, a third of the size high
+	top, height := size/3, size/3
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c := background
+			if dy := y - top; dy >= 0 && dy < height {
+				if dx := x - size/2; dx >= -dy && dx <= dy {
+					c = symbol
+				}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// videoPlaceholder draws a play symbol on a dark square.
+func videoPlaceholder(size int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	background := color.RGBA{0x30, 0x30, 0x30, 0xff}
+	symbol := color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+	// Triangle pointing right, centred, a third of the size high
+	left, top, height := size*2/5, size/3, size/3
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c := background
+			if dy := y - top; dy >= 0 && dy < height {
+				half := min(dy, height-1-dy)
+				if x >= left && x-left <= half {
+					c = symbol
+				}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// serveMotion serves the video of a Live Photo or motion photo under
+// /motion/<filename>: a video file as it is, a JPEG's embedded video as the
+// byte range it occupies. Range requests are supported for seeking.
+func (h *ThumbnailHandler) serveMotion(w http.ResponseWriter, r *http.Request, filename string) {
+	if filename == "" || strings.Contains(filename, "..") {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+	fullPath := filepath.Join(h.libraryPath, filename)
+	f, err := os.Open(fullPath)
+	if err != nil {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+
+	if isVideoFile(filename) {
+		http.ServeContent(w, r, filepath.Base(filename), info.ModTime(), f)
+		return
+	}
+	offset, length, err := exif.EmbeddedVideo(fullPath)
+	if err != nil || length == 0 {
+		http.Error(w, "no motion video", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp4")
+	http.ServeContent(w, r, "", info.ModTime(), io.NewSectionReader(f, offset, length))
+}
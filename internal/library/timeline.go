@@ -0,0 +1,86 @@
+package library
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"photoo/internal/models"
+)
+
+// Timeline granularities and the length of the date_taken prefix they group by
+var bucketKeyLengths = map[string]int{
+	"year":  4,
+	"month": 7,
+	"day":   10,
+}
+
+// TimelineBuckets returns one bucket per year, month or day, newest first,
+// with photos of unknown date in a final bucket with an empty key. Offsets
+// are positions in the date_taken DESC, id DESC order used by the grid.
+func (m *Manager) TimelineBuckets(granularity string) ([]models.TimelineBucket, error) {
+	keyLen, ok := bucketKeyLengths[granularity]
+	if !ok {
+		return nil, fmt.Errorf("unknown timeline granularity %q", granularity)
+	}
+
+	// date_taken is stored as text starting with the wall-clock date, so a
+	// prefix is the local year, month or day.
+	rows, err := m.DB.Query(`SELECT bucket, COUNT(*), MIN(first_id) FROM (
+			SELECT COALESCE(substr(date_taken, 1, ?), '') AS bucket,
+				FIRST_VALUE(id) OVER (PARTITION BY substr(date_taken, 1, ?) ORDER BY date_taken DESC, id DESC) AS first_id
+			FROM photos
+		) GROUP BY bucket ORDER BY bucket = '', bucket DESC`, keyLen, keyLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load timeline: %w", err)
+	}
+	defer rows.Close()
+
+	var buckets []models.TimelineBucket
+	offset := 0
+	for rows.Next() {
+		var b models.TimelineBucket
+		if err := rows.Scan(&b.Key, &b.Count, &b.FirstPhotoID); err != nil {
+			return nil, err
+		}
+		b.Offset = offset
+		offset += b.Count
+		parseBucketKey(&b)
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+// BucketPhotos returns a page of the photos in the bucket with the given key,
+// in timeline order.
+func (m *Manager) BucketPhotos(key string, offset, limit int) ([]models.Photo, error) {
+	var rows *sql.Rows
+	var err error
+	if key == "" {
+		rows, err = m.DB.Query("SELECT "+PhotoColumns+" FROM photos WHERE date_taken IS NULL ORDER BY id DESC LIMIT ? OFFSET ?", limit, offset)
+	} else {
+		start, end, perr := parseDatePeriod(key)
+		if perr != nil {
+			return nil, fmt.Errorf("invalid bucket key %q", key)
+		}
+		rows, err = m.DB.Query(
+			"SELECT "+PhotoColumns+" FROM photos WHERE date_taken >= ? AND date_taken < ? ORDER BY date_taken DESC, id DESC LIMIT ? OFFSET ?",
+			sqlDateTime(start), sqlDateTime(end), limit, offset,
+		)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bucket %q: %w", key, err)
+	}
+	return ScanPhotos(rows)
+}
+
+func parseBucketKey(b *models.TimelineBucket) {
+	parts := strings.Split(b.Key, "-")
+	fields := []*int{&b.Year, &b.Month, &b.Day}
+	for i, p := range parts {
+		if i < len(fields) {
+			*fields[i], _ = strconv.Atoi(p)
+		}
+	}
+}
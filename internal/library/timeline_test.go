@@ -0,0 +1,66 @@
+package library
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimelineBuckets(t *testing.T) {
+	manager := newTestManager(t)
+
+	newest := insertTestPhoto(t, manager, "c.jpg", time.Date(2023, 7, 2, 10, 0, 0, 0, time.Local))
+	insertTestPhoto(t, manager, "b.jpg", time.Date(2023, 6, 30, 23, 0, 0, 0, time.Local))
+	juneFirst := insertTestPhoto(t, manager, "a.jpg", time.Date(2023, 6, 1, 8, 0, 0, 0, time.Local))
+	older := insertTestPhoto(t, manager, "old.jpg", time.Date(2019, 12, 31, 12, 0, 0, 0, time.Local))
+	manager.DB.Exec("INSERT INTO photos (library_path, filename, hash) VALUES ('x', 'undated.jpg', 'h')")
+
+	months, err := manager.TimelineBuckets("month")
+	if err != nil {
+		t.Fatalf("TimelineBuckets failed: %v", err)
+	}
+	expected := []struct {
+		key    string
+		count  int
+		first  int64
+		offset int
+	}{
+		{"2023-07", 1, newest, 0},
+		{"2023-06", 2, 0, 1},
+		{"2019-12", 1, older, 3},
+		{"", 1, 0, 4},
+	}
+	if len(months) != len(expected) {
+		t.Fatalf("Expected %d buckets, got %+v", len(expected), months)
+	}
+	for i, e := range expected {
+		b := months[i]
+		if b.Key != e.key || b.Count != e.count || b.Offset != e.offset || (e.first != 0 && b.FirstPhotoID != e.first) {
+			t.Errorf("Bucket %d: expected %+v, got %+v", i, e, b)
+		}
+	}
+	if months[1].Year != 2023 || months[1].Month != 6 {
+		t.Errorf("Expected year/month 2023/6, got %+v", months[1])
+	}
+
+	years, _ := manager.TimelineBuckets("year")
+	if len(years) != 3 || years[0].Count != 3 {
+		t.Errorf("Unexpected year buckets: %+v", years)
+	}
+
+	if _, err := manager.TimelineBuckets("week"); err == nil {
+		t.Error("Expected error for unknown granularity")
+	}
+
+	photos, err := manager.BucketPhotos("2023-06", 1, 10)
+	if err != nil {
+		t.Fatalf("BucketPhotos failed: %v", err)
+	}
+	if len(photos) != 1 || photos[0].ID != juneFirst {
+		t.Errorf("Expected second June photo to be %d, got %+v", juneFirst, photos)
+	}
+
+	undated, err := manager.BucketPhotos("", 0, 10)
+	if err != nil || len(undated) != 1 || undated[0].Filename != "undated.jpg" {
+		t.Errorf("Expected the undated photo, got %+v, %v", undated, err)
+	}
+}
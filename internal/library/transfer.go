@@ -0,0 +1,134 @@
+package library
+
+import (
+	"fmt"
+	"os"
+)
+
+// Import modes decide how a file gets into the library. Every mode checks
+// the content hash of the result against the source before the import
+// continues.
+const (
+	// ImportCopy copies the bytes. It is the default.
+	ImportCopy = "copy"
+	// ImportMove copies the file, or links it on the same filesystem, and
+	// deletes the source once the photo is in the library.
+	ImportMove = "move"
+	// ImportHardlink links the library file to the source, so both names
+	// share one copy on disk. Edits replace the library file rather than
+	// changing it in place, so they do not reach the source.
+	ImportHardlink = "hardlink"
+	// ImportReflink makes a copy-on-write clone where the filesystem
+	// supports it (Btrfs, XFS, ...), which takes no space until either side
+	// changes.
+	ImportReflink = "reflink"
+)
+
+var importModes = map[string]bool{
+	ImportCopy:     true,
+	ImportMove:     true,
+	ImportHardlink: true,
+	ImportReflink:  true,
+}
+
+// ImportMode returns how files are brought into the library.
+func (m *Manager) ImportMode() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.importMode
+}
+
+// SetImportMode saves mode, one of the Import constants, as the way files
+// are brought into the library from now on.
+func (m *Manager) SetImportMode(mode string) error {
+	if !importModes[mode] {
+		return fmt.Errorf("unknown import mode %q", mode)
+	}
+	if err := m.saveSetting(settingImportMode, mode); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.importMode = mode
+	return nil
+}
+
+// loadImportMode reads the saved import mode.
+func (m *Manager) loadImportMode() error {
+	mode, err := m.setting(settingImportMode, ImportCopy)
+	if err != nil {
+		return err
+	}
+	if !importModes[mode] {
+		fmt.Printf("[BACKEND] Ignoring unknown import mode %q\n", mode)
+		mode = ImportCopy
+	}
+	m.importMode = mode
+	return nil
+}
+
+// transferToTemp brings src into a new temporary file in dir the way mode
+// says. Links and clones fall back to a plain copy where the filesystem
+// cannot make them, e.g. across devices.
+func transferToTemp(src, dir, mode string) (string, error) {
+	switch mode {
+	case ImportMove, ImportHardlink:
+		tempPath, err := linkToTemp(src, dir)
+		if err == nil {
+			return tempPath, nil
+		}
+		if mode == ImportHardlink {
+			fmt.Printf("[BACKEND] Cannot hardlink %s, copying instead: %v\n", src, err)
+		}
+	case ImportReflink:
+		tempPath, err := reflinkToTemp(src, dir)
+		if err == nil {
+			return tempPath, nil
+		}
+		fmt.Printf("[BACKEND] Cannot reflink %s, copying instead: %v\n", src, err)
+	}
+	return copyToTemp(src, dir)
+}
+
+// linkToTemp hardlinks src under a new temporary name in dir.
+func linkToTemp(src, dir string) (string, error) {
+	f, err := os.CreateTemp(dir, ".import-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tempPath := f.Name()
+	f.Close()
+	os.Remove(tempPath)
+	if err := os.Link(src, tempPath); err != nil {
+		return "", err
+	}
+	return tempPath, nil
+}
+
+// reflinkToTemp clones src into a new temporary file in dir.
+func reflinkToTemp(src, dir string) (string, error) {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer sourceFile.Close()
+
+	destFile, err := os.CreateTemp(dir, ".import-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tempPath := destFile.Name()
+
+	err = reflinkFile(destFile, sourceFile)
+	if err == nil {
+		err = destFile.Sync()
+	}
+	if closeErr := destFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return "", err
+	}
+	return tempPath, nil
+}
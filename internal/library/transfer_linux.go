@@ -0,0 +1,35 @@
+//go:build linux
+
+package library
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflinkFile makes dst a copy-on-write clone of src (FICLONE). Where that is
+// not supported it hands the copy to the kernel with copy_file_range, which
+// some filesystems, like NFS 4.2, turn into a clone or server-side copy.
+func reflinkFile(dst, src *os.File) error {
+	if err := unix.IoctlFileClone(int(dst.Fd()), int(src.Fd())); err == nil {
+		return nil
+	}
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	for remaining := info.Size(); remaining > 0; {
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, int(remaining), 0)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		remaining -= int64(n)
+	}
+	return nil
+}
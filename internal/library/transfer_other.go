@@ -0,0 +1,13 @@
+//go:build !linux
+
+package library
+
+import (
+	"errors"
+	"os"
+)
+
+// reflinkFile is only implemented on Linux.
+func reflinkFile(dst, src *os.File) error {
+	return errors.ErrUnsupported
+}
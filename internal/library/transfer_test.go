@@ -0,0 +1,83 @@
+package library
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportModes(t *testing.T) {
+	manager := newTestManager(t)
+	if err := manager.SetImportMode("symlink"); err == nil {
+		t.Error("Expected an unknown mode to be rejected")
+	}
+
+	for i, mode := range []string{ImportCopy, ImportMove, ImportHardlink, ImportReflink} {
+		if err := manager.SetImportMode(mode); err != nil {
+			t.Fatal(err)
+		}
+		src := filepath.Join(t.TempDir(), mode+".jpg")
+		data := append(mustRead(t, "../../test_data/source_digital_camera/RIMG0018.JPG"), byte(i))
+		os.WriteFile(src, data, 0644)
+
+		photo, err := importPhoto(manager, src)
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if got := mustRead(t, photo.LibraryPath); !bytes.Equal(got, data) {
+			t.Errorf("%s: library file differs from the source", mode)
+		}
+
+		srcInfo, srcErr := os.Stat(src)
+		libInfo, _ := os.Stat(photo.LibraryPath)
+		switch mode {
+		case ImportMove:
+			if !os.IsNotExist(srcErr) {
+				t.Errorf("Expected the source to be removed after a move, got %v", srcErr)
+			}
+		case ImportHardlink:
+			if srcErr != nil || !os.SameFile(srcInfo, libInfo) {
+				t.Errorf("Expected the library file to be a hardlink of the source")
+			}
+		default:
+			if srcErr != nil || os.SameFile(srcInfo, libInfo) {
+				t.Errorf("%s: expected the source to stay a separate file, got %v", mode, srcErr)
+			}
+		}
+	}
+
+	// The mode is saved with the library
+	reopened, err := NewManager(manager.LibraryPath, manager.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.ImportMode() != ImportReflink {
+		t.Errorf("Expected the import mode to be saved, got %s", reopened.ImportMode())
+	}
+}
+
+func TestMoveKeepsSourceOnFailure(t *testing.T) {
+	manager := newTestManager(t)
+	manager.SetImportMode(ImportMove)
+	src := filepath.Join(t.TempDir(), "photo.jpg")
+	os.WriteFile(src, mustRead(t, "../../test_data/source_digital_camera/RIMG0018.JPG"), 0644)
+
+	// A copy that does not match the hash of the source is rejected
+	job := &importJob{sourcePath: src, hash: "0000"}
+	if err := manager.metadataStage(job); err != nil {
+		t.Fatal(err)
+	}
+	job.err = manager.copyStage(job)
+	if !errors.Is(job.err, ErrCopy) {
+		t.Errorf("Expected a verification error, got %v", job.err)
+	}
+	manager.releaseJob(job)
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("Expected the source to be kept: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(job.libraryPath)); len(entries) != 0 {
+		t.Errorf("Expected no leftovers in the library, got %d files", len(entries))
+	}
+}
@@ -0,0 +1,165 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"photoo/internal/models"
+)
+
+// trashDir is the folder below the library that removed photos are moved to.
+// Each file is prefixed with the ID of its photo, so names never collide.
+// Imports treat files whose content is in the trash as duplicates, so a
+// removed photo does not come back with the next import of its folder.
+const trashDir = ".trash"
+
+// moveToTrash moves the files of p into the trash and records p there, as
+// replaced by the photo replacedBy if that is not zero. The caller removes
+// the library item itself. Files that are already gone are skipped.
+func (m *Manager) moveToTrash(tx *sql.Tx, moves *fileMoves, p *models.Photo, replacedBy int64, reason string) error {
+	dir := filepath.Join(m.LibraryPath, trashDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create trash: %w", err)
+	}
+
+	var trashPath string
+	for _, filename := range []string{p.Filename, p.MotionFilename, p.AlternateFilename} {
+		if filename == "" {
+			continue
+		}
+		name := fmt.Sprintf("%d-%s", p.ID, filepath.Base(filename))
+		if trashPath == "" {
+			trashPath = filepath.Join(trashDir, name)
+		}
+		err := moves.move(filepath.Join(m.LibraryPath, filename), filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to move %s to trash: %w", filename, err)
+		}
+	}
+
+	snapshot, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to encode photo %d: %w", p.ID, err)
+	}
+	replaced := sql.NullInt64{Int64: replacedBy, Valid: replacedBy != 0}
+	_, err = tx.Exec("INSERT INTO trash (photo_id, filename, trash_path, hash, photo, reason, replaced_by) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		p.ID, p.Filename, filepath.ToSlash(trashPath), p.Hash, string(snapshot), nullString(reason), replaced)
+	if err != nil {
+		return fmt.Errorf("failed to record photo %d in trash: %w", p.ID, err)
+	}
+	return nil
+}
+
+// trashPhoto moves photo photoID to the trash as a duplicate of replacedBy
+// and removes its library item. It is the "trashed" history field, so that
+// undo can bring the photo back with restoreFromTrash; its own edit history
+// is kept for that.
+func (m *Manager) trashPhoto(tx *sql.Tx, moves *fileMoves, photoID, replacedBy int64) error {
+	p, err := scanPhoto(tx.QueryRow("SELECT "+PhotoColumns+" FROM photos WHERE id = ?", photoID))
+	if err != nil {
+		return fmt.Errorf("failed to load photo %d: %w", photoID, err)
+	}
+	if err := m.moveToTrash(tx, moves, &p, replacedBy, fmt.Sprintf("duplicate of photo %d", replacedBy)); err != nil {
+		return err
+	}
+	if err := replaceItem(tx, photoID, replacedBy); err != nil {
+		return fmt.Errorf("failed to remove photo %d: %w", photoID, err)
+	}
+
+	// A stale index entry or a missing thumbnail after a rollback only costs
+	// a lookup or a regeneration
+	m.unindexPhoto(photoID)
+	for _, filename := range []string{p.Filename, p.MotionFilename, p.AlternateFilename} {
+		if filename != "" {
+			os.Remove(thumbnailCachePath(m.LibraryPath, filename))
+			removeEmptyDirs(filepath.Dir(filepath.Join(m.LibraryPath, filename)), m.LibraryPath)
+		}
+	}
+	return nil
+}
+
+// restoreFromTrash brings photo photoID back from the trash to the files and
+// metadata it had when it was trashed, and puts it back into a duplicate
+// group with the photo that replaced it. The hashes that are not part of the
+// snapshot are computed from the restored files.
+func (m *Manager) restoreFromTrash(tx *sql.Tx, moves *fileMoves, photoID int64) error {
+	var trashID int64
+	var snapshot string
+	var replacedBy sql.NullInt64
+	err := tx.QueryRow("SELECT id, photo, replaced_by FROM trash WHERE photo_id = ? ORDER BY id DESC LIMIT 1", photoID).
+		Scan(&trashID, &snapshot, &replacedBy)
+	if err != nil {
+		return fmt.Errorf("failed to find photo %d in trash: %w", photoID, err)
+	}
+	var p models.Photo
+	if err := json.Unmarshal([]byte(snapshot), &p); err != nil {
+		return fmt.Errorf("failed to decode photo %d: %w", photoID, err)
+	}
+
+	for _, filename := range []string{p.Filename, p.MotionFilename, p.AlternateFilename} {
+		if filename == "" {
+			continue
+		}
+		target := filepath.Join(m.LibraryPath, filename)
+		if err := m.claimPath(target, moves); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create folder for %s: %w", filename, err)
+		}
+		trashed := filepath.Join(m.LibraryPath, trashDir, fmt.Sprintf("%d-%s", p.ID, filepath.Base(filename)))
+		if err := moves.move(trashed, target); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to restore %s from trash: %w", filename, err)
+		}
+	}
+
+	p.LibraryPath = filepath.Join(m.LibraryPath, p.Filename)
+	var alternateHash string
+	if p.AlternateFilename != "" {
+		if alternateHash, err = calculateHash(filepath.Join(m.LibraryPath, p.AlternateFilename)); err != nil {
+			return fmt.Errorf("failed to hash %s: %w", p.AlternateFilename, err)
+		}
+	}
+	var phash *int64
+	if p.MediaType != models.MediaVideo {
+		if img, err := openImage(p.LibraryPath, nil); err == nil && !img.Bounds().Empty() {
+			hash := perceptualHash(img)
+			v := int64(hash)
+			phash = &v
+			m.indexPerceptualHash(p.ID, hash)
+		}
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO photos (id, original_path, library_path, filename, hash, date_taken, date_taken_utc, utc_offset, camera_make, camera_model, lens_model,
+			f_number, exposure_time, iso, focal_length, orientation, width, height, latitude, longitude, altitude, import_date, rating, title, description, favorite,
+			media_type, duration, content_identifier, motion_type, motion_filename, alternate_filename, alternate_hash, phash)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.ID, p.OriginalPath, p.LibraryPath, p.Filename, p.Hash, p.DateTaken, p.DateTakenUTC, p.UTCOffset, p.CameraMake, p.CameraModel, p.LensModel,
+		p.FNumber, p.ExposureTime, p.ISO, p.FocalLength, p.Orientation, p.Width, p.Height,
+		p.Latitude, p.Longitude, p.Altitude, p.ImportDate, p.Rating, nullString(p.Title), nullString(p.Description),
+		p.Favorite, p.MediaType, p.Duration, nullString(p.ContentIdentifier), nullString(p.MotionType), nullString(p.MotionFilename),
+		nullString(p.AlternateFilename), nullString(alternateHash), phash,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to restore photo %d: %w", photoID, err)
+	}
+	if err := setKeywords(tx, p.ID, p.Keywords); err != nil {
+		return fmt.Errorf("failed to restore keywords of photo %d: %w", photoID, err)
+	}
+	if err := setPeople(tx, p.ID, p.People); err != nil {
+		return fmt.Errorf("failed to restore people of photo %d: %w", photoID, err)
+	}
+	if replacedBy.Valid {
+		if _, err := groupNearDuplicates(tx, p.ID, []bkMatch{{ID: replacedBy.Int64}}); err != nil {
+			return fmt.Errorf("failed to restore duplicate group of photo %d: %w", photoID, err)
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM trash WHERE id = ?", trashID); err != nil {
+		return fmt.Errorf("failed to remove photo %d from trash: %w", photoID, err)
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"photoo/internal/models"
+)
+
+// Watch folders are imported from automatically: files that appear below
+// them, e.g. synced from a phone, are imported with ImportPhoto once they
+// have stopped changing. Changes are picked up with inotify on Linux and by
+// rescanning the folders elsewhere, or when inotify runs out of watches.
+// Hidden files and folders, such as Syncthing's .stversions, are ignored.
+
+// notifier reports paths below watched directories that were created or
+// written. An empty path means events were lost and everything should be
+// rescanned.
+type notifier interface {
+	add(dir string) error
+	events() <-chan string
+	close() error
+}
+
+// WatchOptions configures Watch.
+type WatchOptions struct {
+	// Settle is how long a file must stay unchanged before it is imported,
+	// so that files still being written are left alone. Zero means 2s.
+	Settle time.Duration
+	// PollInterval is how often folders are rescanned when changes cannot
+	// be watched. Zero means 10s.
+	PollInterval time.Duration
+	// BeginBatch, if set, is called before a batch of settled files is
+	// imported, and EndBatch after it. If BeginBatch fails, e.g. because
+	// another import is running, the batch waits for the next check.
+	// Cancelling the context it returns skips the rest of the batch; those
+	// files are left alone until they change.
+	BeginBatch func() (context.Context, error)
+	EndBatch   func()
+	// OnStart, OnProgress and OnEnd report every batch of settled files
+	// like a folder import.
+	OnStart    func(total int)
+	OnProgress func(ImportProgress)
+	OnEnd      func(ImportProgress)
+	// OnError, if set, receives the problems Watch carries on after: files
+	// that fail to import and folders it has to poll instead of watching.
+	OnError func(error)
+}
+
+// WatchFolders returns the folders imported from automatically.
+func (m *Manager) WatchFolders() ([]string, error) {
+	value, err := m.setting(settingWatchFolders, "[]")
+	if err != nil {
+		return nil, err
+	}
+	var folders []string
+	if err := json.Unmarshal([]byte(value), &folders); err != nil {
+		return nil, fmt.Errorf("failed to load watch folders: %w", err)
+	}
+	return folders, nil
+}
+
+// AddWatchFolder starts importing new files that appear below folder.
+func (m *Manager) AddWatchFolder(folder string) error {
+	folder, err := filepath.Abs(folder)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", folder, err)
+	}
+	if info, err := os.Stat(folder); err != nil {
+		return fmt.Errorf("cannot watch %s: %w", folder, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("cannot watch %s: not a folder", folder)
+	}
+	library, _ := filepath.Abs(m.LibraryPath)
+	if isWithin(folder, library) || isWithin(library, folder) {
+		return fmt.Errorf("cannot watch %s: it overlaps the library", folder)
+	}
+
+	folders, err := m.WatchFolders()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(folders, folder) {
+		return nil
+	}
+	return m.saveWatchFolders(append(folders, folder))
+}
+
+// RemoveWatchFolder stops watching folder.
+func (m *Manager) RemoveWatchFolder(folder string) error {
+	folders, err := m.WatchFolders()
+	if err != nil {
+		return err
+	}
+	i := slices.Index(folders, folder)
+	if i < 0 {
+		return fmt.Errorf("%s is not a watch folder", folder)
+	}
+	return m.saveWatchFolders(slices.Delete(folders, i, i+1))
+}
+
+func (m *Manager) saveWatchFolders(folders []string) error {
+	data, err := json.Marshal(folders)
+	if err != nil {
+		return fmt.Errorf("failed to save watch folders: %w", err)
+	}
+	if err := m.saveSetting(settingWatchFolders, string(data)); err != nil {
+		return err
+	}
+	// Wake a running Watch to pick up the change
+	select {
+	case m.watchChanged <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// isWithin reports whether path is dir or below it.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Watch imports the files that appear below the watch folders until ctx is
+// cancelled, and returns ctx.Err(). Files already there when it starts are
+// imported too; those in the library already are skipped as duplicates.
+func (m *Manager) Watch(ctx context.Context, opts WatchOptions) error {
+	if opts.Settle <= 0 {
+		opts.Settle = 2 * time.Second
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 10 * time.Second
+	}
+	w := &folderWatcher{m: m, opts: opts, pending: map[string]fileState{}, seen: map[string]fileState{}}
+	for {
+		folders, err := m.WatchFolders()
+		if err != nil {
+			return err
+		}
+		w.run(ctx, folders)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// fileState is what a file looked like when it was last checked.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	since   time.Time // when it was first seen like this
+}
+
+// folderWatcher collects the files that appear below the watch folders and
+// imports them once they have settled.
+type folderWatcher struct {
+	m        *Manager
+	opts     WatchOptions
+	notifier notifier             // nil when polling
+	pending  map[string]fileState // candidates waiting to settle
+	seen     map[string]fileState // files already handed to ImportPhoto, until they are gone
+}
+
+// run watches folders until ctx is cancelled or the watch folders change.
+func (w *folderWatcher) run(ctx context.Context, folders []string) {
+	if len(folders) == 0 {
+		select {
+		case <-ctx.Done():
+		case <-w.m.watchChanged:
+		}
+		return
+	}
+
+	n, err := newNotifier()
+	if err != nil {
+		// Polling is how other platforms watch, not a problem
+		if !errors.Is(err, errors.ErrUnsupported) {
+			w.report(fmt.Errorf("failed to watch folders, polling instead: %w", err))
+		}
+	} else {
+		w.notifier = n
+		defer func() {
+			n.close()
+			for range n.events() {
+				// Let the reader finish
+			}
+			w.notifier = nil
+		}()
+	}
+	for _, folder := range folders {
+		w.scan(folder)
+	}
+	w.forget(folders)
+
+	var events <-chan string
+	if w.notifier != nil {
+		events = w.notifier.events()
+	}
+	poll := time.NewTicker(w.opts.PollInterval)
+	defer poll.Stop()
+	settle := time.NewTicker(max(w.opts.Settle/4, 10*time.Millisecond))
+	defer settle.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.m.watchChanged:
+			return
+		case path, ok := <-events:
+			switch {
+			case !ok:
+				events = nil
+			case path == "":
+				for _, folder := range folders {
+					w.scan(folder)
+				}
+			default:
+				w.consider(path)
+			}
+		case <-poll.C:
+			if w.notifier == nil {
+				for _, folder := range folders {
+					w.scan(folder)
+				}
+			}
+			w.forget(folders)
+		case <-settle.C:
+			w.importSettled(ctx)
+		}
+	}
+}
+
+// scan considers every file below dir, and watches its folders.
+func (w *folderWatcher) scan(dir string) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			w.watchDir(path)
+		} else {
+			w.consider(path)
+		}
+		return nil
+	})
+}
+
+// watchDir adds dir to the notifier. If that fails, e.g. because the inotify
+// watch limit is reached, the watcher falls back to polling.
+func (w *folderWatcher) watchDir(dir string) {
+	if w.notifier == nil {
+		return
+	}
+	if err := w.notifier.add(dir); err != nil {
+		w.report(fmt.Errorf("cannot watch %s, polling instead: %w", dir, err))
+		w.notifier.close()
+		w.notifier = nil
+	}
+}
+
+// forget drops the imported files that are gone or no longer below one of
+// folders, so that seen only holds files that could still change.
+func (w *folderWatcher) forget(folders []string) {
+	for path := range w.seen {
+		_, err := os.Lstat(path)
+		if err != nil || !slices.ContainsFunc(folders, func(folder string) bool { return isWithin(path, folder) }) {
+			delete(w.seen, path)
+		}
+	}
+}
+
+// report passes err to opts.OnError.
+func (w *folderWatcher) report(err error) {
+	if w.opts.OnError != nil {
+		w.opts.OnError(err)
+	}
+}
+
+// consider records a file that may be ready for import. New folders are
+// scanned, as files may have landed in them before they were watched.
+func (w *folderWatcher) consider(path string) {
+	if strings.HasPrefix(filepath.Base(path), ".") {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if info.IsDir() {
+		w.scan(path)
+		return
+	}
+	if !info.Mode().IsRegular() || !IsSupportedFile(path) {
+		return
+	}
+	if seen, ok := w.seen[path]; ok && seen.size == info.Size() && seen.modTime.Equal(info.ModTime()) {
+		return
+	}
+	if pending, ok := w.pending[path]; ok && pending.size == info.Size() && pending.modTime.Equal(info.ModTime()) {
+		return
+	}
+	w.pending[path] = fileState{size: info.Size(), modTime: info.ModTime(), since: time.Now()}
+}
+
+// importSettled imports the pending files that have not changed for
+// opts.Settle, as one batch.
+func (w *folderWatcher) importSettled(ctx context.Context) {
+	var ready []string
+	for path, state := range w.pending {
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			delete(w.pending, path)
+		case info.Size() != state.size || !info.ModTime().Equal(state.modTime):
+			w.pending[path] = fileState{size: info.Size(), modTime: info.ModTime(), since: time.Now()}
+		case info.Size() > 0 && time.Since(state.since) >= w.opts.Settle:
+			ready = append(ready, path)
+		}
+	}
+	if len(ready) == 0 {
+		return
+	}
+	slices.Sort(ready)
+
+	batch := ctx
+	if w.opts.BeginBatch != nil {
+		var err error
+		if batch, err = w.opts.BeginBatch(); err != nil {
+			return
+		}
+		if w.opts.EndBatch != nil {
+			defer w.opts.EndBatch()
+		}
+	}
+
+	if w.opts.OnStart != nil {
+		w.opts.OnStart(len(ready))
+	}
+	progress := ImportProgress{Total: len(ready)}
+	start := time.Now()
+	for _, path := range ready {
+		if ctx.Err() != nil {
+			break
+		}
+		w.seen[path] = w.pending[path]
+		delete(w.pending, path)
+		if batch.Err() != nil {
+			continue
+		}
+
+		result, err := w.m.ImportPhoto(path)
+		progress.Current++
+		switch result.Status {
+		case models.ItemImported:
+			progress.Imported++
+		case models.ItemDuplicate:
+			progress.Duplicates++
+		default:
+			progress.Errors++
+			w.report(fmt.Errorf("failed to import %s: %w", path, err))
+		}
+		progress.LastPath = filepath.Base(path)
+		progress.Elapsed = time.Since(start)
+		if secs := progress.Elapsed.Seconds(); secs > 0 {
+			progress.FilesPerSecond = float64(progress.Current) / secs
+		}
+		if w.opts.OnProgress != nil {
+			w.opts.OnProgress(progress)
+		}
+	}
+	if w.opts.OnEnd != nil {
+		w.opts.OnEnd(progress)
+	}
+}
@@ -0,0 +1,89 @@
+//go:build linux
+
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask selects the events that can make a file ready: created,
+// finished writing, or moved in (Syncthing renames its temporary files).
+const inotifyMask = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO
+
+// inotify reports changes below watched directories with the Linux inotify
+// API. Directories are watched one by one; the watcher adds new ones as it
+// is told about them.
+type inotify struct {
+	file *os.File
+	out  chan string
+
+	mu   sync.Mutex
+	dirs map[int]string // watch descriptor to directory
+}
+
+var newNotifier = func() (notifier, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	// A non-blocking descriptor goes through the runtime poller, so Close
+	// interrupts the pending Read.
+	n := &inotify{file: os.NewFile(uintptr(fd), "inotify"), out: make(chan string, 64), dirs: map[int]string{}}
+	go n.read()
+	return n, nil
+}
+
+func (n *inotify) add(dir string) error {
+	wd, err := unix.InotifyAddWatch(int(n.file.Fd()), dir, inotifyMask)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.dirs[wd] = dir
+	return nil
+}
+
+func (n *inotify) events() <-chan string {
+	return n.out
+}
+
+func (n *inotify) close() error {
+	return n.file.Close()
+}
+
+func (n *inotify) read() {
+	defer close(n.out)
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		size, err := n.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= size; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			name := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+
+			if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+				n.out <- ""
+				continue
+			}
+			n.mu.Lock()
+			dir, ok := n.dirs[int(event.Wd)]
+			if event.Mask&unix.IN_IGNORED != 0 {
+				delete(n.dirs, int(event.Wd))
+			}
+			n.mu.Unlock()
+			if ok && len(name) > 0 {
+				n.out <- filepath.Join(dir, strings.TrimRight(string(name), "\x00"))
+			}
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package library
+
+import "errors"
+
+// newNotifier is only implemented on Linux; other platforms poll.
+var newNotifier = func() (notifier, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,201 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchFolders(t *testing.T) {
+	manager := newTestManager(t)
+	dir := t.TempDir()
+
+	if err := manager.AddWatchFolder(manager.LibraryPath); err == nil {
+		t.Error("Expected the library to be rejected as a watch folder")
+	}
+	if err := manager.AddWatchFolder(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected a missing folder to be rejected")
+	}
+	if err := manager.AddWatchFolder(dir); err != nil {
+		t.Fatal(err)
+	}
+	manager.AddWatchFolder(dir)
+	if folders, _ := manager.WatchFolders(); len(folders) != 1 || folders[0] != dir {
+		t.Errorf("Expected %s once, got %v", dir, folders)
+	}
+	if err := manager.RemoveWatchFolder(dir); err != nil {
+		t.Fatal(err)
+	}
+	if folders, _ := manager.WatchFolders(); len(folders) != 0 {
+		t.Errorf("Expected no watch folders, got %v", folders)
+	}
+}
+
+func TestWatchImportsSettledFiles(t *testing.T) {
+	t.Run("inotify", func(t *testing.T) { testWatch(t) })
+	t.Run("polling", func(t *testing.T) {
+		saved := newNotifier
+		newNotifier = func() (notifier, error) { return nil, errors.ErrUnsupported }
+		defer func() { newNotifier = saved }()
+		testWatch(t)
+	})
+}
+
+// watchRun is a Watch running in the background for a test.
+type watchRun struct {
+	mu       sync.Mutex
+	imported int
+	cancel   context.CancelFunc
+	done     chan error
+}
+
+func startWatch(t *testing.T, m *Manager, opts WatchOptions) *watchRun {
+	w := &watchRun{done: make(chan error)}
+	opts.Settle = 300 * time.Millisecond
+	opts.PollInterval = 50 * time.Millisecond
+	opts.OnEnd = func(p ImportProgress) {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.imported += p.Imported
+	}
+	var ctx context.Context
+	ctx, w.cancel = context.WithCancel(context.Background())
+	go func() { w.done <- m.Watch(ctx, opts) }()
+	t.Cleanup(func() {
+		w.cancel()
+		if err := <-w.done; err != context.Canceled {
+			t.Errorf("Expected Watch to stop with context.Canceled, got %v", err)
+		}
+	})
+	return w
+}
+
+func (w *watchRun) importedCount() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.imported
+}
+
+// waitFor waits until cond holds. The deadline is generous, as imports are
+// slow under the race detector.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Minute)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// photoWithHash reports whether the library has a photo with the content of
+// path.
+func photoWithHash(m *Manager, path string) bool {
+	hash, _ := calculateHash(path)
+	var count int
+	m.DB.QueryRow("SELECT COUNT(*) FROM photos WHERE hash = ?", hash).Scan(&count)
+	return count > 0
+}
+
+func testWatch(t *testing.T) {
+	manager := newTestManager(t)
+	dir := t.TempDir()
+	photo := mustRead(t, "../../test_data/source_digital_camera/RIMG0018.JPG")
+
+	// There before the watch starts
+	existing := filepath.Join(dir, "existing.jpg")
+	os.WriteFile(existing, mustRead(t, "../../test_data/source_digital_camera/RIMG0020.JPG"), 0644)
+	// Old versions kept by Syncthing are not imported
+	os.Mkdir(filepath.Join(dir, ".stversions"), 0755)
+	hidden := filepath.Join(dir, ".stversions", "old.jpg")
+	os.WriteFile(hidden, mustRead(t, "../../test_data/source_digital_camera/RIMG0024.JPG"), 0644)
+	manager.AddWatchFolder(dir)
+
+	w := startWatch(t, manager, WatchOptions{})
+	waitFor(t, "the existing file", func() bool { return w.importedCount() == 1 })
+	if !photoWithHash(manager, existing) {
+		t.Fatal("Expected the existing file in the library")
+	}
+
+	// A file written in two parts is imported once, complete
+	sub := filepath.Join(dir, "Camera")
+	os.Mkdir(sub, 0755)
+	path := filepath.Join(sub, "new.jpg")
+	half := filepath.Join(t.TempDir(), "half.jpg")
+	os.WriteFile(half, photo[:len(photo)/2], 0644)
+	f, _ := os.Create(path)
+	f.Write(photo[:len(photo)/2])
+	f.Sync()
+	f.Write(photo[len(photo)/2:])
+	f.Close()
+
+	waitFor(t, "the new file", func() bool { return photoWithHash(manager, path) })
+	if photoWithHash(manager, half) || w.importedCount() != 2 {
+		t.Errorf("Expected only the complete file to be imported, got %d imports", w.importedCount())
+	}
+	if photoWithHash(manager, hidden) {
+		t.Error("Expected the hidden folder to be skipped")
+	}
+}
+
+func TestWatchWaitsForOtherImports(t *testing.T) {
+	manager := newTestManager(t)
+	dir := t.TempDir()
+	copyTestPhoto(t, "RIMG0018.JPG", dir)
+	manager.AddWatchFolder(dir)
+
+	var mu sync.Mutex
+	busy, attempts := true, 0
+	w := startWatch(t, manager, WatchOptions{
+		BeginBatch: func() (context.Context, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts++
+			if busy {
+				return nil, fmt.Errorf("an import is already running")
+			}
+			return context.Background(), nil
+		},
+	})
+
+	// The settled file is held back while the other import runs
+	waitFor(t, "a blocked batch", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts >= 3
+	})
+	if w.importedCount() != 0 {
+		t.Fatal("Expected nothing to be imported while busy")
+	}
+	mu.Lock()
+	busy = false
+	mu.Unlock()
+	waitFor(t, "the held back file", func() bool { return w.importedCount() == 1 })
+}
+
+func TestWatchForgetsRemovedFiles(t *testing.T) {
+	dir, other := t.TempDir(), t.TempDir()
+	kept := filepath.Join(dir, "kept.jpg")
+	removed := filepath.Join(dir, "removed.jpg")
+	unwatched := filepath.Join(other, "unwatched.jpg")
+	for _, path := range []string{kept, removed, unwatched} {
+		os.WriteFile(path, []byte(path), 0644)
+	}
+	w := &folderWatcher{seen: map[string]fileState{kept: {}, removed: {}, unwatched: {}}}
+	os.Remove(removed)
+
+	// Files that are gone or no longer watched could not change again
+	w.forget([]string{dir})
+	if len(w.seen) != 1 {
+		t.Errorf("Expected only %s to be remembered, got %v", kept, w.seen)
+	}
+	if _, ok := w.seen[kept]; !ok {
+		t.Errorf("Expected %s to be remembered", kept)
+	}
+}
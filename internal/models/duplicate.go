@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// DuplicateGroup is a set of photos that look the same, such as an original
+// and a resized or recompressed copy of it.
+type DuplicateGroup struct {
+	ID        int64     `json:"id"`
+	KeeperID  *int64    `json:"keeper_id,omitempty"` // the copy the user chose to keep
+	CreatedAt time.Time `json:"created_at"`
+	Photos    []Photo   `json:"photos"`
+}
+
+// DuplicateHit is a file that was not imported because the library already
+// had the same bytes.
+type DuplicateHit struct {
+	ID         int64     `json:"id"`
+	SourcePath string    `json:"source_path"`
+	Hash       string    `json:"hash"`
+	PhotoID    *int64    `json:"photo_id,omitempty"`   // the library photo it duplicates
+	SessionID  *int64    `json:"session_id,omitempty"` // nil for single-file imports
+	DetectedAt time.Time `json:"detected_at"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+)
+
+// Import session statuses
+const (
+	SessionRunning     = "running"
+	SessionCompleted   = "completed"
+	SessionCancelled   = "cancelled"
+	SessionInterrupted = "interrupted"
+)
+
+// Import session item statuses
+const (
+	ItemPending   = "pending"
+	ItemImported  = "imported"
+	ItemDuplicate = "duplicate"
+	ItemError     = "error"
+)
+
+type ImportSession struct {
+	ID         int64      `json:"id"`
+	SourcePath string     `json:"source_path"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Total      int        `json:"total"`
+	Pending    int        `json:"pending"`
+	Imported   int        `json:"imported"`
+	Duplicates int        `json:"duplicates"`
+	Errors     int        `json:"errors"`
+}
+
+type ImportSessionItem struct {
+	ID          int64      `json:"id"`
+	SessionID   int64      `json:"session_id"`
+	SourcePath  string     `json:"source_path"`
+	Status      string     `json:"status"`
+	PhotoID     *int64     `json:"photo_id,omitempty"`
+	Hash        string     `json:"hash"`
+	Message     string     `json:"message"`
+	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+}
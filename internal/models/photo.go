@@ -4,17 +4,58 @@ import (
 	"time"
 )
 
+// Media types of library items
+const (
+	MediaPhoto = "photo"
+	MediaVideo = "video"
+)
+
+// Kinds of motion a still can have
+const (
+	MotionLive     = "live"     // Apple Live Photo, the video is a separate file
+	MotionEmbedded = "embedded" // Google/Samsung motion photo, the video is inside the JPEG
+)
+
 type Photo struct {
 	ID           int64     `json:"id"`
 	OriginalPath string    `json:"original_path"`
 	LibraryPath  string    `json:"library_path"`
 	Filename     string    `json:"filename"`
-	Hash         string    `json:"hash"` // SHA-256
-	DateTaken    time.Time `json:"date_taken"`
+	Hash         string    `json:"hash"`                 // SHA-256
+	DateTaken    time.Time `json:"date_taken"`           // in the capture zone; wall clock is local time
+	DateTakenUTC time.Time `json:"date_taken_utc"`       // the same instant in UTC
+	UTCOffset    *int      `json:"utc_offset,omitempty"` // seconds east of UTC, nil if the zone is unknown
+	CameraMake   string    `json:"camera_make"`
 	CameraModel  string    `json:"camera_model"`
+	LensModel    string    `json:"lens_model"`
+	FNumber      *float64  `json:"f_number,omitempty"`
+	ExposureTime *float64  `json:"exposure_time,omitempty"` // seconds
+	ISO          *int      `json:"iso,omitempty"`
+	FocalLength  *float64  `json:"focal_length,omitempty"` // mm
+	Orientation  int       `json:"orientation"`            // EXIF 1-8, 0 if unknown
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
 	Latitude     *float64  `json:"latitude,omitempty"`
 	Longitude    *float64  `json:"longitude,omitempty"`
-	ImportDate   time.Time `json:"import_date"`
+	Altitude     *float64  `json:"altitude,omitempty"`
+	Rating       int       `json:"rating"` // 1-5 stars, 0 unrated, -1 rejected
+	Title        string    `json:"title"`
+	Description  string    `json:"description"`
+	Keywords     []string  `json:"keywords"`
+	Favorite     bool      `json:"favorite"`
+	People       []string  `json:"people"`
+	MediaType    string    `json:"media_type"`         // MediaPhoto or MediaVideo
+	Duration     *float64  `json:"duration,omitempty"` // seconds, videos only
+	// ContentIdentifier links the still and video of a Live Photo.
+	ContentIdentifier string `json:"content_identifier,omitempty"`
+	MotionType        string `json:"motion_type,omitempty"` // MotionLive, MotionEmbedded or empty
+	// MotionFilename is the library-relative video of a Live Photo. It is
+	// empty for embedded motion photos, whose video is in Filename.
+	MotionFilename string `json:"motion_filename,omitempty"`
+	// AlternateFilename is the library-relative raw file of a RAW+JPEG
+	// pair; Filename is the JPEG shown for both.
+	AlternateFilename string    `json:"alternate_filename,omitempty"`
+	ImportDate        time.Time `json:"import_date"`
 }
 
 type MetadataHistory struct {
@@ -24,4 +65,21 @@ type MetadataHistory struct {
 	OldValue  string    `json:"old_value"`
 	NewValue  string    `json:"new_value"`
 	ChangedAt time.Time `json:"changed_at"`
+	BatchID   int64     `json:"batch_id"`
+	State     string    `json:"state"` // state of the batch, see EditBatch
+}
+
+// Edit batch states
+const (
+	BatchApplied   = "applied"
+	BatchUndone    = "undone"
+	BatchDiscarded = "discarded" // undone, then superseded by a new edit
+)
+
+// EditBatch groups metadata changes that are undone and redone together.
+type EditBatch struct {
+	ID          int64     `json:"id"`
+	Description string    `json:"description"`
+	State       string    `json:"state"`
+	CreatedAt   time.Time `json:"created_at"`
 }
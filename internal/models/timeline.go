@@ -0,0 +1,12 @@
+package models
+
+// TimelineBucket summarizes the photos of one year, month or day.
+type TimelineBucket struct {
+	Key          string `json:"key"` // "2023", "2023-06" or "2023-06-10"; empty for unknown dates
+	Year         int    `json:"year"`
+	Month        int    `json:"month,omitempty"`
+	Day          int    `json:"day,omitempty"`
+	Count        int    `json:"count"`
+	FirstPhotoID int64  `json:"first_photo_id"`
+	Offset       int    `json:"offset"` // photos before this bucket in timeline order
+}